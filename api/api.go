@@ -8,47 +8,150 @@ import (
 	"time"
 
 	log "github.com/gophish/gophish/logger"
+	"github.com/gophish/healthcheck/config"
 	"github.com/gophish/healthcheck/db"
 	"github.com/gophish/healthcheck/mail"
-	"github.com/gophish/healthcheck/util"
+	"github.com/gophish/healthcheck/smtp"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 )
 
-// NewAPIRouter returns a new router that implements the healthcheck API
-func NewAPIRouter() http.Handler {
+// NewAPIRouter returns a new router that implements the healthcheck API,
+// backed by the provided store
+func NewAPIRouter(store db.Store) http.Handler {
 	r := chi.NewRouter()
+	rateLimit := RateLimit(NewLimiter(config.Config.RateLimitPerHour, config.Config.RateLimitBurst))
 
+	r.Use(StoreCtx(store))
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.DefaultCompress)
-	r.Use(middleware.Timeout(60 * time.Second))
 
-	// Setup CSRF Protection
-	//r.Use(csrf.Protect([]byte(util.GenerateSecureID())))
+	// Event streams stay open until the client disconnects, so they're
+	// registered outside of the compression and timeout middleware.
+	r.Group(func(r chi.Router) {
+		r.Use(RequireAPIKey)
+		r.Use(RequireScope(db.ScopeRead))
+		r.With(MessageCtx, RequireMessageAccess).Get("/messages/{messageID}/events", GetMessageEvents)
+		r.Get("/runs/{runID}/events", GetRunEvents)
+	})
+
+	// The dashboard streams events as well, so it applies the compression
+	// and timeout middleware to its pages itself.
+	r.Route("/dashboard", dashboardRoutes)
 
-	r.Route("/messages", func(r chi.Router) {
-		r.Route("/", func(r chi.Router) {
-			r.Use(RateLimit)
-			r.Post("/", PostMessage)
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.DefaultCompress)
+		r.Use(middleware.Timeout(60 * time.Second))
+
+		// The API is authenticated with bearer tokens rather than cookies, so
+		// it doesn't need CSRF protection.
+		r.Route("/messages", func(r chi.Router) {
+			r.With(RequireAPIKey, RequireScope(db.ScopeSend), rateLimit).Post("/", PostMessage)
+			r.Route("/{messageID}", func(r chi.Router) {
+				r.With(RequireAPIKey, RequireScope(db.ScopeRead), MessageCtx, RequireMessageAccess).Get("/", GetMessage)
+				// The recipient reports a message using the link in the
+				// message itself, so this can't require an API key.
+				r.With(MessageCtx).Post("/{status}", UpdateMessage)
+			})
 		})
-		r.Route("/{messageID}", func(r chi.Router) {
-			r.Use(MessageCtx)
-			r.Post("/{status}", UpdateMessage)
+
+		r.Group(func(r chi.Router) {
+			r.Use(RequireAPIKey)
+
+			r.Route("/domains", func(r chi.Router) {
+				r.With(RequireScope(db.ScopeSend)).Post("/", PostDomain)
+				r.Route("/{domainHash}", func(r chi.Router) {
+					r.Use(RequireDomainAccess)
+					// Messages can be sent to domains which were never
+					// registered, so erasing a domain's data doesn't
+					// require the domain to exist.
+					r.With(RequireScope(db.ScopeAdmin)).Delete("/", DeleteDomain)
+					r.Group(func(r chi.Router) {
+						r.Use(DomainCtx)
+						r.With(RequireScope(db.ScopeRead)).Get("/", GetDomain)
+						r.With(RequireScope(db.ScopeSend)).Post("/verify", VerifyDomain)
+						r.Group(func(r chi.Router) {
+							r.Use(RequireVerifiedDomain)
+							r.Group(func(r chi.Router) {
+								r.Use(RequireScope(db.ScopeRead))
+								r.Get("/schedules", GetSchedules)
+								r.Get("/runs", GetRuns)
+								r.Get("/events", GetDomainPostureEvents)
+								r.Get("/report", GetReport)
+								r.Get("/export", GetDomainExport)
+							})
+							r.Group(func(r chi.Router) {
+								r.Use(RequireScope(db.ScopeSend))
+								r.Post("/schedules", PostSchedule)
+								r.Delete("/schedules/{scheduleID}", DeleteSchedule)
+								r.With(rateLimit).Post("/runs", PostRun)
+							})
+							r.Route("/webhooks", webhookRoutes)
+						})
+					})
+				})
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(RequireScope(db.ScopeRead))
+				r.Get("/runs/{runID}", GetRun)
+				r.Get("/scenarios", GetScenarios)
+			})
+
+			r.With(RequireScope(db.ScopeSend), rateLimit).Post("/protocol", PostProtocolTest)
+
+			// Endpoints spanning every domain in the organization are
+			// restricted to admins
+			r.Group(func(r chi.Router) {
+				r.Use(RequireScope(db.ScopeAdmin))
+				r.Get("/events", GetPostureEvents)
+				r.Get("/export", GetExport)
+				r.Route("/webhooks", webhookRoutes)
+				r.Route("/keys", func(r chi.Router) {
+					r.Get("/", GetAPIKeys)
+					r.Post("/", PostAPIKey)
+					r.Delete("/{keyID}", DeleteAPIKey)
+				})
+			})
+
+			r.Route("/organizations", func(r chi.Router) {
+				r.Use(RequireScope(db.ScopeSuperadmin))
+				r.Get("/", GetOrganizations)
+				r.Post("/", PostOrganization)
+				r.Get("/{orgID}", GetOrganization)
+			})
+
+			// The cache is shared by every organization
+			r.With(RequireScope(db.ScopeSuperadmin)).Get("/cache", GetCacheStats)
 		})
 	})
 
 	return r
 }
 
+// StoreCtx enriches the request context with the store used by the handlers
+func StoreCtx(store db.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), "store", store)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// getStore returns the store set by StoreCtx
+func getStore(r *http.Request) db.Store {
+	return r.Context().Value("store").(db.Store)
+}
+
 // MessageCtx enriches the request context with the requested message
 func MessageCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Get the message from the database, updating the request context
 		messageID := chi.URLParam(r, "messageID")
-		message, err := db.GetMessage(messageID)
+		message, err := getStore(r).GetMessage(messageID)
 		if err != nil {
 			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
 			return
@@ -58,15 +161,8 @@ func MessageCtx(next http.Handler) http.Handler {
 	})
 }
 
-// RateLimit is a function that limits requests to our POST endpoint by
-// receiving domain. (TODO)
-func RateLimit(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		next.ServeHTTP(w, r)
-	})
-}
-
-// PostMessage creates and sends a new message with the provided configuration.
+// PostMessage creates and sends a new message with the provided configuration,
+// or the configuration of the provided scenario.
 func PostMessage(w http.ResponseWriter, r *http.Request) {
 	m := &db.Message{}
 	err := json.NewDecoder(r.Body).Decode(m)
@@ -74,31 +170,116 @@ func PostMessage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	// Messages sent using a scenario always use its configuration, so that
+	// clients only need to know the scenario's name.
+	if m.Scenario != "" {
+		scenario, err := db.GetScenario(m.Scenario)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m.MessageConfiguration = scenario.Configuration
+	}
 	err = m.Validate()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	m.ErrorChan = make(chan error)
-	hash, err := util.DomainHashFromAddress(m.Recipient)
+	hash, err := db.DomainIDFromAddress(m.Recipient)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if !allowsDomain(r, hash) {
+		http.Error(w, ErrDomainNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
+	m.OrgID = orgID(r)
 	m.DomainHash = hash
-	db.PostMessage(m)
+	err = m.ResolveMailServer()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = getStore(r).PostMessage(m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	// Send the message to the mailer
 	mail.SendEmail(m)
 	JSONResponse(w, m, http.StatusOK)
 }
 
+// ProtocolTestRequest is the request used to run malformed SMTP protocol
+// tests against a mail server.
+type ProtocolTestRequest struct {
+	Recipient  string   `json:"recipient"`
+	MailServer string   `json:"mail_server"`
+	Tests      []string `json:"tests"`
+}
+
+// PostProtocolTest runs the requested protocol tests directly against the
+// target mail server and returns which sequences were accepted.
+func PostProtocolTest(w http.ResponseWriter, r *http.Request) {
+	p := &ProtocolTestRequest{}
+	err := json.NewDecoder(r.Body).Decode(p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if p.Recipient == "" {
+		http.Error(w, db.ErrMissingRecipient.Error(), http.StatusBadRequest)
+		return
+	}
+	if p.MailServer == "" {
+		http.Error(w, db.ErrMissingMailServer.Error(), http.StatusBadRequest)
+		return
+	}
+	hash, err := db.DomainIDFromAddress(p.Recipient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !allowsDomain(r, hash) {
+		http.Error(w, ErrDomainNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
+	e := smtp.Envelope{
+		Hostname: config.Config.EmailHostname,
+		From:     fmt.Sprintf("%s@%s", db.DefaultSender, config.Config.EmailHostname),
+		To:       p.Recipient,
+	}
+	results, err := smtp.RunProtocolTests(p.MailServer, e, p.Tests)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	JSONResponse(w, results, http.StatusOK)
+}
+
+// GetMessage returns the requested message
+func GetMessage(w http.ResponseWriter, r *http.Request) {
+	m := r.Context().Value("message").(*db.Message)
+	JSONResponse(w, m, http.StatusOK)
+}
+
 // UpdateMessage updates the status for a particular message to indicate
 // if it was received. It then returns a template with information on how to
 // update the mail server settings to block future emails with the same
-// configuration.
+// configuration. Only the received status is recorded, other statuses are
+// echoed back as they always have been.
 func UpdateMessage(w http.ResponseWriter, r *http.Request) {
+	m := r.Context().Value("message").(*db.Message)
 	status := chi.URLParam(r, "status")
+	if status == db.StatusReceived {
+		err := m.Receive()
+		if err != nil {
+			log.Error(err)
+		}
+	}
 	w.Write([]byte(status))
 }
 
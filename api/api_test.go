@@ -0,0 +1,476 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gophish/healthcheck/config"
+	"github.com/gophish/healthcheck/db"
+	"github.com/gophish/healthcheck/events"
+)
+
+const testSecretKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func TestMain(m *testing.M) {
+	// Template and migration paths are relative to the repository root
+	err := os.Chdir("..")
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func setupRouter(t *testing.T) (http.Handler, db.Store) {
+	config.Config.DBName = "sqlite3"
+	config.Config.DBPath = ":memory:"
+	config.Config.MigrationsPath = "./db/sqlite3/migrations/"
+	config.Config.SecretKey = testSecretKey
+	store, err := db.Setup()
+	if err != nil {
+		t.Fatalf("Failed setting up the database: %s", err.Error())
+	}
+	t.Cleanup(func() { store.Close() })
+	return NewAPIRouter(store), store
+}
+
+func createMessage(t *testing.T, store db.Store) *db.Message {
+	m := &db.Message{
+		OrgID:      db.DefaultOrganizationID,
+		Recipient:  "test@example.com",
+		MailServer: "localhost",
+	}
+	err := store.PostMessage(m)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating message: %v", err)
+	}
+	return m
+}
+
+// createAPIKey returns a new API key in the default organization with the
+// provided scopes.
+func createAPIKey(t *testing.T, store db.Store, scopes ...string) string {
+	key, err := store.PostAPIKey(&db.APIKey{
+		OrgID:      db.DefaultOrganizationID,
+		ScopeNames: scopes,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error when creating API key: %v", err)
+	}
+	return key
+}
+
+func TestUpdateMessage(t *testing.T) {
+	router, store := setupRouter(t)
+	m := createMessage(t, store)
+	testSuite := []struct {
+		status   string
+		expected string
+	}{
+		// Statuses other than received are echoed back without changing
+		// the message
+		{"opened", db.StatusQueued},
+		{db.StatusReceived, db.StatusReceived},
+	}
+	for _, test := range testSuite {
+		req := httptest.NewRequest("POST", "/messages/"+m.MessageID+"/"+test.status, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Unexpected status code for %s. Expected %d Got %d", test.status, http.StatusOK, w.Code)
+		}
+		body, _ := ioutil.ReadAll(w.Body)
+		if string(body) != test.status {
+			t.Fatalf("Unexpected response for %s. Expected %s Got %s", test.status, test.status, body)
+		}
+		got, err := store.GetMessage(m.MessageID)
+		if err != nil {
+			t.Fatalf("Unexpected error when getting message: %v", err)
+		}
+		if got.Status != test.expected {
+			t.Fatalf("Unexpected message status after %s. Expected %s Got %s", test.status, test.expected, got.Status)
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/messages/unknown/received", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Unexpected status code for an unknown message. Expected %d Got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestParseDomainHash(t *testing.T) {
+	setupRouter(t)
+	id, err := db.DomainID("example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error computing domain ID: %v", err)
+	}
+	for _, value := range []string{id, "example.com", "Example.com."} {
+		got := parseDomainHash(value)
+		if got != id {
+			t.Fatalf("Unexpected domain hash for %s. Expected %s Got %s", value, id, got)
+		}
+	}
+}
+
+func TestWebhookSecret(t *testing.T) {
+	router, store := setupRouter(t)
+	key := createAPIKey(t, store, db.ScopeAdmin)
+
+	req := httptest.NewRequest("POST", "/webhooks/", strings.NewReader(`{"url":"https://example.com/hook"}`))
+	req.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Unexpected status code creating a webhook. Expected %d Got %d", http.StatusCreated, w.Code)
+	}
+	created := WebhookResponse{}
+	err := json.NewDecoder(w.Body).Decode(&created)
+	if err != nil || created.Secret == "" {
+		t.Fatalf("Secret wasn't returned when creating the webhook: %#v, %v", created, err)
+	}
+
+	req = httptest.NewRequest("GET", "/webhooks/", nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code listing webhooks. Expected %d Got %d", http.StatusOK, w.Code)
+	}
+	if strings.Contains(w.Body.String(), created.Secret) || strings.Contains(w.Body.String(), "secret") {
+		t.Fatalf("Secret was returned when listing webhooks: %s", w.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/webhooks/", strings.NewReader(`{"url":"http://169.254.169.254/latest/meta-data/"}`))
+	req.Header.Set("Authorization", "Bearer "+key)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Unexpected status code for an internal webhook. Expected %d Got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	router, store := setupRouter(t)
+	key := createAPIKey(t, store, db.ScopeRead)
+	revoked := createAPIKey(t, store, db.ScopeRead)
+	keys, err := store.GetAPIKeys(db.DefaultOrganizationID)
+	if err != nil {
+		t.Fatalf("Unexpected error getting API keys: %v", err)
+	}
+	err = store.DeleteAPIKey(keys[len(keys)-1].ID, db.DefaultOrganizationID)
+	if err != nil {
+		t.Fatalf("Unexpected error revoking API key: %v", err)
+	}
+	testSuite := []struct {
+		name          string
+		authorization string
+		expected      int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"not a bearer token", key, http.StatusUnauthorized},
+		{"invalid", "Bearer hc_invalid", http.StatusUnauthorized},
+		{"revoked", "Bearer " + revoked, http.StatusUnauthorized},
+		{"valid", "Bearer " + key, http.StatusOK},
+	}
+	for _, test := range testSuite {
+		req := httptest.NewRequest("GET", "/scenarios", nil)
+		if test.authorization != "" {
+			req.Header.Set("Authorization", test.authorization)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.expected {
+			t.Fatalf("Unexpected status code for a %s key. Expected %d Got %d", test.name, test.expected, w.Code)
+		}
+		if test.expected == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Fatalf("Missing WWW-Authenticate header for a %s key", test.name)
+		}
+	}
+}
+
+func TestRequireAPIKeyDatabaseError(t *testing.T) {
+	router, store := setupRouter(t)
+	key := createAPIKey(t, store, db.ScopeRead)
+	// A database outage isn't reported as an invalid key
+	store.(*db.GormStore).Close()
+	req := httptest.NewRequest("GET", "/scenarios", nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Unexpected status code with the database closed. Expected %d Got %d", http.StatusInternalServerError, w.Code)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	router, store := setupRouter(t)
+	testSuite := []struct {
+		scope    string
+		method   string
+		path     string
+		expected int
+	}{
+		{db.ScopeRead, "POST", "/domains/", http.StatusForbidden},
+		{db.ScopeRead, "GET", "/keys/", http.StatusForbidden},
+		{db.ScopeSend, "GET", "/scenarios", http.StatusForbidden},
+		{db.ScopeSend, "GET", "/keys/", http.StatusForbidden},
+		{db.ScopeAdmin, "GET", "/keys/", http.StatusOK},
+		{db.ScopeAdmin, "GET", "/organizations/", http.StatusForbidden},
+	}
+	for _, test := range testSuite {
+		key := createAPIKey(t, store, test.scope)
+		req := httptest.NewRequest(test.method, test.path, nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.expected {
+			t.Fatalf("Unexpected status code for %s %s with the %s scope. Expected %d Got %d", test.method, test.path, test.scope, test.expected, w.Code)
+		}
+	}
+}
+
+func TestRequireDomainAccess(t *testing.T) {
+	router, store := setupRouter(t)
+	allowed, err := db.DomainID("example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error computing domain ID: %v", err)
+	}
+	err = store.PostDomain(&db.Domain{OrgID: db.DefaultOrganizationID, DomainHash: allowed, Name: "example.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error when creating domain: %v", err)
+	}
+	key, err := store.PostAPIKey(&db.APIKey{
+		OrgID:        db.DefaultOrganizationID,
+		ScopeNames:   []string{db.ScopeRead},
+		DomainHashes: []string{allowed},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error when creating API key: %v", err)
+	}
+	testSuite := map[string]int{
+		"/domains/" + allowed + "/": http.StatusOK,
+		"/domains/example.com/":     http.StatusOK,
+		"/domains/example.org/":     http.StatusForbidden,
+		"/export":                   http.StatusForbidden,
+	}
+	for path, expected := range testSuite {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != expected {
+			t.Fatalf("Unexpected status code for %s. Expected %d Got %d", path, expected, w.Code)
+		}
+	}
+}
+
+// csrfToken matches the CSRF token in the dashboard forms
+var csrfToken = regexp.MustCompile(`name="gorilla.csrf.Token" value="([^"]+)"`)
+
+// dashboardPost submits a dashboard form with a valid CSRF token, along with
+// the provided cookies.
+func dashboardPost(t *testing.T, router http.Handler, path string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/dashboard/login", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	match := csrfToken.FindStringSubmatch(w.Body.String())
+	if match == nil {
+		t.Fatalf("No CSRF token in the login page: %s", w.Body.String())
+	}
+	form.Set("gorilla.csrf.Token", match[1])
+	req = httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, c := range append(w.Result().Cookies(), cookies...) {
+		req.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// responseSessionCookie returns the session cookie set by the response, if
+// any
+func responseSessionCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == SessionCookie {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestDashboardSession(t *testing.T) {
+	router, store := setupRouter(t)
+	key := createAPIKey(t, store, db.ScopeRead)
+
+	w := dashboardPost(t, router, "/dashboard/login", url.Values{"key": {key}})
+	if w.Code != http.StatusFound {
+		t.Fatalf("Unexpected status code signing in. Expected %d Got %d", http.StatusFound, w.Code)
+	}
+	session := responseSessionCookie(w)
+	if session == nil || session.Value == "" || strings.Contains(session.Value, key) {
+		t.Fatalf("Unexpected session cookie: %#v", session)
+	}
+	if !session.HttpOnly || session.SameSite != http.SameSiteLaxMode || session.Secure {
+		t.Fatalf("Unexpected session cookie attributes: %#v", session)
+	}
+
+	req := httptest.NewRequest("GET", "/dashboard/", nil)
+	req.AddCookie(session)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code with a session. Expected %d Got %d", http.StatusOK, w.Code)
+	}
+
+	// Signing out revokes the session, even if the cookie is kept
+	w = dashboardPost(t, router, "/dashboard/logout", url.Values{}, session)
+	if w.Code != http.StatusFound {
+		t.Fatalf("Unexpected status code signing out. Expected %d Got %d", http.StatusFound, w.Code)
+	}
+	req = httptest.NewRequest("GET", "/dashboard/", nil)
+	req.AddCookie(session)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/dashboard/login" {
+		t.Fatalf("Session wasn't revoked. Got %d to %s", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestDashboardSecureCookies(t *testing.T) {
+	router, store := setupRouter(t)
+	key := createAPIKey(t, store, db.ScopeRead)
+	req := httptest.NewRequest("GET", "/dashboard/login", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	for _, c := range w.Result().Cookies() {
+		if !c.Secure {
+			t.Fatalf("Cookie %s isn't secure over HTTPS", c.Name)
+		}
+	}
+	match := csrfToken.FindStringSubmatch(w.Body.String())
+	if match == nil {
+		t.Fatalf("No CSRF token in the login page: %s", w.Body.String())
+	}
+	form := url.Values{"key": {key}, "gorilla.csrf.Token": {match[1]}}
+	req = httptest.NewRequest("POST", "/dashboard/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	session := responseSessionCookie(w)
+	if session == nil || !session.Secure {
+		t.Fatalf("Session cookie isn't secure over HTTPS: %#v", session)
+	}
+}
+
+// readEvent returns the name and data of the next Server-Sent Event in the
+// stream, skipping comments.
+func readEvent(t *testing.T, r *bufio.Reader) (string, string) {
+	name, data := "", ""
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Unexpected error reading event stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && name != "":
+			return name, data
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestGetMessageEvents(t *testing.T) {
+	router, store := setupRouter(t)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+	key := createAPIKey(t, store, db.ScopeAdmin)
+	m := createMessage(t, store)
+	err := store.PostDNSQuery(m, &db.DNSQuery{Name: m.MessageID + ".example.com.", Type: "TXT"})
+	if err != nil {
+		t.Fatalf("Unexpected error when creating DNS query: %v", err)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/messages/"+m.MessageID+"/events", nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error opening event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected response opening event stream: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	stream := bufio.NewReader(resp.Body)
+
+	// DNS queries already received are sent first
+	name, data := readEvent(t, stream)
+	if name != events.DNSLookup || !strings.Contains(data, m.MessageID+".example.com.") {
+		t.Fatalf("Unexpected first event: %s %s", name, data)
+	}
+	// Events for other messages aren't sent
+	events.Publish(events.Event{Type: events.MessageSent, MessageID: "other"})
+	events.Publish(events.Event{Type: events.MessageRejected, MessageID: m.MessageID})
+	name, data = readEvent(t, stream)
+	if name != events.MessageRejected || !strings.Contains(data, m.MessageID) {
+		t.Fatalf("Unexpected event: %s %s", name, data)
+	}
+}
+
+func TestDashboardEvents(t *testing.T) {
+	router, store := setupRouter(t)
+	key := createAPIKey(t, store, db.ScopeAdmin)
+	m := createMessage(t, store)
+	path := "/dashboard/messages/" + m.MessageID + "/events"
+
+	// The stream is authenticated with the session cookie rather than a
+	// bearer token
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusFound {
+		t.Fatalf("Unexpected status code without a session. Expected %d Got %d", http.StatusFound, w.Code)
+	}
+
+	w = dashboardPost(t, router, "/dashboard/login", url.Values{"key": {key}})
+	session := responseSessionCookie(w)
+	if session == nil {
+		t.Fatalf("No session cookie after signing in")
+	}
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+	req, _ = http.NewRequest("GET", ts.URL+path, nil)
+	req.AddCookie(session)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error opening event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Unexpected response opening event stream: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	events.Publish(events.Event{Type: events.MessageSent, MessageID: m.MessageID})
+	name, _ := readEvent(t, bufio.NewReader(resp.Body))
+	if name != events.MessageSent {
+		t.Fatalf("Unexpected event. Expected %s Got %s", events.MessageSent, name)
+	}
+}
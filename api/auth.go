@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/gophish/healthcheck/db"
+
+	"github.com/go-chi/chi"
+)
+
+// ErrDomainNotAllowed occurs when an API key is used for a domain it wasn't
+// granted access to.
+var ErrDomainNotAllowed = errors.New("API key is not allowed to access this domain")
+
+// APIKeyRequest is the request used to create an API key. Domains are
+// provided as domain names, and stored as their hashes.
+type APIKeyRequest struct {
+	Name    string   `json:"name"`
+	Scopes  []string `json:"scopes"`
+	Domains []string `json:"domains"`
+}
+
+// APIKeyResponse is the response returned when creating an API key. It's the
+// only time the key itself is returned.
+type APIKeyResponse struct {
+	*db.APIKey
+	Key string `json:"key"`
+}
+
+// RequireAPIKey authenticates the request using the API key provided in the
+// Authorization header, adding the key to the request context.
+func RequireAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == r.Header.Get("Authorization") {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		key, err := getStore(r).GetAPIKeyByKey(token)
+		if err == db.ErrInvalidAPIKey {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Error(err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		ctx := context.WithValue(r.Context(), "apikey", key)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireScope only allows requests using an API key with the provided
+// scope. It must be used after RequireAPIKey.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Context().Value("apikey").(*db.APIKey)
+			if !key.HasScope(scope) {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireDomainAccess only allows requests using an API key allowed to
+// access the domain in the URL. It must be used after RequireAPIKey.
+func RequireDomainAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowsDomain(r, domainHash(r)) {
+			http.Error(w, ErrDomainNotAllowed.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireMessageAccess only allows requests using an API key allowed to
+// access the domain the message was sent to. Messages sent by other
+// organizations aren't found. It must be used after RequireAPIKey and
+// MessageCtx.
+func RequireMessageAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := r.Context().Value("message").(*db.Message)
+		if m.OrgID != orgID(r) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		if !allowsDomain(r, m.DomainHash) {
+			http.Error(w, ErrDomainNotAllowed.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowsDomain returns whether the request's API key may access the domain
+func allowsDomain(r *http.Request, hash string) bool {
+	key := r.Context().Value("apikey").(*db.APIKey)
+	return key.AllowsDomain(hash)
+}
+
+// orgID returns the organization of the request's API key
+func orgID(r *http.Request) uint {
+	key := r.Context().Value("apikey").(*db.APIKey)
+	return key.OrgID
+}
+
+// GetAPIKeys returns every API key for the organization
+func GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := getStore(r).GetAPIKeys(orgID(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, keys, http.StatusOK)
+}
+
+// PostAPIKey creates a new API key
+func PostAPIKey(w http.ResponseWriter, r *http.Request) {
+	kr := &APIKeyRequest{}
+	err := json.NewDecoder(r.Body).Decode(kr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if containsScope(kr.Scopes, db.ScopeSuperadmin) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	k := &db.APIKey{
+		OrgID:        orgID(r),
+		Name:         kr.Name,
+		ScopeNames:   kr.Scopes,
+		DomainHashes: []string{},
+	}
+	for _, domain := range kr.Domains {
+		hash, err := db.DomainID(domain)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		k.DomainHashes = append(k.DomainHashes, hash)
+	}
+	key, err := getStore(r).PostAPIKey(k)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	JSONResponse(w, APIKeyResponse{k, key}, http.StatusCreated)
+}
+
+// DeleteAPIKey revokes an API key
+func DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "keyID"), 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	err = getStore(r).DeleteAPIKey(uint(id), orgID(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// containsScope returns whether the list of scopes includes the scope
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
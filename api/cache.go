@@ -0,0 +1,18 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gophish/healthcheck/db"
+)
+
+// GetCacheStats returns the hit and miss counts of the message cache, if the
+// store is cached.
+func GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	store, ok := getStore(r).(*db.CachedStore)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	JSONResponse(w, store.Stats(), http.StatusOK)
+}
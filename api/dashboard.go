@@ -0,0 +1,424 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/gophish/healthcheck/db"
+	"github.com/gophish/healthcheck/mail"
+	"github.com/gophish/healthcheck/report"
+	"github.com/gophish/healthcheck/template"
+	"github.com/gophish/healthcheck/util"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/gorilla/csrf"
+)
+
+// SessionCookie is the cookie holding the dashboard session ID.
+const SessionCookie = "healthcheck_session"
+
+// DashboardPage is the data passed to every dashboard template.
+type DashboardPage struct {
+	Title     string
+	CSRFField htmltemplate.HTML
+	Key       *db.APIKey
+	Error     string
+	// Refresh reloads the page every few seconds, so that the status of
+	// pending messages is updated live.
+	Refresh bool
+	// Events is the event stream for the messages on the page. When it's
+	// set, the page reloads as soon as a message changes status, and only
+	// refreshes every 30 seconds in case an event was missed.
+	Events string
+	Data   interface{}
+}
+
+// isTLS returns whether the request was made over HTTPS, either to us or to
+// the reverse proxy terminating TLS in front of us.
+func isTLS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// csrfProtect protects the dashboard forms against CSRF. The CSRF cookie is
+// only sent over HTTPS when the dashboard is served over HTTPS.
+func csrfProtect() func(http.Handler) http.Handler {
+	key, err := db.CSRFKey()
+	if err != nil {
+		log.Errorf("error deriving the CSRF key, tokens won't survive a restart: %v", err)
+		key = []byte(util.GenerateSecureID(16))
+	}
+	options := []csrf.Option{csrf.Path("/dashboard"), csrf.SameSite(csrf.SameSiteLaxMode)}
+	secure := csrf.Protect(key, append(options, csrf.Secure(true))...)
+	insecure := csrf.Protect(key, append(options, csrf.Secure(false))...)
+	return func(next http.Handler) http.Handler {
+		secureNext, insecureNext := secure(next), insecure(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isTLS(r) {
+				secureNext.ServeHTTP(w, r)
+				return
+			}
+			insecureNext.ServeHTTP(w, r)
+		})
+	}
+}
+
+// dashboardRoutes registers the dashboard pages on the router. Unlike the
+// rest of the API, the dashboard is authenticated with a cookie, so every
+// form is protected against CSRF.
+func dashboardRoutes(r chi.Router) {
+	r.Use(csrfProtect())
+	// The event streams let pages update as soon as messages change status.
+	// EventSource can't set an Authorization header, so they're
+	// authenticated with the session cookie.
+	r.Group(func(r chi.Router) {
+		r.Use(RequireDashboardSession)
+		r.Use(RequireScope(db.ScopeRead))
+		r.With(MessageCtx, RequireMessageAccess).Get("/messages/{messageID}/events", GetMessageEvents)
+		r.Get("/runs/{runID}/events", GetRunEvents)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.DefaultCompress)
+		r.Use(middleware.Timeout(60 * time.Second))
+		r.Get("/login", DashboardLogin)
+		r.Post("/login", DashboardPostLogin)
+		r.Post("/logout", DashboardLogout)
+		r.Group(dashboardPages)
+	})
+}
+
+// dashboardPages registers the dashboard pages which require a session
+func dashboardPages(r chi.Router) {
+	r.Use(RequireDashboardSession)
+	r.Group(func(r chi.Router) {
+		r.Use(RequireScope(db.ScopeRead))
+		r.Get("/", DashboardIndex)
+		r.Get("/runs/{runID}", DashboardRun)
+		r.With(MessageCtx, RequireMessageAccess).Get("/messages/{messageID}", DashboardMessage)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(RequireScope(db.ScopeSend))
+		r.Post("/messages", DashboardPostMessage)
+		r.Post("/domains", DashboardPostDomain)
+	})
+	r.Route("/domains/{domainHash}", func(r chi.Router) {
+		r.Use(RequireDomainAccess)
+		r.Use(DomainCtx)
+		r.With(RequireScope(db.ScopeRead)).Get("/", DashboardDomain)
+		r.With(RequireScope(db.ScopeSend)).Post("/verify", DashboardVerifyDomain)
+		r.Group(func(r chi.Router) {
+			r.Use(RequireVerifiedDomain)
+			r.With(RequireScope(db.ScopeRead)).Get("/report", DashboardReport)
+			r.With(RequireScope(db.ScopeSend)).Post("/runs", DashboardPostRun)
+		})
+	})
+}
+
+// RequireDashboardSession authenticates the request using the session in the
+// session cookie, redirecting to the login page if there isn't a valid one.
+func RequireDashboardSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(SessionCookie)
+		if err != nil {
+			http.Redirect(w, r, "/dashboard/login", http.StatusFound)
+			return
+		}
+		key, err := getStore(r).GetSessionAPIKey(cookie.Value)
+		if err == db.ErrInvalidSession {
+			http.Redirect(w, r, "/dashboard/login", http.StatusFound)
+			return
+		}
+		if err != nil {
+			log.Error(err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		ctx := context.WithValue(r.Context(), "apikey", key)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// renderDashboard renders the named dashboard page
+func renderDashboard(w http.ResponseWriter, r *http.Request, name string, p *DashboardPage) {
+	p.CSRFField = csrf.TemplateField(r)
+	if key, ok := r.Context().Value("apikey").(*db.APIKey); ok {
+		p.Key = key
+	}
+	html, err := template.ExecuteDashboardTemplate(name, p)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(html))
+}
+
+// DashboardLogin renders the login page
+func DashboardLogin(w http.ResponseWriter, r *http.Request) {
+	renderDashboard(w, r, "login.html", &DashboardPage{Title: "Sign In"})
+}
+
+// sessionCookie returns the session cookie holding the provided session ID
+func sessionCookie(r *http.Request, id string) *http.Cookie {
+	return &http.Cookie{
+		Name:     SessionCookie,
+		Value:    id,
+		Path:     "/dashboard",
+		MaxAge:   int(db.SessionDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   isTLS(r),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// DashboardPostLogin signs in using the submitted API key. The key itself is
+// never stored in the cookie: a new session is started instead.
+func DashboardPostLogin(w http.ResponseWriter, r *http.Request) {
+	key, err := getStore(r).GetAPIKeyByKey(r.FormValue("key"))
+	if err == db.ErrInvalidAPIKey {
+		w.WriteHeader(http.StatusUnauthorized)
+		renderDashboard(w, r, "login.html", &DashboardPage{Title: "Sign In", Error: db.ErrInvalidAPIKey.Error()})
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	id, err := getStore(r).PostSession(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, sessionCookie(r, id))
+	http.Redirect(w, r, "/dashboard/", http.StatusFound)
+}
+
+// DashboardLogout signs out by ending the session and clearing the session
+// cookie
+func DashboardLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		err = getStore(r).DeleteSession(cookie.Value)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+	cookie := sessionCookie(r, "")
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+	http.Redirect(w, r, "/dashboard/login", http.StatusFound)
+}
+
+// DashboardIndex lists the domains the API key may access, along with the
+// form used to send a single test.
+func DashboardIndex(w http.ResponseWriter, r *http.Request) {
+	all, err := getStore(r).GetDomains(orgID(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	domains := []db.Domain{}
+	for _, d := range all {
+		if allowsDomain(r, d.DomainHash) {
+			domains = append(domains, d)
+		}
+	}
+	renderDashboard(w, r, "index.html", &DashboardPage{
+		Title: "Domains",
+		Data: map[string]interface{}{
+			"Domains":   domains,
+			"Scenarios": db.Scenarios,
+		},
+	})
+}
+
+// DashboardPostMessage sends a single scenario, redirecting to the message's
+// page to follow its status.
+func DashboardPostMessage(w http.ResponseWriter, r *http.Request) {
+	scenario, err := db.GetScenario(r.FormValue("scenario"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m := &db.Message{
+		Recipient:            r.FormValue("recipient"),
+		MailServer:           r.FormValue("mail_server"),
+		Scenario:             scenario.Name,
+		MessageConfiguration: scenario.Configuration,
+	}
+	err = m.Validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hash, err := db.DomainIDFromAddress(m.Recipient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !allowsDomain(r, hash) {
+		http.Error(w, ErrDomainNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
+	m.OrgID = orgID(r)
+	m.DomainHash = hash
+	err = m.ResolveMailServer()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.ErrorChan = make(chan error)
+	err = getStore(r).PostMessage(m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The message page refreshes until the message is sent, so there's no
+	// need to wait for it here.
+	go mail.SendEmail(m)
+	http.Redirect(w, r, fmt.Sprintf("/dashboard/messages/%s", m.MessageID), http.StatusFound)
+}
+
+// DashboardMessage shows the status of a single message
+func DashboardMessage(w http.ResponseWriter, r *http.Request) {
+	m := r.Context().Value("message").(*db.Message)
+	renderDashboard(w, r, "message.html", &DashboardPage{
+		Title:   "Message",
+		Refresh: db.Pending(*m),
+		Events:  fmt.Sprintf("/dashboard/messages/%s/events", m.MessageID),
+		Data:    m,
+	})
+}
+
+// DashboardPostDomain registers a domain, redirecting to its page which shows
+// how to verify it.
+func DashboardPostDomain(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("domain")
+	if name == "" {
+		http.Error(w, db.ErrMissingDomain.Error(), http.StatusBadRequest)
+		return
+	}
+	hash, err := db.DomainID(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !allowsDomain(r, hash) {
+		http.Error(w, ErrDomainNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
+	_, err = getStore(r).GetDomain(orgID(r), hash)
+	if err != nil {
+		err = getStore(r).PostDomain(&db.Domain{OrgID: orgID(r), DomainHash: hash, Name: name})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	http.Redirect(w, r, fmt.Sprintf("/dashboard/domains/%s/", hash), http.StatusFound)
+}
+
+// DashboardDomain shows the requested domain, its history of runs and the
+// form used to launch a suite of scenarios.
+func DashboardDomain(w http.ResponseWriter, r *http.Request) {
+	domain := r.Context().Value("domain").(*db.Domain)
+	runs, err := getStore(r).GetRuns(domain.OrgID, domain.DomainHash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderDashboard(w, r, "domain.html", &DashboardPage{
+		Title: "Domain",
+		Data: map[string]interface{}{
+			"Domain":             domain,
+			"VerificationRecord": domain.VerificationRecord(),
+			"Runs":               runs,
+			"Scenarios":          db.Scenarios,
+		},
+	})
+}
+
+// DashboardVerifyDomain checks the verification record for the domain
+func DashboardVerifyDomain(w http.ResponseWriter, r *http.Request) {
+	domain := r.Context().Value("domain").(*db.Domain)
+	err := domain.Verify(r.FormValue("domain"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = getStore(r).PutDomain(domain)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/dashboard/domains/%s/", domain.DomainHash), http.StatusFound)
+}
+
+// DashboardPostRun launches the selected scenarios, redirecting to the run's
+// page to follow the status of each message.
+func DashboardPostRun(w http.ResponseWriter, r *http.Request) {
+	domain := r.Context().Value("domain").(*db.Domain)
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	recipient := r.FormValue("recipient")
+	err = checkRecipient(domain, recipient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scenarios := r.Form["scenarios"]
+	if len(scenarios) == 0 {
+		http.Error(w, db.ErrMissingScenarios.Error(), http.StatusBadRequest)
+		return
+	}
+	run := &db.Run{OrgID: domain.OrgID, DomainHash: domain.DomainHash}
+	err = mail.SendRun(getStore(r), run, recipient, r.FormValue("mail_server"), scenarios)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/dashboard/runs/%d", run.ID), http.StatusFound)
+}
+
+// DashboardRun shows the status of every message in a run
+func DashboardRun(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "runID"), 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	run, err := getStore(r).GetRun(uint(id), orgID(r))
+	if err != nil || !allowsDomain(r, run.DomainHash) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	renderDashboard(w, r, "run.html", &DashboardPage{
+		Title:   "Run",
+		Refresh: db.Pending(run.Messages...),
+		Events:  fmt.Sprintf("/dashboard/runs/%d/events", run.ID),
+		Data:    run,
+	})
+}
+
+// DashboardReport shows the security scorecard for the requested domain
+func DashboardReport(w http.ResponseWriter, r *http.Request) {
+	domain := r.Context().Value("domain").(*db.Domain)
+	rep, err := report.Generate(getStore(r), domain.OrgID, domain.DomainHash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	renderDashboard(w, r, "report.html", &DashboardPage{
+		Title: "Report",
+		Data:  rep,
+	})
+}
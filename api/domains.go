@@ -0,0 +1,304 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/gophish/healthcheck/db"
+	"github.com/gophish/healthcheck/mail"
+	"github.com/gophish/healthcheck/report"
+	"github.com/gophish/healthcheck/template"
+
+	"github.com/go-chi/chi"
+)
+
+// ErrRecipientDomainMismatch occurs when a recipient address doesn't belong
+// to the domain being tested.
+var ErrRecipientDomainMismatch = errors.New("recipient does not belong to the domain")
+
+// DomainRequest is the request used to register or verify a domain.
+type DomainRequest struct {
+	Domain string `json:"domain"`
+}
+
+// DomainResponse is the response returned when registering a domain,
+// including the TXT record needed to verify it.
+type DomainResponse struct {
+	*db.Domain
+	VerificationRecord string `json:"verification_record"`
+}
+
+// RunRequest is the request used to launch a run on demand.
+type RunRequest struct {
+	Recipient  string   `json:"recipient"`
+	MailServer string   `json:"mail_server"`
+	Scenarios  []string `json:"scenarios"`
+}
+
+// domainHash returns the identifier of the domain in the URL.
+func domainHash(r *http.Request) string {
+	return parseDomainHash(chi.URLParam(r, "domainHash"))
+}
+
+// parseDomainHash returns the identifier of the requested domain. Clients
+// can't compute identifiers without the secret key, so they may use the
+// domain name instead.
+func parseDomainHash(hash string) string {
+	if strings.Contains(hash, ".") {
+		id, err := db.DomainID(hash)
+		if err == nil {
+			return id
+		}
+	}
+	return hash
+}
+
+// DomainCtx enriches the request context with the requested domain
+func DomainCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domainHash := domainHash(r)
+		domain, err := getStore(r).GetDomain(orgID(r), domainHash)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		ctx := context.WithValue(r.Context(), "domain", domain)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireVerifiedDomain only allows requests for domains which have been
+// verified. It must be used after DomainCtx.
+func RequireVerifiedDomain(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		domain := r.Context().Value("domain").(*db.Domain)
+		if !domain.Verified {
+			http.Error(w, db.ErrDomainNotVerified.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkRecipient ensures the recipient address belongs to the domain
+func checkRecipient(domain *db.Domain, recipient string) error {
+	hash, err := db.DomainIDFromAddress(recipient)
+	if err != nil {
+		return err
+	}
+	if hash != domain.DomainHash {
+		return ErrRecipientDomainMismatch
+	}
+	return nil
+}
+
+// PostDomain registers a domain, returning the TXT record its owner needs to
+// publish to verify it.
+func PostDomain(w http.ResponseWriter, r *http.Request) {
+	dr := &DomainRequest{}
+	err := json.NewDecoder(r.Body).Decode(dr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if dr.Domain == "" {
+		http.Error(w, db.ErrMissingDomain.Error(), http.StatusBadRequest)
+		return
+	}
+	hash, err := db.DomainID(dr.Domain)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !allowsDomain(r, hash) {
+		http.Error(w, ErrDomainNotAllowed.Error(), http.StatusForbidden)
+		return
+	}
+	domain, err := getStore(r).GetDomain(orgID(r), hash)
+	if err != nil {
+		domain = &db.Domain{OrgID: orgID(r), DomainHash: hash, Name: dr.Domain}
+		err = getStore(r).PostDomain(domain)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	JSONResponse(w, DomainResponse{domain, domain.VerificationRecord()}, http.StatusOK)
+}
+
+// GetDomain returns the requested domain
+func GetDomain(w http.ResponseWriter, r *http.Request) {
+	domain := r.Context().Value("domain").(*db.Domain)
+	JSONResponse(w, DomainResponse{domain, domain.VerificationRecord()}, http.StatusOK)
+}
+
+// VerifyDomain checks the verification TXT record for the requested domain
+func VerifyDomain(w http.ResponseWriter, r *http.Request) {
+	domain := r.Context().Value("domain").(*db.Domain)
+	dr := &DomainRequest{}
+	err := json.NewDecoder(r.Body).Decode(dr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = domain.Verify(dr.Domain)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = getStore(r).PutDomain(domain)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, DomainResponse{domain, domain.VerificationRecord()}, http.StatusOK)
+}
+
+// GetSchedules returns the schedules for the requested domain
+func GetSchedules(w http.ResponseWriter, r *http.Request) {
+	domain := r.Context().Value("domain").(*db.Domain)
+	schedules, err := getStore(r).GetSchedules(domain.OrgID, domain.DomainHash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, schedules, http.StatusOK)
+}
+
+// PostSchedule creates a new schedule for the requested domain
+func PostSchedule(w http.ResponseWriter, r *http.Request) {
+	domain := r.Context().Value("domain").(*db.Domain)
+	s := &db.Schedule{}
+	err := json.NewDecoder(r.Body).Decode(s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.ID = 0
+	s.OrgID = domain.OrgID
+	s.DomainHash = domain.DomainHash
+	err = checkRecipient(domain, s.Recipient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = getStore(r).PostSchedule(s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	JSONResponse(w, s, http.StatusCreated)
+}
+
+// DeleteDomain permanently deletes everything the organization stored for
+// the requested domain, including the results of every message sent to it.
+func DeleteDomain(w http.ResponseWriter, r *http.Request) {
+	err := getStore(r).DeleteDomainData(orgID(r), domainHash(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteSchedule deletes a schedule for the requested domain
+func DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	domain := r.Context().Value("domain").(*db.Domain)
+	id, err := strconv.ParseUint(chi.URLParam(r, "scheduleID"), 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	err = getStore(r).DeleteSchedule(uint(id), domain.OrgID, domain.DomainHash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetRuns returns the history of runs for the requested domain
+func GetRuns(w http.ResponseWriter, r *http.Request) {
+	domain := r.Context().Value("domain").(*db.Domain)
+	runs, err := getStore(r).GetRuns(domain.OrgID, domain.DomainHash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, runs, http.StatusOK)
+}
+
+// PostRun launches a set of scenarios against the requested domain
+func PostRun(w http.ResponseWriter, r *http.Request) {
+	domain := r.Context().Value("domain").(*db.Domain)
+	rr := &RunRequest{}
+	err := json.NewDecoder(r.Body).Decode(rr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = checkRecipient(domain, rr.Recipient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(rr.Scenarios) == 0 {
+		http.Error(w, db.ErrMissingScenarios.Error(), http.StatusBadRequest)
+		return
+	}
+	run := &db.Run{OrgID: domain.OrgID, DomainHash: domain.DomainHash}
+	err = mail.SendRun(getStore(r), run, rr.Recipient, rr.MailServer, rr.Scenarios)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	JSONResponse(w, run, http.StatusCreated)
+}
+
+// GetRun returns the requested run along with its messages
+func GetRun(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "runID"), 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	run, err := getStore(r).GetRun(uint(id), orgID(r))
+	if err != nil || !allowsDomain(r, run.DomainHash) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	JSONResponse(w, run, http.StatusOK)
+}
+
+// GetScenarios returns the scenarios available to runs and schedules
+func GetScenarios(w http.ResponseWriter, r *http.Request) {
+	JSONResponse(w, db.Scenarios, http.StatusOK)
+}
+
+// GetReport returns the security scorecard for the requested domain, either
+// as JSON or, using ?format=html, as a rendered page.
+func GetReport(w http.ResponseWriter, r *http.Request) {
+	domain := r.Context().Value("domain").(*db.Domain)
+	rep, err := report.Generate(getStore(r), domain.OrgID, domain.DomainHash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("format") != "html" {
+		JSONResponse(w, rep, http.StatusOK)
+		return
+	}
+	html, err := template.ExecuteHTMLTemplate(template.ReportTemplate, rep)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(html))
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gophish/healthcheck/db"
+)
+
+// GetPostureEvents returns the regressions and improvements detected across
+// every domain in the organization. Integrations can poll this endpoint using
+// the since parameter set to the last event ID they processed.
+func GetPostureEvents(w http.ResponseWriter, r *http.Request) {
+	writePostureEvents(w, r, parseDomainHash(r.URL.Query().Get("domain_hash")))
+}
+
+// GetDomainPostureEvents returns the regressions and improvements detected
+// for the requested domain.
+func GetDomainPostureEvents(w http.ResponseWriter, r *http.Request) {
+	domain := r.Context().Value("domain").(*db.Domain)
+	writePostureEvents(w, r, domain.DomainHash)
+}
+
+func writePostureEvents(w http.ResponseWriter, r *http.Request, hash string) {
+	since := uint64(0)
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		since, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	events, err := getStore(r).GetPostureEvents(orgID(r), hash, uint(since))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, events, http.StatusOK)
+}
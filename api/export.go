@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/gophish/healthcheck/db"
+	"github.com/gophish/healthcheck/export"
+)
+
+// GetExport exports the messages matching the domain_hash, scenario, from and
+// to parameters in the format requested with the format parameter.
+func GetExport(w http.ResponseWriter, r *http.Request) {
+	writeExport(w, r, parseDomainHash(r.URL.Query().Get("domain_hash")))
+}
+
+// GetDomainExport exports the messages sent to the requested domain.
+func GetDomainExport(w http.ResponseWriter, r *http.Request) {
+	domain := r.Context().Value("domain").(*db.Domain)
+	writeExport(w, r, domain.DomainHash)
+}
+
+func writeExport(w http.ResponseWriter, r *http.Request, hash string) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = export.FormatNDJSON
+	}
+	f, err := export.ParseFilter(q.Get("scenario"), q.Get("from"), q.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.OrgID = orgID(r)
+	f.DomainHash = hash
+	switch format {
+	case export.FormatCSV, export.FormatNDJSON, export.FormatJUnit:
+	default:
+		http.Error(w, export.ErrInvalidFormat.Error(), http.StatusBadRequest)
+		return
+	}
+	messages, err := getStore(r).GetMessages(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", export.ContentType(format))
+	err = export.Write(w, format, messages)
+	if err != nil {
+		log.Error(err)
+	}
+}
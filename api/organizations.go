@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gophish/healthcheck/db"
+
+	"github.com/go-chi/chi"
+)
+
+// OrganizationResponse is the response returned when creating an
+// organization, including its first admin API key.
+type OrganizationResponse struct {
+	*db.Organization
+	APIKey APIKeyResponse `json:"api_key"`
+}
+
+// GetOrganizations returns every organization
+func GetOrganizations(w http.ResponseWriter, r *http.Request) {
+	orgs, err := getStore(r).GetOrganizations()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, orgs, http.StatusOK)
+}
+
+// PostOrganization creates a new organization along with an admin API key,
+// which can then be used to create the organization's other keys.
+func PostOrganization(w http.ResponseWriter, r *http.Request) {
+	org := &db.Organization{}
+	err := json.NewDecoder(r.Body).Decode(org)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	org.ID = 0
+	err = getStore(r).PostOrganization(org)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	k := &db.APIKey{
+		OrgID:      org.ID,
+		Name:       "admin",
+		ScopeNames: []string{db.ScopeAdmin},
+	}
+	key, err := getStore(r).PostAPIKey(k)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, OrganizationResponse{org, APIKeyResponse{k, key}}, http.StatusCreated)
+}
+
+// GetOrganization returns the requested organization
+func GetOrganization(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "orgID"), 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	org, err := getStore(r).GetOrganization(uint(id))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	JSONResponse(w, org, http.StatusOK)
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gophish/healthcheck/db"
+)
+
+// ErrRateLimited occurs when too many messages were sent to a domain.
+var ErrRateLimited = errors.New("too many messages sent to this domain, try again later")
+
+// maxBuckets is the number of domains tracked before we forget about the
+// domains which are back to a full bucket.
+const maxBuckets = 10000
+
+// Limiter is a token bucket rate limiter keyed by receiving domain.
+type Limiter struct {
+	// PerHour is the number of messages allowed per hour. Zero disables
+	// rate limiting.
+	PerHour int
+	// Burst is the number of messages which can be sent at once
+	Burst int
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter allowing perHour messages per hour to each
+// domain, with bursts of up to burst messages.
+func NewLimiter(perHour, burst int) *Limiter {
+	return &Limiter{
+		PerHour: perHour,
+		Burst:   burst,
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the domain's bucket, returning how long to wait
+// before retrying when the bucket is empty.
+func (l *Limiter) Allow(domainHash string) (bool, time.Duration) {
+	if l.PerHour <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if len(l.buckets) >= maxBuckets {
+		for k, b := range l.buckets {
+			if l.refill(b, now) >= float64(l.Burst) {
+				delete(l.buckets, k)
+			}
+		}
+	}
+	b, ok := l.buckets[domainHash]
+	if !ok {
+		b = &bucket{tokens: float64(l.Burst), last: now}
+		l.buckets[domainHash] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / l.rate()
+		return false, time.Duration(math.Ceil(wait)) * time.Second
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rate returns the number of tokens added per second
+func (l *Limiter) rate() float64 {
+	return float64(l.PerHour) / time.Hour.Seconds()
+}
+
+// refill returns the tokens in the bucket at the provided time
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*l.rate()
+	return math.Min(tokens, float64(l.Burst))
+}
+
+// RateLimit limits requests to our POST endpoints by receiving domain. The
+// domain is taken from the URL when present, or from the recipient in the
+// request body otherwise. Requests without a valid recipient are passed
+// through so that the handler can reject them.
+func RateLimit(l *Limiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hash := domainHash(r)
+			if hash == "" {
+				hash = recipientDomainHash(r)
+			}
+			if hash != "" {
+				ok, wait := l.Allow(hash)
+				if !ok {
+					w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+					http.Error(w, ErrRateLimited.Error(), http.StatusTooManyRequests)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// recipientDomainHash returns the identifier of the recipient's domain in the
+// request body, leaving the body untouched for the handler.
+func recipientDomainHash(r *http.Request) string {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return ""
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req := struct {
+		Recipient string `json:"recipient"`
+	}{}
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return ""
+	}
+	hash, err := db.DomainIDFromAddress(req.Recipient)
+	if err != nil {
+		return ""
+	}
+	return hash
+}
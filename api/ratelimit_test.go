@@ -0,0 +1,137 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/gophish/healthcheck/config"
+)
+
+// testLimiter returns a limiter whose clock is moved forward with the
+// returned function.
+func testLimiter(perHour, burst int) (*Limiter, func(time.Duration)) {
+	l := NewLimiter(perHour, burst)
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestLimiterBurst(t *testing.T) {
+	l, _ := testLimiter(60, 3)
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("hash")
+		if !ok {
+			t.Fatalf("Unexpected rate limit for message %d of the burst", i+1)
+		}
+	}
+	ok, wait := l.Allow("hash")
+	if ok {
+		t.Fatal("Expected the message after the burst to be rate limited")
+	}
+	if wait != time.Minute {
+		t.Fatalf("Unexpected wait. Expected %s Got %s", time.Minute, wait)
+	}
+}
+
+func TestLimiterRefill(t *testing.T) {
+	l, advance := testLimiter(60, 2)
+	l.Allow("hash")
+	l.Allow("hash")
+	advance(30 * time.Second)
+	ok, wait := l.Allow("hash")
+	if ok || wait != 30*time.Second {
+		t.Fatalf("Unexpected result half way through the refill. Expected a wait of 30s Got %v %s", ok, wait)
+	}
+	advance(30 * time.Second)
+	ok, _ = l.Allow("hash")
+	if !ok {
+		t.Fatal("Unexpected rate limit after a token was added")
+	}
+	// Buckets don't fill up past the burst
+	advance(time.Hour)
+	for i := 0; i < 2; i++ {
+		l.Allow("hash")
+	}
+	ok, _ = l.Allow("hash")
+	if ok {
+		t.Fatal("Expected the bucket to hold at most the burst")
+	}
+}
+
+func TestLimiterDomains(t *testing.T) {
+	l, _ := testLimiter(60, 1)
+	ok, _ := l.Allow("first")
+	if !ok {
+		t.Fatal("Unexpected rate limit for the first domain")
+	}
+	ok, _ = l.Allow("second")
+	if !ok {
+		t.Fatal("Unexpected rate limit for the second domain")
+	}
+	ok, _ = l.Allow("first")
+	if ok {
+		t.Fatal("Expected the first domain to be rate limited")
+	}
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	l, _ := testLimiter(0, 0)
+	for i := 0; i < 10; i++ {
+		ok, _ := l.Allow("hash")
+		if !ok {
+			t.Fatal("Unexpected rate limit when rate limiting is disabled")
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	config.Config.SecretKey = testSecretKey
+	l, _ := testLimiter(60, 1)
+	r := chi.NewRouter()
+	// The handler still gets the request body once the recipient is read
+	body := ""
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+	}
+	// The middleware is applied to the routes so that it sees the URL
+	// parameters, like in the API router
+	r.With(RateLimit(l)).Post("/messages/", ok)
+	r.With(RateLimit(l)).Post("/domains/{domainHash}/runs", ok)
+
+	tests := []struct {
+		path     string
+		body     string
+		expected int
+	}{
+		{"/messages/", `{"recipient":"test@example.com"}`, http.StatusOK},
+		// Recipients at the same domain share a bucket
+		{"/messages/", `{"recipient":"other@example.com"}`, http.StatusTooManyRequests},
+		{"/messages/", `{"recipient":"test@example.org"}`, http.StatusOK},
+		// Domains in the URL share the bucket of their recipients
+		{"/domains/example.org/runs", `{}`, http.StatusTooManyRequests},
+		{"/domains/example.net/runs", `{}`, http.StatusOK},
+		// Requests without a recipient are left to the handler
+		{"/messages/", `{}`, http.StatusOK},
+		{"/messages/", `{}`, http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", test.path, strings.NewReader(test.body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != test.expected {
+			t.Fatalf("Unexpected status code for %s %s. Expected %d Got %d", test.path, test.body, test.expected, w.Code)
+		}
+		if w.Code == http.StatusOK && body != test.body {
+			t.Fatalf("Unexpected request body for %s. Expected %s Got %s", test.path, test.body, body)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "60" {
+			t.Fatalf("Unexpected Retry-After header. Expected 60 Got %s", w.Header().Get("Retry-After"))
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/gophish/healthcheck/db"
+	"github.com/gophish/healthcheck/events"
+
+	"github.com/go-chi/chi"
+)
+
+// StreamPollInterval is how often event streams check for new DNS queries
+// after the messages changed status. The DNS plugin may run in a different
+// process, so its lookups are read from the query log rather than the event
+// bus.
+var StreamPollInterval = time.Second
+
+// StreamMaxPollInterval is the longest interval between checks for new DNS
+// queries. The interval doubles every time no new query is found, up to this
+// interval, since lookups mostly happen right after a message is sent.
+var StreamMaxPollInterval = 30 * time.Second
+
+// StreamHeartbeatInterval is how often a comment is sent on idle event
+// streams so that proxies don't close the connection.
+var StreamHeartbeatInterval = 15 * time.Second
+
+// GetMessageEvents streams the lifecycle events for the requested message as
+// Server-Sent Events, until the client disconnects. DNS lookups already
+// received for the message are sent first.
+func GetMessageEvents(w http.ResponseWriter, r *http.Request) {
+	m := r.Context().Value("message").(*db.Message)
+	streamEvents(w, r, []db.Message{*m}, func(e events.Event) bool {
+		return e.MessageID == m.MessageID
+	})
+}
+
+// GetRunEvents streams the lifecycle events for every message in the
+// requested run as Server-Sent Events, until the client disconnects.
+func GetRunEvents(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "runID"), 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	run, err := getStore(r).GetRun(uint(id), orgID(r))
+	if err != nil || !allowsDomain(r, run.DomainHash) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	streamEvents(w, r, run.Messages, func(e events.Event) bool {
+		return e.RunID == run.ID && e.OrgID == run.OrgID
+	})
+}
+
+// streamEvents writes the published events matching the filter, along with
+// the DNS queries logged for the messages, as Server-Sent Events.
+func streamEvents(w http.ResponseWriter, r *http.Request, messages []db.Message, match func(events.Event) bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	// DNS lookups are sent from the query log, so they aren't duplicated
+	// when the DNS plugin runs in this process.
+	sub := events.SubscribeFunc(events.DefaultBufferSize, func(e events.Event) bool {
+		return e.Type != events.DNSLookup && match(e)
+	})
+	defer events.Unsubscribe(sub)
+
+	byID := map[string]*db.Message{}
+	ids := []string{}
+	for i := range messages {
+		byID[messages[i].MessageID] = &messages[i]
+		ids = append(ids, messages[i].MessageID)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	interval := StreamPollInterval
+	poll := time.NewTimer(interval)
+	defer poll.Stop()
+	heartbeat := time.NewTicker(StreamHeartbeatInterval)
+	defer heartbeat.Stop()
+	lastQuery := uint(0)
+	sendQueries := func() error {
+		if len(ids) == 0 {
+			return nil
+		}
+		queries, err := getStore(r).GetDNSQueriesSince(ids, lastQuery)
+		if err != nil {
+			return err
+		}
+		interval *= 2
+		if len(queries) > 0 {
+			interval = StreamPollInterval
+		}
+		if interval > StreamMaxPollInterval {
+			interval = StreamMaxPollInterval
+		}
+		for _, q := range queries {
+			lastQuery = q.ID
+			err = writeEvent(w, q.Event(byID[q.MessageID]))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err := sendQueries()
+	for err == nil {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			err = writeEvent(w, e)
+			// Lookups are likely to follow a change of status, so we
+			// check for them sooner
+			interval = StreamPollInterval
+			poll.Reset(interval)
+		case <-poll.C:
+			err = sendQueries()
+			poll.Reset(interval)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		flusher.Flush()
+	}
+	log.Error(err)
+}
+
+// writeEvent writes a single Server-Sent Event, using the event type as the
+// SSE event name
+func writeEvent(w http.ResponseWriter, e events.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+	return err
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gophish/healthcheck/db"
+
+	"github.com/go-chi/chi"
+)
+
+// DefaultDeliveryLimit is the number of webhook deliveries returned by
+// default.
+const DefaultDeliveryLimit = 100
+
+// WebhookRequest is the request used to register a webhook. A secret is
+// generated if none is provided.
+type WebhookRequest struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// WebhookResponse is the response returned when registering a webhook. It's
+// the only time the secret is returned.
+type WebhookResponse struct {
+	*db.Webhook
+	Secret string `json:"secret"`
+}
+
+// webhookDomainHash returns the domain hash webhooks are scoped to. Webhooks
+// registered outside of a domain receive events for the whole organization.
+func webhookDomainHash(r *http.Request) string {
+	if domain, ok := r.Context().Value("domain").(*db.Domain); ok {
+		return domain.DomainHash
+	}
+	return ""
+}
+
+// GetWebhooks returns the registered webhooks
+func GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := getStore(r).GetWebhooks(orgID(r), webhookDomainHash(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, webhooks, http.StatusOK)
+}
+
+// PostWebhook registers a new webhook
+func PostWebhook(w http.ResponseWriter, r *http.Request) {
+	wr := WebhookRequest{}
+	err := json.NewDecoder(r.Body).Decode(&wr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hook := &db.Webhook{
+		OrgID:      orgID(r),
+		DomainHash: webhookDomainHash(r),
+		URL:        wr.URL,
+		Secret:     wr.Secret,
+	}
+	err = getStore(r).PostWebhook(hook)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	JSONResponse(w, WebhookResponse{hook, hook.Secret}, http.StatusCreated)
+}
+
+// DeleteWebhook deletes a webhook
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "webhookID"), 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	err = getStore(r).DeleteWebhook(uint(id), orgID(r), webhookDomainHash(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetWebhookDeliveries returns the delivery log for a webhook
+func GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "webhookID"), 10, 64)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	hook, err := getStore(r).GetWebhook(uint(id), orgID(r), webhookDomainHash(r))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	deliveries, err := getStore(r).GetWebhookDeliveries(hook.ID, DefaultDeliveryLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	JSONResponse(w, deliveries, http.StatusOK)
+}
+
+// webhookRoutes registers the webhook endpoints on the router. Webhooks can
+// be used to exfiltrate results, so every endpoint requires the send scope.
+func webhookRoutes(r chi.Router) {
+	r.Use(RequireScope(db.ScopeSend))
+	r.Get("/", GetWebhooks)
+	r.Post("/", PostWebhook)
+	r.Delete("/{webhookID}", DeleteWebhook)
+	r.Get("/{webhookID}/deliveries", GetWebhookDeliveries)
+}
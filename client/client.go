@@ -0,0 +1,306 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is the timeout for a single API request. Sending a message
+// waits for the mail server to respond, so this is longer than the SMTP
+// timeout.
+const DefaultTimeout = 2 * time.Minute
+
+// DefaultPollInterval is how often Wait checks the status of messages.
+const DefaultPollInterval = 5 * time.Second
+
+// APIError is returned when the API responds with an error status code.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// MailServerMX is the mail server used to deliver to the recipient's MX
+const MailServerMX = "mx"
+
+const (
+	// OutcomeBlocked indicates the mail server rejected the message
+	OutcomeBlocked = "blocked"
+	// OutcomeDelivered indicates the mail server accepted the message
+	OutcomeDelivered = "delivered"
+	// ResultPass indicates the message had the outcome expected from its
+	// scenario
+	ResultPass = "pass"
+)
+
+// Message is a message returned by the API. The outcome, expected outcome
+// and result are computed by the server, and are empty until they're known.
+type Message struct {
+	ID            uint            `json:"id"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+	Recipient     string          `json:"recipient"`
+	MailServer    string          `json:"mail_server"`
+	MessageID     string          `json:"message_id"`
+	DomainHash    string          `json:"domain_hash"`
+	RunID         uint            `json:"run_id,omitempty"`
+	Scenario      string          `json:"scenario,omitempty"`
+	Status        string          `json:"status"`
+	ErrorMessage  string          `json:"error_message"`
+	TLS           json.RawMessage `json:"tls,omitempty"`
+	Configuration json.RawMessage `json:"configuration,omitempty"`
+	Pending       bool            `json:"pending"`
+	Outcome       string          `json:"outcome,omitempty"`
+	Expected      string          `json:"expected,omitempty"`
+	Result        string          `json:"result,omitempty"`
+}
+
+// Run is a run returned by the API, along with its messages
+type Run struct {
+	ID         uint      `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	DomainHash string    `json:"domain_hash"`
+	ScheduleID uint      `json:"schedule_id"`
+	Messages   []Message `json:"messages,omitempty"`
+}
+
+// Scenario is a scenario available to runs
+type Scenario struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Category    string `json:"category"`
+	Blocked     bool   `json:"blocked"`
+	Quarantine  bool   `json:"quarantine"`
+	Remediation string `json:"remediation"`
+}
+
+// MessageRequest is the request used to send a single message using a
+// scenario.
+type MessageRequest struct {
+	Recipient  string `json:"recipient"`
+	MailServer string `json:"mail_server"`
+	Scenario   string `json:"scenario"`
+}
+
+// RunRequest is the request used to launch a run.
+type RunRequest struct {
+	Recipient  string   `json:"recipient"`
+	MailServer string   `json:"mail_server"`
+	Scenarios  []string `json:"scenarios"`
+}
+
+// Client talks to the Healthcheck API.
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+	// PollInterval is how often Wait checks the status of messages.
+	PollInterval time.Duration
+}
+
+// New returns a client for the API at the base URL, authenticating with the
+// API key.
+func New(baseURL, apiKey string) *Client {
+	return &Client{
+		BaseURL:      strings.TrimSuffix(baseURL, "/"),
+		APIKey:       apiKey,
+		HTTPClient:   &http.Client{Timeout: DefaultTimeout},
+		PollInterval: DefaultPollInterval,
+	}
+}
+
+// request sends the request, returning the response if it has a 2xx status
+// code. The caller must close the response body.
+func (c *Client) request(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.BaseURL+path, r)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// do sends the request, decoding the JSON response into out
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	resp, err := c.request(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// SendMessage sends a single message, returning it once the mail server
+// responded.
+func (c *Client) SendMessage(ctx context.Context, m MessageRequest) (*Message, error) {
+	sent := &Message{}
+	err := c.do(ctx, "POST", "/messages/", m, sent)
+	return sent, err
+}
+
+// GetMessage returns the message with the provided ID
+func (c *Client) GetMessage(ctx context.Context, id string) (*Message, error) {
+	m := &Message{}
+	err := c.do(ctx, "GET", "/messages/"+url.PathEscape(id), nil, m)
+	return m, err
+}
+
+// PostRun launches a run against the domain. Domains can be referenced by
+// name or by identifier in every method.
+func (c *Client) PostRun(ctx context.Context, domain string, rr RunRequest) (*Run, error) {
+	run := &Run{}
+	err := c.do(ctx, "POST", fmt.Sprintf("/domains/%s/runs", url.PathEscape(domain)), rr, run)
+	return run, err
+}
+
+// GetRun returns the run with the provided ID, along with its messages
+func (c *Client) GetRun(ctx context.Context, id uint) (*Run, error) {
+	run := &Run{}
+	err := c.do(ctx, "GET", fmt.Sprintf("/runs/%d", id), nil, run)
+	return run, err
+}
+
+// GetRuns returns the runs for the domain, most recent first
+func (c *Client) GetRuns(ctx context.Context, domain string) ([]Run, error) {
+	runs := []Run{}
+	err := c.do(ctx, "GET", fmt.Sprintf("/domains/%s/runs", url.PathEscape(domain)), nil, &runs)
+	return runs, err
+}
+
+// GetScenarios returns the scenarios available to runs
+func (c *Client) GetScenarios(ctx context.Context) ([]Scenario, error) {
+	scenarios := []Scenario{}
+	err := c.do(ctx, "GET", "/scenarios", nil, &scenarios)
+	return scenarios, err
+}
+
+// GetReport returns the report for the domain, as returned by the API
+func (c *Client) GetReport(ctx context.Context, domain string) (json.RawMessage, error) {
+	rep := json.RawMessage{}
+	err := c.do(ctx, "GET", fmt.Sprintf("/domains/%s/report", url.PathEscape(domain)), nil, rep)
+	return rep, err
+}
+
+// Export writes the export matching the parameters to w. If a domain is
+// provided, only messages sent to that domain are exported.
+func (c *Client) Export(ctx context.Context, w io.Writer, domain string, params url.Values) error {
+	path := "/export"
+	if domain != "" {
+		path = fmt.Sprintf("/domains/%s/export", url.PathEscape(domain))
+	}
+	resp, err := c.request(ctx, "GET", path+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// WaitForRun polls the run until none of its messages are queued or
+// deferred, or the context is cancelled.
+func (c *Client) WaitForRun(ctx context.Context, id uint) (*Run, error) {
+	for {
+		run, err := c.GetRun(ctx, id)
+		if err != nil {
+			return run, err
+		}
+		if !Pending(run.Messages...) {
+			return run, nil
+		}
+		select {
+		case <-ctx.Done():
+			return run, ctx.Err()
+		case <-time.After(c.PollInterval):
+		}
+	}
+}
+
+// WaitForMessage polls the message until it's no longer queued or deferred,
+// or the context is cancelled.
+func (c *Client) WaitForMessage(ctx context.Context, id string) (*Message, error) {
+	for {
+		m, err := c.GetMessage(ctx, id)
+		if err != nil {
+			return m, err
+		}
+		if !m.Pending {
+			return m, nil
+		}
+		select {
+		case <-ctx.Done():
+			return m, ctx.Err()
+		case <-time.After(c.PollInterval):
+		}
+	}
+}
+
+// Pending returns whether any of the messages may still change status
+func Pending(messages ...Message) bool {
+	for _, m := range messages {
+		if m.Pending {
+			return true
+		}
+	}
+	return false
+}
+
+// Unblocked returns the messages using a scenario which should have been
+// blocked, but were delivered. Messages which were accepted and then
+// quarantined aren't included.
+func Unblocked(messages ...Message) []Message {
+	unblocked := []Message{}
+	for _, m := range messages {
+		if m.Outcome == OutcomeDelivered && m.Expected == OutcomeBlocked && m.Result != ResultPass {
+			unblocked = append(unblocked, m)
+		}
+	}
+	return unblocked
+}
+
+// Failed returns the messages which couldn't be delivered or rejected, e.g.
+// because the mail server couldn't be reached.
+func Failed(messages ...Message) []Message {
+	failed := []Message{}
+	for _, m := range messages {
+		if !m.Pending && m.Outcome == "" {
+			failed = append(failed, m)
+		}
+	}
+	return failed
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestAuthentication(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(Message{MessageID: "abc", Status: "sent", Outcome: OutcomeDelivered})
+	}))
+	defer ts.Close()
+
+	m, err := New(ts.URL, "key").GetMessage(context.Background(), "abc")
+	if err != nil {
+		t.Fatalf("Unexpected error getting message: %v", err)
+	}
+	if m.MessageID != "abc" {
+		t.Fatalf("Unexpected message returned: %#v", m)
+	}
+
+	_, err = New(ts.URL, "invalid").GetMessage(context.Background(), "abc")
+	apiErr, ok := err.(*APIError)
+	if !ok || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Unexpected error with an invalid key: %v", err)
+	}
+}
+
+func TestWaitForRun(t *testing.T) {
+	requests := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		m := Message{Scenario: "spf_hardfail", Status: "queued", Pending: true}
+		if requests > 1 {
+			m = Message{Scenario: "spf_hardfail", Status: "rejected", Outcome: OutcomeBlocked}
+		}
+		json.NewEncoder(w).Encode(Run{ID: 1, Messages: []Message{m}})
+	}))
+	defer ts.Close()
+
+	c := New(ts.URL, "key")
+	c.PollInterval = time.Millisecond
+	run, err := c.WaitForRun(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Unexpected error waiting for run: %v", err)
+	}
+	if requests != 2 || run.Messages[0].Status != "rejected" {
+		t.Fatalf("Unexpected run after %d requests: %#v", requests, run)
+	}
+}
+
+func TestUnblocked(t *testing.T) {
+	messages := []Message{
+		{MessageID: "1", Scenario: "baseline", Outcome: OutcomeDelivered, Expected: OutcomeDelivered, Result: ResultPass},
+		{MessageID: "2", Scenario: "spf_hardfail", Outcome: OutcomeBlocked, Expected: OutcomeBlocked, Result: ResultPass},
+		{MessageID: "3", Scenario: "spf_softfail", Outcome: OutcomeDelivered, Expected: OutcomeBlocked, Result: "fail"},
+		{MessageID: "4", Scenario: "dmarc_quarantine", Outcome: OutcomeDelivered, Expected: OutcomeBlocked, Result: ResultPass},
+		{MessageID: "5", Scenario: "no_mx", Pending: true},
+	}
+	unblocked := Unblocked(messages...)
+	if len(unblocked) != 1 || unblocked[0].MessageID != "3" {
+		t.Fatalf("Unexpected unblocked messages: %#v", unblocked)
+	}
+}
+
+func TestFailed(t *testing.T) {
+	messages := []Message{
+		{MessageID: "1", Status: "sent", Outcome: OutcomeDelivered},
+		{MessageID: "2", Status: "failed"},
+		{MessageID: "3", Status: "deferred", Pending: true},
+	}
+	failed := Failed(messages...)
+	if len(failed) != 1 || failed[0].MessageID != "2" {
+		t.Fatalf("Unexpected failed messages: %#v", failed)
+	}
+}
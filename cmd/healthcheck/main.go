@@ -0,0 +1,290 @@
+// Command healthcheck runs healthchecks against the Healthcheck API from
+// scripts and CI pipelines.
+//
+// It exits with status 2 if any scenario which should have been blocked was
+// delivered, and status 1 for any other error.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gophish/healthcheck/client"
+	"github.com/gophish/healthcheck/util"
+)
+
+const (
+	// ExitError is the exit code used when a command fails
+	ExitError = 1
+	// ExitUnblocked is the exit code used when a scenario which should have
+	// been blocked was delivered
+	ExitUnblocked = 2
+)
+
+// errUnblocked is returned by commands when a scenario which should have been
+// blocked was delivered
+var errUnblocked = errors.New("a scenario which should have been blocked was delivered")
+
+// errFailed is returned by commands when a message couldn't be delivered or
+// rejected, e.g. because the mail server couldn't be reached
+var errFailed = errors.New("a message couldn't be sent")
+
+const usage = `Usage: healthcheck [flags] <command> [command flags]
+
+Commands:
+  send      Send a single scenario and wait for the result
+  run       Run a suite of scenarios against a domain and wait for the results
+  message   Show a message
+  runs      List the runs for a domain
+  report    Show the report for a domain
+  export    Export results as CSV, NDJSON or JUnit XML
+
+Flags:
+`
+
+type command func(ctx context.Context, c *client.Client, args []string) error
+
+var commands = map[string]command{
+	"send":    sendCommand,
+	"run":     runCommand,
+	"message": messageCommand,
+	"runs":    runsCommand,
+	"report":  reportCommand,
+	"export":  exportCommand,
+}
+
+func main() {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	baseURL := fs.String("url", envOr("HEALTHCHECK_URL", "http://localhost:3000"), "Healthcheck API URL (or HEALTHCHECK_URL)")
+	apiKey := fs.String("key", os.Getenv("HEALTHCHECK_API_KEY"), "API key (or HEALTHCHECK_API_KEY)")
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(os.Args[1:])
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", fs.Arg(0))
+		fs.Usage()
+		os.Exit(ExitError)
+	}
+	err := cmd(context.Background(), client.New(*baseURL, *apiKey), fs.Args()[1:])
+	if err == errUnblocked {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ExitUnblocked)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(ExitError)
+	}
+}
+
+// envOr returns the environment variable, or the fallback if it isn't set
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// waitContext returns a context which is cancelled after the timeout, or
+// never if the timeout is zero
+func waitContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func sendCommand(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	recipient := fs.String("recipient", "", "recipient address")
+	mailServer := fs.String("mail-server", client.MailServerMX, "mail server to deliver to, or mx to use the recipient's MX")
+	name := fs.String("scenario", "", "scenario to send")
+	timeout := fs.Duration("timeout", 10*time.Minute, "how long to wait for a deferred message (0 waits forever)")
+	fs.Parse(args)
+	m, err := c.SendMessage(ctx, client.MessageRequest{
+		Recipient:  *recipient,
+		MailServer: *mailServer,
+		Scenario:   *name,
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := waitContext(ctx, *timeout)
+	defer cancel()
+	m, err = c.WaitForMessage(ctx, m.MessageID)
+	if err != nil {
+		return err
+	}
+	return printResults(os.Stdout, *m)
+}
+
+func runCommand(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	recipient := fs.String("recipient", "", "recipient address, at a verified domain")
+	mailServer := fs.String("mail-server", client.MailServerMX, "mail server to deliver to, or mx to use the recipient's MX")
+	scenarios := fs.String("scenarios", "", "comma-separated list of scenarios (defaults to every scenario)")
+	timeout := fs.Duration("timeout", 10*time.Minute, "how long to wait for deferred messages (0 waits forever)")
+	fs.Parse(args)
+	// The server identifies domains using a secret key, so we send the
+	// domain name and let it compute the identifier.
+	domain, err := util.DomainFromAddress(*recipient)
+	if err != nil {
+		return err
+	}
+	rr := client.RunRequest{
+		Recipient:  *recipient,
+		MailServer: *mailServer,
+		Scenarios:  []string{},
+	}
+	if *scenarios != "" {
+		rr.Scenarios = strings.Split(*scenarios, ",")
+	} else {
+		all, err := c.GetScenarios(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range all {
+			rr.Scenarios = append(rr.Scenarios, s.Name)
+		}
+	}
+	run, err := c.PostRun(ctx, domain, rr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Started run %d\n", run.ID)
+	ctx, cancel := waitContext(ctx, *timeout)
+	defer cancel()
+	run, err = c.WaitForRun(ctx, run.ID)
+	if err != nil {
+		return err
+	}
+	return printResults(os.Stdout, run.Messages...)
+}
+
+func messageCommand(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: healthcheck message <message id>")
+	}
+	m, err := c.GetMessage(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return printJSON(os.Stdout, m)
+}
+
+func runsCommand(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("runs", flag.ExitOnError)
+	domain := fs.String("domain", "", "domain to list the runs for")
+	fs.Parse(args)
+	if fs.NArg() == 1 {
+		id, err := strconv.ParseUint(fs.Arg(0), 10, 64)
+		if err != nil {
+			return err
+		}
+		run, err := c.GetRun(ctx, uint(id))
+		if err != nil {
+			return err
+		}
+		return printResults(os.Stdout, run.Messages...)
+	}
+	runs, err := c.GetRuns(ctx, *domain)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN\tSTARTED\tSCHEDULE")
+	for _, run := range runs {
+		fmt.Fprintf(tw, "%d\t%s\t%d\n", run.ID, run.CreatedAt.Format(time.RFC3339), run.ScheduleID)
+	}
+	return tw.Flush()
+}
+
+func reportCommand(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	domain := fs.String("domain", "", "domain to show the report for")
+	fs.Parse(args)
+	rep, err := c.GetReport(ctx, *domain)
+	if err != nil {
+		return err
+	}
+	return printJSON(os.Stdout, rep)
+}
+
+func exportCommand(ctx context.Context, c *client.Client, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	domain := fs.String("domain", "", "only export messages sent to this domain")
+	format := fs.String("format", "junit", "export format (csv, ndjson or junit)")
+	scenario := fs.String("scenario", "", "only export messages sent using this scenario")
+	from := fs.String("from", "", "only export messages created at or after this time (RFC3339)")
+	to := fs.String("to", "", "only export messages created before this time (RFC3339)")
+	output := fs.String("o", "", "file to write the export to (defaults to stdout)")
+	fs.Parse(args)
+	params := url.Values{"format": {*format}}
+	for name, value := range map[string]string{"scenario": *scenario, "from": *from, "to": *to} {
+		if value != "" {
+			params[name] = []string{value}
+		}
+	}
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return c.Export(ctx, w, *domain, params)
+}
+
+// printResults writes a table of the messages and their outcomes, returning
+// errUnblocked if a scenario which should have been blocked was delivered, or
+// errFailed if a message couldn't be sent.
+func printResults(w io.Writer, messages ...client.Message) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCENARIO\tSTATUS\tEXPECTED\tRESULT\tMESSAGE")
+	for _, m := range messages {
+		result := m.Result
+		switch {
+		case m.Pending:
+			result = "pending"
+		case m.Outcome == "":
+			result = "error"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", m.Scenario, m.Status, m.Expected, result, m.MessageID)
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	if len(client.Unblocked(messages...)) > 0 {
+		return errUnblocked
+	}
+	if len(client.Failed(messages...)) > 0 {
+		return errFailed
+	}
+	return nil
+}
+
+// printJSON writes the value as indented JSON
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
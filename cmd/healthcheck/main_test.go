@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gophish/healthcheck/client"
+)
+
+func TestPrintResults(t *testing.T) {
+	delivered := client.Message{Scenario: "baseline", Status: "received", Outcome: client.OutcomeDelivered, Expected: client.OutcomeDelivered, Result: client.ResultPass}
+	blocked := client.Message{Scenario: "spf_hardfail", Status: "rejected", Outcome: client.OutcomeBlocked, Expected: client.OutcomeBlocked, Result: client.ResultPass}
+	unblocked := client.Message{Scenario: "spf_hardfail", Status: "received", Outcome: client.OutcomeDelivered, Expected: client.OutcomeBlocked, Result: "fail"}
+	failed := client.Message{Scenario: "baseline", Status: "failed", Expected: client.OutcomeDelivered}
+	tests := []struct {
+		messages []client.Message
+		expected error
+	}{
+		{[]client.Message{delivered, blocked}, nil},
+		{[]client.Message{delivered, unblocked}, errUnblocked},
+		{[]client.Message{delivered, failed}, errFailed},
+		{[]client.Message{failed, unblocked}, errUnblocked},
+	}
+	for _, test := range tests {
+		buff := &bytes.Buffer{}
+		err := printResults(buff, test.messages...)
+		if err != test.expected {
+			t.Fatalf("Unexpected error printing results. Expected %v Got %v", test.expected, err)
+		}
+	}
+}
+
+func TestPrintResultsError(t *testing.T) {
+	buff := &bytes.Buffer{}
+	printResults(buff, client.Message{Scenario: "baseline", Status: "failed", MessageID: "abc"})
+	if !bytes.Contains(buff.Bytes(), []byte("error")) {
+		t.Fatalf("Unexpected output for a failed message: %s", buff.String())
+	}
+}
@@ -1,8 +1,17 @@
 package config
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"flag"
+	"fmt"
 	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	log "github.com/gophish/gophish/logger"
 )
@@ -14,30 +23,384 @@ const DMARCPrefix = "_dmarc"
 // Note: this is specified by Healthcheck when sending emails.
 const DKIMPrefix = "_dkim"
 
+// DefaultConfigPath is the configuration file loaded when no other file is
+// set with the -config flag or the HEALTHCHECK_CONFIG environment variable.
+// It's optional, so that the configuration can be set entirely with
+// environment variables and flags.
+const DefaultConfigPath = "./config.json"
+
+// EnvPrefix is prepended to the upper-cased configuration key to build the
+// environment variable overriding it, such as HEALTHCHECK_DB_PATH.
+const EnvPrefix = "HEALTHCHECK_"
+
+// ConfigPathEnv is the environment variable setting the configuration file.
+const ConfigPathEnv = EnvPrefix + "CONFIG"
+
+// DefaultListenAddr is the address the API server listens on.
+const DefaultListenAddr = ":3000"
+
+// DefaultDNSListenAddr is the address the embedded DNS server listens on when
+// running in serve mode.
+const DefaultDNSListenAddr = ":53"
+
+// DefaultSMTPDialTimeoutSeconds is how long we wait to connect to a mail
+// server.
+const DefaultSMTPDialTimeoutSeconds = 30
+
+// DefaultSMTPTimeoutSeconds is the maximum amount of time spent in a single
+// SMTP session.
+const DefaultSMTPTimeoutSeconds = 30
+
+// DefaultDNSTTL is the TTL in seconds of the records served by the DNS
+// plugin.
+const DefaultDNSTTL = 300
+
+// DefaultDNSLongTTL is the TTL in seconds of the records served for messages
+// testing long TTLs, and of the record they include.
+const DefaultDNSLongTTL = 7 * 24 * 60 * 60
+
+// DefaultRateLimitBurst is the number of messages which can be sent to a
+// domain at once when rate limiting is enabled.
+const DefaultRateLimitBurst = 10
+
+// BouncePrefix is the DNS label prepended to the message domain to build an
+// envelope sender domain that is a subdomain of the header From domain.
+const BouncePrefix = "bounce"
+
+// LongTTLPrefix is the DNS label prepended to the email hostname to build the
+// name served with a long TTL. The name stays the same across runs, so that
+// resolvers answer it from their cache in later runs.
+const LongTTLPrefix = "_ttl"
+
+// SecretKeyLength is the number of bytes in the secret key used to identify
+// and encrypt domains.
+const SecretKeyLength = 32
+
+// ErrInvalidSecretKey occurs when the secret key isn't set to a hex-encoded
+// key of the right length.
+var ErrInvalidSecretKey = fmt.Errorf("secret_key must be set to %d hex-encoded bytes: generate one with `openssl rand -hex %d` and set it in the configuration file or with %sSECRET_KEY", SecretKeyLength, SecretKeyLength, EnvPrefix)
+
 type Conf struct {
-	DBName         string `json:"db_name,omitempty"`
-	DBPath         string `json:"db_path,omitempty"`
-	EmailHostname  string `json:"email_hostname,omitempty"`
-	MigrationsPath string `json:"migrations_path,omitempty"`
+	DBName            string `json:"db_name,omitempty"`
+	DBPath            string `json:"db_path,omitempty"`
+	EmailHostname     string `json:"email_hostname,omitempty"`
+	EnvelopeHostname  string `json:"envelope_hostname,omitempty"`
+	LookalikeHostname string `json:"lookalike_hostname,omitempty"`
+	MigrationsPath    string `json:"migrations_path,omitempty"`
+	DNSSECResolver    string `json:"dnssec_resolver,omitempty"`
+	ListenAddr        string `json:"listen_addr,omitempty"`
+	DNSListenAddr     string `json:"dns_listen_addr,omitempty"`
+	SecretKey         string `json:"secret_key,omitempty"`
+	StoreRecipients   bool   `json:"store_recipients,omitempty"`
+
+	// SMTP timeouts in seconds
+	SMTPDialTimeoutSeconds int `json:"smtp_dial_timeout_seconds,omitempty"`
+	SMTPTimeoutSeconds     int `json:"smtp_timeout_seconds,omitempty"`
+
+	// Messages sent to a single receiving domain per hour. Zero disables
+	// rate limiting.
+	RateLimitPerHour int `json:"rate_limit_per_hour,omitempty"`
+	RateLimitBurst   int `json:"rate_limit_burst,omitempty"`
+
+	// TTLs in seconds of the records served by the DNS plugin
+	DNSTTL     uint32 `json:"dns_ttl,omitempty"`
+	DNSLongTTL uint32 `json:"dns_long_ttl,omitempty"`
+
+	// Retention periods in days. Zero keeps the data forever.
+	MessageRetentionDays      int `json:"message_retention_days,omitempty"`
+	DNSRetentionDays          int `json:"dns_retention_days,omitempty"`
+	PersonalDataRetentionDays int `json:"personal_data_retention_days,omitempty"`
 }
 
 var Config Conf
 
-// LoadConfig loads the configuration from the specified filepath
+// defaults returns the configuration used for keys which aren't set
+func defaults() Conf {
+	return Conf{
+		ListenAddr:             DefaultListenAddr,
+		DNSListenAddr:          DefaultDNSListenAddr,
+		SMTPDialTimeoutSeconds: DefaultSMTPDialTimeoutSeconds,
+		SMTPTimeoutSeconds:     DefaultSMTPTimeoutSeconds,
+		RateLimitBurst:         DefaultRateLimitBurst,
+		DNSTTL:                 DefaultDNSTTL,
+		DNSLongTTL:             DefaultDNSLongTTL,
+	}
+}
+
+// flagOverrides holds the values set with the flags defined by RegisterFlags,
+// keyed by configuration key.
+var flagOverrides = map[string]string{}
+
+// Path returns the configuration file set with HEALTHCHECK_CONFIG, falling
+// back to DefaultConfigPath.
+func Path() string {
+	if path := os.Getenv(ConfigPathEnv); path != "" {
+		return path
+	}
+	return DefaultConfigPath
+}
+
+// LoadConfig loads the configuration from the specified filepath, which may
+// only be missing when it's DefaultConfigPath. Keys are then overridden by
+// environment variables and by the flags defined with RegisterFlags, in that
+// order. Every problem found in the resulting configuration is returned at
+// once as a ValidationError.
 func LoadConfig(filepath string) error {
+	c, err := Load(filepath, nil)
+	if err != nil {
+		return err
+	}
+	Config = c
+	return nil
+}
+
+// Load returns the configuration loaded like LoadConfig, with the provided
+// overrides, keyed by configuration key, applied last. The global Config
+// isn't changed, so the caller can decide when to use the configuration.
+func Load(filepath string, overrides map[string]string) (Conf, error) {
+	c := defaults()
 	// Get the config file
 	configFile, err := ioutil.ReadFile(filepath)
-	if err != nil {
+	switch {
+	case os.IsNotExist(err) && filepath == DefaultConfigPath:
+		// The configuration is set with environment variables and flags
+	case err != nil:
 		log.Errorf("File error: %v\n", err)
-		return err
+		return c, err
+	default:
+		err = json.Unmarshal(configFile, &c)
+		if err != nil {
+			log.Errorf("error unmarshaling config: %s", err.Error())
+			return c, err
+		}
 	}
-	err = json.Unmarshal(configFile, &Config)
-	if err != nil {
-		log.Errorf("error unmarshaling config: %s", err.Error())
-		return err
+
+	errs := ValidationError{}
+	for _, k := range keys() {
+		if value, ok := os.LookupEnv(k.env()); ok {
+			errs = errs.add(k.set(&c, value))
+		}
+	}
+	for _, o := range []map[string]string{flagOverrides, overrides} {
+		for _, k := range keys() {
+			if value, ok := o[k.name]; ok {
+				errs = errs.add(k.set(&c, value))
+			}
+		}
 	}
 
 	// Choosing the migrations directory based on the database used.
-	Config.MigrationsPath = Config.MigrationsPath + Config.DBName
+	c.MigrationsPath = c.MigrationsPath + c.DBName
+	errs = append(errs, c.validate()...)
+	if len(errs) > 0 {
+		log.Error(errs)
+		return c, errs
+	}
+	return c, nil
+}
+
+// RegisterFlags defines a flag on fs for every configuration key, named after
+// the key with dashes instead of underscores, such as -db-path. It also
+// defines -config, returning the configuration file to load.
+func RegisterFlags(fs *flag.FlagSet) *string {
+	path := fs.String("config", Path(), "configuration file to load")
+	for _, k := range keys() {
+		fs.Var(keyFlag(k.name), strings.Replace(k.name, "_", "-", -1), fmt.Sprintf("override the %s configuration key (env %s)", k.name, k.env()))
+	}
+	return path
+}
+
+// keyFlag records the value of a configuration flag so that it's applied
+// after the configuration file and the environment variables.
+type keyFlag string
+
+func (k keyFlag) String() string { return "" }
+
+func (k keyFlag) Set(value string) error {
+	// Check the value now so that typos are reported by the flag package
+	c := Conf{}
+	for _, key := range keys() {
+		if key.name == string(k) {
+			err := key.set(&c, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	flagOverrides[string(k)] = value
+	return nil
+}
+
+// key is a configuration key along with the Conf field it sets
+type key struct {
+	name  string
+	index int
+}
+
+// keys returns every configuration key, in the order of the Conf fields
+func keys() []key {
+	t := reflect.TypeOf(Conf{})
+	ks := []key{}
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		ks = append(ks, key{name: name, index: i})
+	}
+	return ks
+}
+
+// env returns the environment variable overriding the key
+func (k key) env() string {
+	return EnvPrefix + strings.ToUpper(k.name)
+}
+
+// set parses value into the field of c matching the key
+func (k key) set(c *Conf, value string) error {
+	field := reflect.ValueOf(c).Elem().Field(k.index)
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false, got %q", k.name, value)
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s must be an integer, got %q", k.name, value)
+		}
+		field.SetInt(int64(i))
+	case reflect.Uint32:
+		u, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("%s must be a positive integer, got %q", k.name, value)
+		}
+		field.SetUint(u)
+	default:
+		return fmt.Errorf("%s can't be overridden", k.name)
+	}
+	return nil
+}
+
+// ValidationError lists every problem found in the configuration
+type ValidationError []error
+
+func (v ValidationError) Error() string {
+	msgs := make([]string, len(v))
+	for i, err := range v {
+		msgs[i] = err.Error()
+	}
+	return "invalid configuration:\n  " + strings.Join(msgs, "\n  ")
+}
+
+func (v ValidationError) add(err error) ValidationError {
+	if err != nil {
+		return append(v, err)
+	}
+	return v
+}
+
+// validate returns every problem found in the configuration
+func (c Conf) validate() ValidationError {
+	errs := ValidationError{}
+	switch c.DBName {
+	case "sqlite3", "postgres", "mysql":
+	default:
+		errs = errs.add(fmt.Errorf("db_name must be sqlite3, postgres or mysql, got %q", c.DBName))
+	}
+	if c.DBPath == "" {
+		errs = errs.add(fmt.Errorf("db_path must be set"))
+	}
+	info, err := os.Stat(c.MigrationsPath)
+	if err != nil || !info.IsDir() {
+		errs = errs.add(fmt.Errorf("migrations directory %s does not exist", c.MigrationsPath))
+	}
+	if !isFQDN(c.EmailHostname) {
+		errs = errs.add(fmt.Errorf("email_hostname must be a fully qualified domain name, got %q", c.EmailHostname))
+	}
+	if c.EnvelopeHostname != "" && !isFQDN(c.EnvelopeHostname) {
+		errs = errs.add(fmt.Errorf("envelope_hostname must be a fully qualified domain name, got %q", c.EnvelopeHostname))
+	}
+	if c.LookalikeHostname != "" && !isFQDN(c.LookalikeHostname) {
+		errs = errs.add(fmt.Errorf("lookalike_hostname must be a fully qualified domain name, got %q", c.LookalikeHostname))
+	}
+	errs = errs.add(checkAddr("listen_addr", c.ListenAddr))
+	errs = errs.add(checkAddr("dns_listen_addr", c.DNSListenAddr))
+	if c.DNSSECResolver != "" {
+		errs = errs.add(checkAddr("dnssec_resolver", c.DNSSECResolver))
+	}
+	_, err = c.SecretKeyBytes()
+	errs = errs.add(err)
+	if c.SMTPDialTimeoutSeconds <= 0 {
+		errs = errs.add(fmt.Errorf("smtp_dial_timeout_seconds must be positive"))
+	}
+	if c.SMTPTimeoutSeconds <= 0 {
+		errs = errs.add(fmt.Errorf("smtp_timeout_seconds must be positive"))
+	}
+	if c.RateLimitPerHour < 0 {
+		errs = errs.add(fmt.Errorf("rate_limit_per_hour can't be negative"))
+	}
+	if c.RateLimitPerHour > 0 && c.RateLimitBurst <= 0 {
+		errs = errs.add(fmt.Errorf("rate_limit_burst must be positive when rate limiting is enabled"))
+	}
+	if c.MessageRetentionDays < 0 || c.DNSRetentionDays < 0 || c.PersonalDataRetentionDays < 0 {
+		errs = errs.add(fmt.Errorf("retention periods can't be negative"))
+	}
+	if c.MessageRetentionDays > 0 && c.PersonalDataRetentionDays > 0 && c.PersonalDataRetentionDays < c.MessageRetentionDays {
+		errs = errs.add(fmt.Errorf("personal_data_retention_days must be at least message_retention_days"))
+	}
+	return errs
+}
+
+// checkAddr ensures the key is set to a host:port address
+func checkAddr(name, addr string) error {
+	_, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%s must be a host:port address, got %q", name, addr)
+	}
 	return nil
 }
+
+// isFQDN returns whether the hostname is a fully qualified domain name, made
+// of at least two valid labels.
+func isFQDN(hostname string) bool {
+	hostname = strings.TrimSuffix(hostname, ".")
+	labels := strings.Split(hostname, ".")
+	if len(hostname) > 253 || len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SMTPDialTimeout returns how long we wait to connect to a mail server
+func (c Conf) SMTPDialTimeout() time.Duration {
+	return time.Duration(c.SMTPDialTimeoutSeconds) * time.Second
+}
+
+// SMTPTimeout returns the maximum amount of time spent in an SMTP session
+func (c Conf) SMTPTimeout() time.Duration {
+	return time.Duration(c.SMTPTimeoutSeconds) * time.Second
+}
+
+// SecretKeyBytes returns the decoded secret key
+func (c Conf) SecretKeyBytes() ([]byte, error) {
+	key, err := hex.DecodeString(c.SecretKey)
+	if err != nil || len(key) != SecretKeyLength {
+		return nil, ErrInvalidSecretKey
+	}
+	return key, nil
+}
@@ -0,0 +1,237 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSecretKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+// writeConfig writes a valid configuration to a temporary directory which
+// also holds the migrations directory, returning the configuration file.
+func writeConfig(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "healthcheck-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Mkdir(filepath.Join(dir, "sqlite3"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents == "" {
+		contents = `{
+			"db_name": "sqlite3",
+			"db_path": "healthcheck.db",
+			"migrations_path": "` + dir + `/",
+			"email_hostname": "mail.example.com",
+			"secret_key": "` + testSecretKey + `"
+		}`
+	}
+	path := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func resetOverrides(t *testing.T) {
+	flagOverrides = map[string]string{}
+	for _, k := range keys() {
+		os.Unsetenv(k.env())
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	resetOverrides(t)
+	path := writeConfig(t, "")
+	defer os.RemoveAll(filepath.Dir(path))
+	err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	if Config.ListenAddr != DefaultListenAddr {
+		t.Fatalf("Unexpected listen address. Expected %s Got %s", DefaultListenAddr, Config.ListenAddr)
+	}
+	if Config.SMTPTimeoutSeconds != DefaultSMTPTimeoutSeconds {
+		t.Fatalf("Unexpected SMTP timeout. Expected %d Got %d", DefaultSMTPTimeoutSeconds, Config.SMTPTimeoutSeconds)
+	}
+	expected := filepath.Join(filepath.Dir(path), "sqlite3")
+	if Config.MigrationsPath != expected {
+		t.Fatalf("Unexpected migrations path. Expected %s Got %s", expected, Config.MigrationsPath)
+	}
+}
+
+func TestLoadConfigOverrides(t *testing.T) {
+	resetOverrides(t)
+	defer resetOverrides(t)
+	path := writeConfig(t, "")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	os.Setenv("HEALTHCHECK_LISTEN_ADDR", ":8080")
+	os.Setenv("HEALTHCHECK_DNS_TTL", "60")
+	os.Setenv("HEALTHCHECK_STORE_RECIPIENTS", "true")
+	os.Setenv("HEALTHCHECK_RATE_LIMIT_PER_HOUR", "10")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	RegisterFlags(fs)
+	err := fs.Parse([]string{"-rate-limit-per-hour", "20"})
+	if err != nil {
+		t.Fatalf("Unexpected error parsing flags: %v", err)
+	}
+
+	err = LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+	if Config.ListenAddr != ":8080" {
+		t.Fatalf("Unexpected listen address. Expected :8080 Got %s", Config.ListenAddr)
+	}
+	if Config.DNSTTL != 60 {
+		t.Fatalf("Unexpected DNS TTL. Expected 60 Got %d", Config.DNSTTL)
+	}
+	if !Config.StoreRecipients {
+		t.Fatal("Expected store_recipients to be set from the environment")
+	}
+	// Flags take precedence over the environment
+	if Config.RateLimitPerHour != 20 {
+		t.Fatalf("Unexpected rate limit. Expected 20 Got %d", Config.RateLimitPerHour)
+	}
+}
+
+func TestLoadConfigWithoutFile(t *testing.T) {
+	resetOverrides(t)
+	defer resetOverrides(t)
+	path := writeConfig(t, "{}")
+	dir := filepath.Dir(path)
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path)
+
+	os.Setenv("HEALTHCHECK_DB_NAME", "sqlite3")
+	os.Setenv("HEALTHCHECK_DB_PATH", "healthcheck.db")
+	os.Setenv("HEALTHCHECK_MIGRATIONS_PATH", dir+"/")
+	os.Setenv("HEALTHCHECK_EMAIL_HOSTNAME", "mail.example.com")
+	os.Setenv("HEALTHCHECK_SECRET_KEY", testSecretKey)
+	err = LoadConfig(DefaultConfigPath)
+	if err != nil {
+		t.Fatalf("Unexpected error loading the configuration without the default file: %v", err)
+	}
+	if Config.EmailHostname != "mail.example.com" {
+		t.Fatalf("Unexpected email hostname. Expected mail.example.com Got %s", Config.EmailHostname)
+	}
+
+	// Files set explicitly still have to exist
+	err = LoadConfig(path)
+	if err == nil {
+		t.Fatal("Expected an error loading a missing configuration file")
+	}
+}
+
+func TestShippedConfig(t *testing.T) {
+	resetOverrides(t)
+	defer resetOverrides(t)
+	os.Setenv("HEALTHCHECK_MIGRATIONS_PATH", "../db/")
+
+	// The placeholder has to be replaced with a generated key
+	err := LoadConfig("../config.json")
+	if err == nil || !strings.Contains(err.Error(), "openssl rand -hex") {
+		t.Fatalf("Expected an error explaining how to generate the secret key. Got %v", err)
+	}
+	os.Setenv("HEALTHCHECK_SECRET_KEY", testSecretKey)
+	err = LoadConfig("../config.json")
+	if err != nil {
+		t.Fatalf("Unexpected error loading the shipped configuration: %v", err)
+	}
+}
+
+func TestInvalidFlag(t *testing.T) {
+	resetOverrides(t)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	RegisterFlags(fs)
+	err := fs.Parse([]string{"-dns-ttl", "-1"})
+	if err == nil {
+		t.Fatal("Expected an error parsing a negative TTL")
+	}
+}
+
+func TestLoadConfigReportsEveryError(t *testing.T) {
+	resetOverrides(t)
+	defer resetOverrides(t)
+	path := writeConfig(t, `{
+		"db_name": "sqlite3",
+		"db_path": "healthcheck.db",
+		"migrations_path": "/nonexistent/",
+		"email_hostname": "localhost",
+		"secret_key": "`+testSecretKey+`"
+	}`)
+	defer os.RemoveAll(filepath.Dir(path))
+	os.Setenv("HEALTHCHECK_SMTP_TIMEOUT_SECONDS", "soon")
+
+	err := LoadConfig(path)
+	verr, ok := err.(ValidationError)
+	if !ok {
+		t.Fatalf("Expected a ValidationError. Got %v", err)
+	}
+	if len(verr) != 3 {
+		t.Fatalf("Unexpected number of errors. Expected 3 Got %d: %v", len(verr), verr)
+	}
+	for _, expected := range []string{"smtp_timeout_seconds", "migrations directory", "email_hostname"} {
+		if !strings.Contains(verr.Error(), expected) {
+			t.Fatalf("Expected an error about %s. Got %v", expected, verr)
+		}
+	}
+}
+
+func TestRetentionValidation(t *testing.T) {
+	tests := []struct {
+		messages     int
+		personalData int
+		valid        bool
+	}{
+		{0, 0, true},
+		{30, 0, true},
+		{0, 30, true},
+		{30, 90, true},
+		{30, 30, true},
+		{90, 30, false},
+	}
+	for _, test := range tests {
+		c := Conf{MessageRetentionDays: test.messages, PersonalDataRetentionDays: test.personalData}
+		found := strings.Contains(c.validate().Error(), "personal_data_retention_days")
+		if found == test.valid {
+			t.Fatalf("Unexpected validation for %d days of messages and %d days of personal data. Expected valid %v", test.messages, test.personalData, test.valid)
+		}
+	}
+}
+
+func TestIsFQDN(t *testing.T) {
+	tests := map[string]bool{
+		"mail.example.com":  true,
+		"mail.example.com.": true,
+		"localhost":         false,
+		"":                  false,
+		"-mail.example.com": false,
+		"mail..example.com": false,
+		"mail_example.com":  false,
+	}
+	for hostname, expected := range tests {
+		got := isFQDN(hostname)
+		if got != expected {
+			t.Fatalf("Unexpected result for %q. Expected %v Got %v", hostname, expected, got)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gophish/healthcheck/util"
+)
+
+const (
+	// ScopeSend allows sending messages and managing domains, schedules and
+	// runs
+	ScopeSend = "send"
+	// ScopeRead allows reading messages, runs, reports and events
+	ScopeRead = "read"
+	// ScopeAdmin allows everything, for every domain in the organization,
+	// including managing API keys and organization-wide webhooks
+	ScopeAdmin = "admin"
+	// ScopeSuperadmin allows managing organizations, in addition to
+	// everything allowed by ScopeAdmin
+	ScopeSuperadmin = "superadmin"
+)
+
+// APIKeyPrefix is prepended to generated API keys so they're easy to
+// recognize, for example by secret scanners.
+const APIKeyPrefix = "hc_"
+
+// APIKeyLength is the number of random bytes in a generated API key.
+const APIKeyLength = 32
+
+// LastUsedInterval is how often we record when an API key was last used, so
+// that authenticating a request doesn't write to the database every time.
+const LastUsedInterval = time.Minute
+
+// ErrInvalidScope occurs when an API key is created with an unknown scope.
+var ErrInvalidScope = errors.New("invalid scope")
+
+// ErrMissingScopes occurs when an API key is created without any scopes.
+var ErrMissingScopes = errors.New("no scopes specified")
+
+// ErrInvalidAPIKey occurs when a request uses an API key which doesn't exist.
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// APIKey grants access to the API. Only the SHA-256 hash of the key is
+// stored, so the key itself is only available when it's created.
+type APIKey struct {
+	ID         uint       `gorm:"primary_key" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	OrgID      uint       `json:"org_id"`
+	Name       string     `json:"name"`
+	// Hint is the start of the key, to help identify it.
+	Hint    string `json:"hint"`
+	KeyHash string `json:"-"`
+	Scopes  string `json:"-"`
+	Domains string `json:"-"`
+
+	ScopeNames   []string `gorm:"-" json:"scopes"`
+	DomainHashes []string `gorm:"-" json:"domains"`
+}
+
+// BeforeSave stores the scopes and domain hashes as comma-separated lists
+func (k *APIKey) BeforeSave() error {
+	k.Scopes = strings.Join(k.ScopeNames, ",")
+	k.Domains = strings.Join(k.DomainHashes, ",")
+	return nil
+}
+
+// AfterFind parses the comma-separated lists of scopes and domain hashes
+func (k *APIKey) AfterFind() error {
+	k.ScopeNames = []string{}
+	if k.Scopes != "" {
+		k.ScopeNames = strings.Split(k.Scopes, ",")
+	}
+	k.DomainHashes = []string{}
+	if k.Domains != "" {
+		k.DomainHashes = strings.Split(k.Domains, ",")
+	}
+	return nil
+}
+
+// Validate ensures the API key only has known scopes
+func (k *APIKey) Validate() error {
+	if len(k.ScopeNames) == 0 {
+		return ErrMissingScopes
+	}
+	for _, scope := range k.ScopeNames {
+		switch scope {
+		case ScopeSend, ScopeRead, ScopeAdmin, ScopeSuperadmin:
+		default:
+			return fmt.Errorf("%s: %s", ErrInvalidScope, scope)
+		}
+	}
+	return nil
+}
+
+// HasScope returns whether the key has been granted the scope. Admin keys
+// have every scope except superadmin, which has every scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.ScopeNames {
+		switch {
+		case s == scope, s == ScopeSuperadmin:
+			return true
+		case s == ScopeAdmin && scope != ScopeSuperadmin:
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsDomain returns whether the key may be used for the domain. Admin keys
+// may be used for every domain. This doesn't check the domain belongs to the
+// key's organization, which is enforced by scoping every query to it.
+func (k *APIKey) AllowsDomain(hash string) bool {
+	if k.HasScope(ScopeAdmin) {
+		return true
+	}
+	for _, h := range k.DomainHashes {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// hashAPIKey returns the hex-encoded SHA-256 hash of the key
+func hashAPIKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// GetAPIKeys returns every API key for the organization
+func (s *GormStore) GetAPIKeys(orgID uint) ([]APIKey, error) {
+	keys := []APIKey{}
+	err := s.db.Where("org_id=?", orgID).Order("id asc").Find(&keys).Error
+	return keys, err
+}
+
+// GetAPIKeyByKey returns the API key matching the provided key, updating
+// when it was last used unless it was recorded less than LastUsedInterval
+// ago. ErrInvalidAPIKey is only returned if the key doesn't exist, so that
+// database errors aren't mistaken for invalid keys.
+func (s *GormStore) GetAPIKeyByKey(key string) (*APIKey, error) {
+	k := &APIKey{}
+	err := notFound(s.db.Where("key_hash=?", hashAPIKey(key)).First(k).Error)
+	if err == ErrNotFound {
+		return k, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return k, err
+	}
+	return k, s.touch(k)
+}
+
+// touch records that the key was just used, unless it was recorded less than
+// LastUsedInterval ago.
+func (s *GormStore) touch(k *APIKey) error {
+	now, ok := k.touch()
+	if !ok {
+		return nil
+	}
+	return s.db.Model(k).UpdateColumn("last_used_at", now).Error
+}
+
+// touch sets when the key was last used, returning false if it was recorded
+// less than LastUsedInterval ago and doesn't need to be saved.
+func (k *APIKey) touch() (time.Time, bool) {
+	now := time.Now().UTC()
+	if k.LastUsedAt != nil && now.Sub(*k.LastUsedAt) < LastUsedInterval {
+		return now, false
+	}
+	k.LastUsedAt = &now
+	return now, true
+}
+
+// generate validates the API key and generates the key itself, which is
+// returned.
+func (k *APIKey) generate() (string, error) {
+	err := k.Validate()
+	if err != nil {
+		return "", err
+	}
+	key := APIKeyPrefix + util.GenerateSecureID(APIKeyLength)
+	k.KeyHash = hashAPIKey(key)
+	k.Hint = key[:len(APIKeyPrefix)+4]
+	return key, nil
+}
+
+// PostAPIKey validates and saves a new API key, returning the generated key.
+// This is the only time the key is available.
+func (s *GormStore) PostAPIKey(k *APIKey) (string, error) {
+	key, err := k.generate()
+	if err != nil {
+		return "", err
+	}
+	return key, s.db.Save(k).Error
+}
+
+// DeleteAPIKey deletes the organization's API key with the provided ID
+func (s *GormStore) DeleteAPIKey(id, orgID uint) error {
+	return s.db.Where("id=? and org_id=?", id, orgID).Delete(&APIKey{}).Error
+}
+
+// BootstrapAPIKey creates a superadmin key in the default organization if no
+// API keys exist yet, so that the API can be used after the first start. The
+// generated key is returned, or an empty string if keys already exist.
+func (s *GormStore) BootstrapAPIKey() (string, error) {
+	count := 0
+	err := s.db.Model(&APIKey{}).Count(&count).Error
+	if err != nil || count > 0 {
+		return "", err
+	}
+	return s.PostAPIKey(&APIKey{
+		Name:       "bootstrap",
+		OrgID:      DefaultOrganizationID,
+		ScopeNames: []string{ScopeSuperadmin},
+	})
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAPIKeyScopes(t *testing.T) {
+	k := &APIKey{ScopeNames: []string{ScopeRead}, DomainHashes: []string{"hash"}}
+	if !k.HasScope(ScopeRead) || k.HasScope(ScopeSend) {
+		t.Fatalf("Unexpected scopes for read key")
+	}
+	if !k.AllowsDomain("hash") || k.AllowsDomain("other") {
+		t.Fatalf("Unexpected domains for read key")
+	}
+	admin := &APIKey{ScopeNames: []string{ScopeAdmin}}
+	if !admin.HasScope(ScopeSend) || !admin.AllowsDomain("other") {
+		t.Fatalf("Admin key doesn't have access to everything")
+	}
+}
+
+func TestAPIKeyValidation(t *testing.T) {
+	k := &APIKey{}
+	if err := k.Validate(); err != ErrMissingScopes {
+		t.Fatalf("Unexpected error. Got %v Expected %v", err, ErrMissingScopes)
+	}
+	k.ScopeNames = []string{ScopeSend, "bogus"}
+	if err := k.Validate(); err == nil {
+		t.Fatalf("Didn't receive expected error with an invalid scope")
+	}
+}
+
+func TestPostAPIKey(t *testing.T) {
+	store := setupConfig(t)
+	k := &APIKey{ScopeNames: []string{ScopeSend, ScopeRead}, DomainHashes: []string{"hash"}}
+	key, err := store.PostAPIKey(k)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating API key: %v", err)
+	}
+	if !strings.HasPrefix(key, APIKeyPrefix) || k.KeyHash == key {
+		t.Fatalf("Unexpected API key generated: %s", key)
+	}
+	got, err := store.GetAPIKeyByKey(key)
+	if err != nil {
+		t.Fatalf("Unexpected error when getting API key: %v", err)
+	}
+	if got.ID != k.ID || !got.AllowsDomain("hash") || got.LastUsedAt == nil {
+		t.Fatalf("Unexpected API key returned: %#v", got)
+	}
+	_, err = store.GetAPIKeyByKey("invalid")
+	if err != ErrInvalidAPIKey {
+		t.Fatalf("Unexpected error. Got %v Expected %v", err, ErrInvalidAPIKey)
+	}
+}
+
+func TestGetAPIKeyByKeyDatabaseError(t *testing.T) {
+	store := setupConfig(t)
+	key, err := store.PostAPIKey(&APIKey{OrgID: DefaultOrganizationID, ScopeNames: []string{ScopeRead}})
+	if err != nil {
+		t.Fatalf("Unexpected error when creating API key: %v", err)
+	}
+	// Database errors aren't reported as invalid keys
+	store.Close()
+	_, err = store.GetAPIKeyByKey(key)
+	if err == nil || err == ErrInvalidAPIKey {
+		t.Fatalf("Unexpected error with the database closed. Got %v", err)
+	}
+}
+
+func TestAPIKeyLastUsed(t *testing.T) {
+	store := setupConfig(t)
+	key, err := store.PostAPIKey(&APIKey{OrgID: DefaultOrganizationID, ScopeNames: []string{ScopeRead}})
+	if err != nil {
+		t.Fatalf("Unexpected error when creating API key: %v", err)
+	}
+	first, err := store.GetAPIKeyByKey(key)
+	if err != nil || first.LastUsedAt == nil {
+		t.Fatalf("Last use wasn't recorded: %#v, %v", first, err)
+	}
+	// Uses within the interval aren't recorded
+	got, err := store.GetAPIKeyByKey(key)
+	if err != nil || !got.LastUsedAt.Equal(*first.LastUsedAt) {
+		t.Fatalf("Unexpected last use. Expected %v Got %v", first.LastUsedAt, got.LastUsedAt)
+	}
+	old := first.LastUsedAt.Add(-2 * LastUsedInterval)
+	err = store.db.Model(first).UpdateColumn("last_used_at", old).Error
+	if err != nil {
+		t.Fatalf("Unexpected error updating last use: %v", err)
+	}
+	got, err = store.GetAPIKeyByKey(key)
+	if err != nil || !got.LastUsedAt.After(old.Add(LastUsedInterval)) {
+		t.Fatalf("Last use wasn't updated after the interval. Got %v", got.LastUsedAt)
+	}
+}
+
+func TestBootstrapAPIKey(t *testing.T) {
+	store := setupConfig(t)
+	key, err := store.BootstrapAPIKey()
+	if err != nil {
+		t.Fatalf("Unexpected error when bootstrapping API key: %v", err)
+	}
+	k, err := store.GetAPIKeyByKey(key)
+	if err != nil || !k.HasScope(ScopeAdmin) {
+		t.Fatalf("Bootstrapped key isn't an admin key: %v", err)
+	}
+	key, err = store.BootstrapAPIKey()
+	if err != nil || key != "" {
+		t.Fatalf("Unexpected second bootstrapped key %q: %v", key, err)
+	}
+}
+
+func TestSuperadminScope(t *testing.T) {
+	admin := &APIKey{ScopeNames: []string{ScopeAdmin}}
+	if admin.HasScope(ScopeSuperadmin) {
+		t.Fatalf("Admin key unexpectedly has the superadmin scope")
+	}
+	superadmin := &APIKey{ScopeNames: []string{ScopeSuperadmin}}
+	if !superadmin.HasScope(ScopeAdmin) || !superadmin.HasScope(ScopeSend) {
+		t.Fatalf("Superadmin key doesn't have every scope")
+	}
+}
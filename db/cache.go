@@ -0,0 +1,284 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/gophish/healthcheck/events"
+)
+
+// DefaultCacheSize is the default number of messages kept by a CachedStore.
+const DefaultCacheSize = 10000
+
+// DefaultCacheTTL is how long a CachedStore keeps a message by default.
+const DefaultCacheTTL = 5 * time.Minute
+
+// DefaultNegativeCacheTTL is how long a CachedStore remembers that a message
+// doesn't exist by default. It's kept short so that a message created by
+// another process is served soon after.
+const DefaultNegativeCacheTTL = 30 * time.Second
+
+// DefaultCachePollInterval is how often a CachedStore looks for messages
+// updated by other processes, when it's used without the API.
+const DefaultCachePollInterval = 5 * time.Second
+
+// CacheStats counts how the messages requested from a CachedStore were found.
+type CacheStats struct {
+	Hits         uint64 `json:"hits"`
+	NegativeHits uint64 `json:"negative_hits"`
+	Misses       uint64 `json:"misses"`
+	Evictions    uint64 `json:"evictions"`
+	Size         int    `json:"size"`
+}
+
+type cacheEntry struct {
+	id      string
+	version uint64   // changes every time the entry is replaced
+	loaded  bool     // false while the message is retrieved from the store
+	message *Message // nil when the message doesn't exist
+	expires time.Time
+}
+
+// CachedStore keeps the most recently used messages in memory in front of
+// another store, since the DNS plugin looks up the same message several
+// times for every message sent. Other requests are passed through to the
+// underlying store.
+//
+// Messages updated in this process are invalidated by the events published
+// for them. When PollInterval is set, the store is also polled for messages
+// updated by other processes, such as the API when the DNS plugin runs in
+// CoreDNS.
+type CachedStore struct {
+	Store
+	Size         int
+	TTL          time.Duration
+	NegativeTTL  time.Duration
+	PollInterval time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // most recently used first
+	version uint64
+	stats   CacheStats
+	now     func() time.Time
+}
+
+// NewCachedStore returns a cache in front of the store using the default
+// size and TTLs.
+func NewCachedStore(store Store) *CachedStore {
+	return &CachedStore{
+		Store:       store,
+		Size:        DefaultCacheSize,
+		TTL:         DefaultCacheTTL,
+		NegativeTTL: DefaultNegativeCacheTTL,
+		entries:     map[string]*list.Element{},
+		order:       list.New(),
+		now:         time.Now,
+	}
+}
+
+// GetMessage returns the cached message if it's still fresh, otherwise it
+// retrieves the message from the underlying store and caches the result,
+// including when the message doesn't exist. The result isn't cached if the
+// message is invalidated while it's retrieved.
+func (s *CachedStore) GetMessage(id string) (*Message, error) {
+	s.mu.Lock()
+	if el, ok := s.entries[id]; ok {
+		e := el.Value.(*cacheEntry)
+		if e.loaded && s.now().Before(e.expires) {
+			s.order.MoveToFront(el)
+			if e.message == nil {
+				s.stats.NegativeHits++
+				s.mu.Unlock()
+				return &Message{}, ErrNotFound
+			}
+			s.stats.Hits++
+			m := *e.message
+			s.mu.Unlock()
+			return &m, nil
+		}
+	}
+	s.stats.Misses++
+	version := s.reserve(id)
+	s.mu.Unlock()
+
+	m, err := s.Store.GetMessage(id)
+	switch err {
+	case nil:
+		m.store = s
+		s.fill(id, version, m, s.TTL)
+	case ErrNotFound:
+		s.fill(id, version, nil, s.NegativeTTL)
+	default:
+		s.Invalidate(id)
+	}
+	return m, err
+}
+
+// PostMessage saves the message in the underlying store and caches it, since
+// the DNS lookups for it will follow shortly.
+func (s *CachedStore) PostMessage(m *Message) error {
+	err := s.Store.PostMessage(m)
+	if err != nil {
+		return err
+	}
+	m.store = s
+	s.mu.Lock()
+	version := s.reserve(m.MessageID)
+	s.mu.Unlock()
+	s.fill(m.MessageID, version, m, s.TTL)
+	return nil
+}
+
+// PutMessage saves the message in the underlying store and invalidates it.
+func (s *CachedStore) PutMessage(m *Message) error {
+	err := s.Store.PutMessage(m)
+	s.Invalidate(m.MessageID)
+	return err
+}
+
+// Invalidate removes the message from the cache.
+func (s *CachedStore) Invalidate(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[id]; ok {
+		s.remove(el)
+	}
+}
+
+// DeleteMessagesBefore deletes the messages from the underlying store and
+// empties the cache, since we don't know which cached messages were deleted.
+func (s *CachedStore) DeleteMessagesBefore(t time.Time) (int64, error) {
+	deleted, err := s.Store.DeleteMessagesBefore(t)
+	s.Purge()
+	return deleted, err
+}
+
+// PurgePersonalDataBefore erases the personal data in the underlying store
+// and empties the cache.
+func (s *CachedStore) PurgePersonalDataBefore(t time.Time) error {
+	err := s.Store.PurgePersonalDataBefore(t)
+	s.Purge()
+	return err
+}
+
+// DeleteDomainData deletes the domain's data from the underlying store and
+// empties the cache.
+func (s *CachedStore) DeleteDomainData(orgID uint, hash string) error {
+	err := s.Store.DeleteDomainData(orgID, hash)
+	s.Purge()
+	return err
+}
+
+// Purge removes every message from the cache. Messages being retrieved
+// aren't cached once they're found.
+func (s *CachedStore) Purge() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = map[string]*list.Element{}
+	s.order.Init()
+}
+
+// Stats returns the cache metrics collected so far.
+func (s *CachedStore) Stats() CacheStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats
+	stats.Size = s.order.Len()
+	return stats
+}
+
+// Start invalidates the cached messages as they're updated until the context
+// is cancelled. Messages are updated outside of the store, so we rely on the
+// events published for every update, and on polling the store for the
+// updates made by other processes.
+func (s *CachedStore) Start(ctx context.Context) {
+	sub := events.Subscribe(events.DefaultBufferSize)
+	defer events.Unsubscribe(sub)
+	var poll <-chan time.Time
+	if s.PollInterval > 0 {
+		ticker := time.NewTicker(s.PollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	since := s.now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-sub.C:
+			// Lookups and newly queued messages don't change the message
+			if e.Type == events.DNSLookup || e.Type == events.MessageQueued {
+				continue
+			}
+			s.Invalidate(e.MessageID)
+		case <-poll:
+			since = s.poll(since)
+		}
+	}
+}
+
+// poll invalidates the messages updated in the store since the provided
+// time, returning the time to use for the next poll. The polls overlap by an
+// interval so that updates made by processes whose clock is slightly behind
+// aren't missed.
+func (s *CachedStore) poll(since time.Time) time.Time {
+	next := s.now()
+	messages, err := s.Store.GetMessages(MessageFilter{UpdatedSince: since.Add(-s.PollInterval)})
+	if err != nil {
+		log.Error(err)
+		return since
+	}
+	for _, m := range messages {
+		s.Invalidate(m.MessageID)
+	}
+	return next
+}
+
+// reserve replaces the entry for the message by one which isn't loaded yet,
+// returning its version. The caller must hold the lock.
+func (s *CachedStore) reserve(id string) uint64 {
+	s.version++
+	e := &cacheEntry{id: id, version: s.version}
+	if el, ok := s.entries[id]; ok {
+		el.Value = e
+		s.order.MoveToFront(el)
+		return e.version
+	}
+	s.entries[id] = s.order.PushFront(e)
+	for s.order.Len() > s.Size {
+		s.remove(s.order.Back())
+		s.stats.Evictions++
+	}
+	return e.version
+}
+
+// fill caches a copy of the message in the entry reserved with the provided
+// version. Nothing is cached if the entry was invalidated, evicted or
+// reserved again since.
+func (s *CachedStore) fill(id string, version uint64, m *Message, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[id]
+	if !ok {
+		return
+	}
+	e := el.Value.(*cacheEntry)
+	if e.version != version {
+		return
+	}
+	e.loaded = true
+	e.expires = s.now().Add(ttl)
+	if m != nil {
+		message := *m
+		e.message = &message
+	}
+}
+
+func (s *CachedStore) remove(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.entries, el.Value.(*cacheEntry).id)
+}
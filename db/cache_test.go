@@ -0,0 +1,207 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gophish/healthcheck/events"
+)
+
+func TestCachedStoreHits(t *testing.T) {
+	store := NewMemoryStore()
+	cache := NewCachedStore(store)
+	m := createMessage()
+	err := cache.PostMessage(m)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating message: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		got, err := cache.GetMessage(m.MessageID)
+		if err != nil || got.MessageID != m.MessageID {
+			t.Fatalf("Unexpected message returned: %#v, %v", got, err)
+		}
+	}
+	_, err = cache.GetMessage("missing")
+	if err != ErrNotFound {
+		t.Fatalf("Unexpected error for missing message. Got %v Expected %v", err, ErrNotFound)
+	}
+	_, err = cache.GetMessage("missing")
+	if err != ErrNotFound {
+		t.Fatalf("Unexpected error for cached missing message. Got %v Expected %v", err, ErrNotFound)
+	}
+	expected := CacheStats{Hits: 2, NegativeHits: 1, Misses: 1, Size: 2}
+	if got := cache.Stats(); got != expected {
+		t.Fatalf("Unexpected cache stats.\nGot %#v\nExpected %#v", got, expected)
+	}
+}
+
+func TestCachedStoreExpiry(t *testing.T) {
+	store := NewMemoryStore()
+	cache := NewCachedStore(store)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	_, err := cache.GetMessage("late")
+	if err != ErrNotFound {
+		t.Fatalf("Unexpected error for missing message. Got %v Expected %v", err, ErrNotFound)
+	}
+	// Create the message behind the cache's back, like another process would
+	store.messages = append(store.messages, &Message{MessageID: "late"})
+	_, err = cache.GetMessage("late")
+	if err != ErrNotFound {
+		t.Fatalf("Expected the negative entry to be served. Got %v", err)
+	}
+	now = now.Add(DefaultNegativeCacheTTL)
+	got, err := cache.GetMessage("late")
+	if err != nil || got.MessageID != "late" {
+		t.Fatalf("Unexpected message returned after the negative entry expired: %#v, %v", got, err)
+	}
+}
+
+func TestCachedStoreEviction(t *testing.T) {
+	cache := NewCachedStore(NewMemoryStore())
+	cache.Size = 1
+	first := createMessage()
+	cache.PostMessage(first)
+	second := createMessage()
+	cache.PostMessage(second)
+	stats := cache.Stats()
+	if stats.Size != 1 || stats.Evictions != 1 {
+		t.Fatalf("Unexpected cache stats after eviction: %#v", stats)
+	}
+	if _, ok := cache.entries[first.MessageID]; ok {
+		t.Fatalf("Least recently used message wasn't evicted")
+	}
+}
+
+func TestCachedStoreInvalidation(t *testing.T) {
+	cache := NewCachedStore(NewMemoryStore())
+	m := createMessage()
+	cache.PostMessage(m)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		cache.Start(ctx)
+		close(done)
+	}()
+	// The subscription is created in the background, so we keep publishing
+	// until the message is invalidated.
+	for i := 0; cache.Stats().Size != 0; i++ {
+		if i == 100 {
+			t.Fatalf("Message wasn't invalidated after being updated")
+		}
+		events.Publish(events.Event{Type: events.MessageSent, MessageID: m.MessageID})
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+}
+
+// blockingStore blocks in GetMessage until it's released, so that the cache
+// can be changed while a message is retrieved.
+type blockingStore struct {
+	Store
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s blockingStore) GetMessage(id string) (*Message, error) {
+	s.started <- struct{}{}
+	<-s.release
+	return s.Store.GetMessage(id)
+}
+
+func TestCachedStoreInvalidateWhileLoading(t *testing.T) {
+	store := NewMemoryStore()
+	m := createMessage()
+	store.PostMessage(m)
+	blocking := blockingStore{Store: store, started: make(chan struct{}), release: make(chan struct{})}
+	cache := NewCachedStore(blocking)
+
+	done := make(chan struct{})
+	go func() {
+		cache.GetMessage(m.MessageID)
+		close(done)
+	}()
+	<-blocking.started
+	cache.Invalidate(m.MessageID)
+	close(blocking.release)
+	<-done
+	if _, ok := cache.entries[m.MessageID]; ok {
+		t.Fatal("Message invalidated while it was retrieved was cached")
+	}
+}
+
+func TestCachedStorePutMessage(t *testing.T) {
+	cache := NewCachedStore(NewMemoryStore())
+	m := createMessage()
+	cache.PostMessage(m)
+	got, err := cache.GetMessage(m.MessageID)
+	if err != nil {
+		t.Fatalf("Unexpected error getting message: %v", err)
+	}
+	// Messages are saved through the store they were retrieved from
+	got.Status = StatusReceived
+	err = got.store.PutMessage(got)
+	if err != nil {
+		t.Fatalf("Unexpected error updating message: %v", err)
+	}
+	got, err = cache.GetMessage(m.MessageID)
+	if err != nil || got.Status != StatusReceived {
+		t.Fatalf("Unexpected message returned after being updated: %#v, %v", got, err)
+	}
+}
+
+func TestCachedStorePoll(t *testing.T) {
+	store := NewMemoryStore()
+	cache := NewCachedStore(store)
+	cache.PollInterval = time.Second
+	m := createMessage()
+	cache.PostMessage(m)
+	since := time.Now()
+
+	// Update the message behind the cache's back, like another process would
+	updated := *m
+	updated.Status = StatusReceived
+	store.PutMessage(&updated)
+	got, _ := cache.GetMessage(m.MessageID)
+	if got.Status != StatusQueued {
+		t.Fatalf("Unexpected status before polling. Expected %s Got %s", StatusQueued, got.Status)
+	}
+	cache.poll(since)
+	got, _ = cache.GetMessage(m.MessageID)
+	if got.Status != StatusReceived {
+		t.Fatalf("Unexpected status after polling. Expected %s Got %s", StatusReceived, got.Status)
+	}
+}
+
+func TestCachedStoreDeletes(t *testing.T) {
+	store := NewMemoryStore()
+	cache := NewCachedStore(store)
+	m := createMessage()
+	m.OrgID = DefaultOrganizationID
+	m.DomainHash = "hash"
+	cache.PostMessage(m)
+	err := cache.DeleteDomainData(DefaultOrganizationID, "hash")
+	if err != nil {
+		t.Fatalf("Unexpected error deleting domain data: %v", err)
+	}
+	_, err = cache.GetMessage(m.MessageID)
+	if err != ErrNotFound {
+		t.Fatalf("Unexpected error for deleted message. Got %v Expected %v", err, ErrNotFound)
+	}
+
+	m = createMessage()
+	cache.PostMessage(m)
+	_, err = cache.DeleteMessagesBefore(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Unexpected error deleting messages: %v", err)
+	}
+	_, err = cache.GetMessage(m.MessageID)
+	if err != ErrNotFound {
+		t.Fatalf("Unexpected error for expired message. Got %v Expected %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,133 @@
+package db
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/gophish/gomail"
+)
+
+const (
+	// AttachmentExecutable attaches a harmless Windows executable, which
+	// only contains an MZ header.
+	AttachmentExecutable = "executable"
+	// AttachmentMacro attaches a Word document with macros enabled. The
+	// macro project is empty.
+	AttachmentMacro = "macro"
+	// AttachmentEICAR attaches the EICAR anti-virus test file, which every
+	// anti-virus engine detects as malware.
+	AttachmentEICAR = "eicar"
+	// LinkMalware includes a link to Google Safe Browsing's malware test
+	// page in the message body.
+	LinkMalware = "malware"
+)
+
+// MalwareTestURL is flagged as malware by Google Safe Browsing, and by the
+// URL scanners relying on it, without hosting anything harmful.
+const MalwareTestURL = "http://malware.testing.google.test/testing/malware/"
+
+// eicar is the EICAR anti-virus test file. It's split so that this source
+// file isn't itself flagged by anti-virus software.
+var eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$` + `EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// ErrInvalidContentScenario occurs when an unknown attachment or link option
+// is requested.
+var ErrInvalidContentScenario = errors.New("invalid content scenario")
+
+// attachment is a file attached to a message
+type attachment struct {
+	Name        string
+	ContentType string
+	Content     []byte
+}
+
+// generateAttachment returns the attachment requested by the message
+// configuration, or nil if no attachment was requested.
+func (m *Message) generateAttachment() (*attachment, error) {
+	switch m.MessageConfiguration.Attachment {
+	case AttachmentExecutable:
+		content := append([]byte("MZ"), make([]byte, 62)...)
+		return &attachment{
+			Name:        "invoice.exe",
+			ContentType: "application/x-msdownload",
+			Content:     content,
+		}, nil
+	case AttachmentMacro:
+		content, err := macroDocument()
+		if err != nil {
+			return nil, err
+		}
+		return &attachment{
+			Name:        "invoice.docm",
+			ContentType: "application/vnd.ms-word.document.macroEnabled.12",
+			Content:     content,
+		}, nil
+	case AttachmentEICAR:
+		return &attachment{
+			Name:        "eicar.com",
+			ContentType: "application/octet-stream",
+			Content:     []byte(eicar),
+		}, nil
+	}
+	return nil, nil
+}
+
+// macroDocument returns a minimal macro-enabled Word document. It contains
+// just enough of the Open XML package for scanners to find the VBA project.
+func macroDocument() ([]byte, error) {
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="bin" ContentType="application/vnd.ms-office.vbaProject"/><Override PartName="/word/document.xml" ContentType="application/vnd.ms-word.document.macroEnabled.main+xml"/></Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="word/document.xml"/></Relationships>`},
+		{"word/_rels/document.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.microsoft.com/office/2006/relationships/vbaProject" Target="vbaProject.bin"/></Relationships>`},
+		{"word/document.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body><w:p><w:r><w:t>Gophish Healthcheck</w:t></w:r></w:p></w:body></w:document>`},
+		{"word/vbaProject.bin", ""},
+	}
+	buff := bytes.Buffer{}
+	zw := zip.NewWriter(&buff)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.WriteString(w, f.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := zw.Close()
+	return buff.Bytes(), err
+}
+
+// attach adds the requested attachment, if any, to the message
+func (m *Message) attach(msg *gomail.Message) error {
+	a, err := m.generateAttachment()
+	if err != nil || a == nil {
+		return err
+	}
+	msg.Attach(a.Name,
+		gomail.SetHeader(map[string][]string{"Content-Type": {a.ContentType}}),
+		gomail.SetCopyFunc(func(w io.Writer) error {
+			_, err := w.Write(a.Content)
+			return err
+		}),
+	)
+	return nil
+}
+
+// LinkURL returns the URL to include in the message body, or an empty
+// string if no link was requested.
+func (m *Message) LinkURL() string {
+	if m.MessageConfiguration.Link == LinkMalware {
+		return MalwareTestURL
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestContentScenarioValidation(t *testing.T) {
+	m := createMessage()
+	for _, attachment := range []string{AttachmentExecutable, AttachmentMacro, AttachmentEICAR} {
+		m.MessageConfiguration.Attachment = attachment
+		err := m.Validate()
+		if err != nil {
+			t.Fatalf("Received unexpected error with the %s attachment: %v", attachment, err)
+		}
+	}
+	m.MessageConfiguration.Attachment = "invalid"
+	err := m.Validate()
+	if err != ErrInvalidContentScenario {
+		t.Fatalf("Didn't receive expected error with invalid attachment. Got: %v", err)
+	}
+	m.MessageConfiguration.Attachment = ""
+	m.MessageConfiguration.Link = "invalid"
+	err = m.Validate()
+	if err != ErrInvalidContentScenario {
+		t.Fatalf("Didn't receive expected error with invalid link. Got: %v", err)
+	}
+}
+
+func TestGenerateAttachment(t *testing.T) {
+	m := createMessage()
+	a, err := m.generateAttachment()
+	if err != nil || a != nil {
+		t.Fatalf("Unexpected attachment without an attachment scenario: %v %v", a, err)
+	}
+
+	m.MessageConfiguration.Attachment = AttachmentExecutable
+	a, err = m.generateAttachment()
+	if err != nil {
+		t.Fatalf("Unexpected error generating attachment: %v", err)
+	}
+	if !bytes.HasPrefix(a.Content, []byte("MZ")) {
+		t.Fatalf("Expected the executable to start with an MZ header")
+	}
+
+	m.MessageConfiguration.Attachment = AttachmentEICAR
+	a, err = m.generateAttachment()
+	if err != nil {
+		t.Fatalf("Unexpected error generating attachment: %v", err)
+	}
+	if len(a.Content) != 68 || !strings.Contains(string(a.Content), "EICAR-STANDARD-ANTIVIRUS-TEST-FILE") {
+		t.Fatalf("Unexpected EICAR test file: %s", a.Content)
+	}
+
+	m.MessageConfiguration.Attachment = AttachmentMacro
+	a, err = m.generateAttachment()
+	if err != nil {
+		t.Fatalf("Unexpected error generating attachment: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(a.Content), int64(len(a.Content)))
+	if err != nil {
+		t.Fatalf("Unexpected error reading the macro document: %v", err)
+	}
+	found := false
+	for _, f := range zr.File {
+		if f.Name == "word/vbaProject.bin" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("Expected the macro document to contain a VBA project")
+	}
+}
+
+func TestLinkURL(t *testing.T) {
+	m := createMessage()
+	if m.LinkURL() != "" {
+		t.Fatalf("Unexpected link without a link scenario: %s", m.LinkURL())
+	}
+	m.MessageConfiguration.Link = LinkMalware
+	if m.LinkURL() != MalwareTestURL {
+		t.Fatalf("Unexpected link. Expected %s Got %s", MalwareTestURL, m.LinkURL())
+	}
+}
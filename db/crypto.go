@@ -0,0 +1,152 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/gophish/healthcheck/config"
+	"github.com/gophish/healthcheck/util"
+)
+
+const (
+	// domainIDPurpose derives the key used to compute domain identifiers
+	domainIDPurpose = "domain-id"
+	// encryptionPurpose derives the key used to encrypt domains and
+	// recipients
+	encryptionPurpose = "encryption"
+	// csrfPurpose derives the key used to sign the dashboard's CSRF tokens
+	csrfPurpose = "csrf"
+)
+
+// deriveKey returns a key for a single purpose derived from the configured
+// secret key, so that the same key is never used for two algorithms.
+func deriveKey(purpose string) ([]byte, error) {
+	secret, err := config.Config.SecretKeyBytes()
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil), nil
+}
+
+// CSRFKey returns the key used to sign the dashboard's CSRF tokens. It's
+// derived from the secret key, so tokens stay valid across restarts and
+// between instances sharing the configuration.
+func CSRFKey() ([]byte, error) {
+	return deriveKey(csrfPurpose)
+}
+
+// DomainID returns the identifier used to store results for the domain. It's
+// a keyed hash, so the domain can't be recovered by hashing candidate domain
+// names without the secret key.
+func DomainID(domain string) (string, error) {
+	key, err := deriveKey(domainIDPurpose)
+	if err != nil {
+		return "", err
+	}
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	return util.HMAC(key, domain), nil
+}
+
+// LegacyDomainID returns the unkeyed SHA-1 hash which identified the domain
+// before identifiers were keyed.
+func LegacyDomainID(domain string) string {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	hash := sha1.Sum([]byte(domain))
+	return hex.EncodeToString(hash[:])
+}
+
+// RehashDomain replaces the legacy identifier of the domain with its keyed
+// identifier everywhere it's stored, including the domains API keys are
+// restricted to. Legacy identifiers can't be reversed, so this must be run
+// for every domain known before upgrading. Domains registered with the
+// legacy identifier get their name stored as well. It returns the number of
+// updated rows.
+func (s *GormStore) RehashDomain(domain string) (int64, error) {
+	legacy := LegacyDomainID(domain)
+	id, err := DomainID(domain)
+	if err != nil {
+		return 0, err
+	}
+	name, err := encrypt(strings.TrimSuffix(strings.ToLower(domain), "."))
+	if err != nil {
+		return 0, err
+	}
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return 0, tx.Error
+	}
+	updated := int64(0)
+	result := tx.Model(&Domain{}).Where("domain_hash=? and (encrypted_name is null or encrypted_name=?)", legacy, "").UpdateColumn("encrypted_name", name)
+	if result.Error != nil {
+		tx.Rollback()
+		return 0, result.Error
+	}
+	for _, model := range []interface{}{&Message{}, &Run{}, &Schedule{}, &PostureEvent{}, &Webhook{}, &Domain{}} {
+		result = tx.Unscoped().Model(model).Where("domain_hash=?", legacy).UpdateColumn("domain_hash", id)
+		if result.Error != nil {
+			tx.Rollback()
+			return 0, result.Error
+		}
+		updated += result.RowsAffected
+	}
+	keys := []APIKey{}
+	err = tx.Where("domains like ?", "%"+legacy+"%").Find(&keys).Error
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	for _, k := range keys {
+		for i, hash := range k.DomainHashes {
+			if hash == legacy {
+				k.DomainHashes[i] = id
+			}
+		}
+		err = tx.Model(&k).UpdateColumn("domains", strings.Join(k.DomainHashes, ",")).Error
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		updated++
+	}
+	return updated, tx.Commit().Error
+}
+
+// DomainIDFromAddress returns the identifier of the domain in an email
+// address.
+func DomainIDFromAddress(addr string) (string, error) {
+	domain, err := util.DomainFromAddress(addr)
+	if err != nil {
+		return "", err
+	}
+	return DomainID(domain)
+}
+
+// encrypt encrypts a value stored in the database. Empty values are stored
+// as is.
+func encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	key, err := deriveKey(encryptionPurpose)
+	if err != nil {
+		return "", err
+	}
+	return util.Encrypt(key, plaintext)
+}
+
+// decrypt decrypts a value encrypted with encrypt.
+func decrypt(ciphertext string) (string, error) {
+	if ciphertext == "" {
+		return "", nil
+	}
+	key, err := deriveKey(encryptionPurpose)
+	if err != nil {
+		return "", err
+	}
+	return util.Decrypt(key, ciphertext)
+}
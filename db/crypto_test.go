@@ -0,0 +1,135 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gophish/healthcheck/config"
+)
+
+func TestDomainID(t *testing.T) {
+	config.Config.SecretKey = testSecretKey
+	id, err := DomainID("example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error computing domain ID: %v", err)
+	}
+	for _, name := range []string{"EXAMPLE.com", "example.com."} {
+		got, err := DomainID(name)
+		if err != nil || got != id {
+			t.Fatalf("Unexpected ID for %s. Got %s Expected %s", name, got, id)
+		}
+	}
+	got, err := DomainIDFromAddress("test@Example.com")
+	if err != nil || got != id {
+		t.Fatalf("Unexpected ID for address. Got %s Expected %s", got, id)
+	}
+
+	config.Config.SecretKey = strings.Repeat("ff", config.SecretKeyLength)
+	got, err = DomainID("example.com")
+	if err != nil || got == id {
+		t.Fatalf("Domain ID didn't depend on the secret key: %s, %v", got, err)
+	}
+	config.Config.SecretKey = "invalid"
+	_, err = DomainID("example.com")
+	if err != config.ErrInvalidSecretKey {
+		t.Fatalf("Unexpected error with an invalid key. Got %v Expected %v", err, config.ErrInvalidSecretKey)
+	}
+	config.Config.SecretKey = testSecretKey
+}
+
+func TestDomainNameEncrypted(t *testing.T) {
+	store := setupConfig(t)
+	d := &Domain{OrgID: DefaultOrganizationID, DomainHash: "hash", Name: "example.com"}
+	err := store.PostDomain(d)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating domain: %v", err)
+	}
+	if d.EncryptedName == "" || strings.Contains(d.EncryptedName, "example") {
+		t.Fatalf("Domain name wasn't encrypted: %s", d.EncryptedName)
+	}
+	got, err := store.GetDomain(DefaultOrganizationID, "hash")
+	if err != nil || got.Name != "example.com" {
+		t.Fatalf("Unexpected domain returned: %#v, %v", got, err)
+	}
+}
+
+func TestStoreRecipients(t *testing.T) {
+	store := setupConfig(t)
+	defer func() { config.Config.StoreRecipients = false }()
+	for _, storeRecipients := range []bool{false, true} {
+		config.Config.StoreRecipients = storeRecipients
+		m := createMessage()
+		err := store.PostMessage(m)
+		if err != nil {
+			t.Fatalf("Unexpected error when creating message: %v", err)
+		}
+		got, err := store.GetMessage(m.MessageID)
+		if err != nil {
+			t.Fatalf("Unexpected error when getting message: %v", err)
+		}
+		expected := ""
+		if storeRecipients {
+			expected = m.Recipient
+		}
+		if got.Recipient != expected {
+			t.Fatalf("Unexpected recipient with store_recipients %v. Got %q Expected %q", storeRecipients, got.Recipient, expected)
+		}
+	}
+}
+
+func TestRehashDomain(t *testing.T) {
+	store := setupConfig(t)
+	legacy := LegacyDomainID("example.com")
+	id, err := DomainID("example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error computing domain ID: %v", err)
+	}
+	m := createMessage()
+	m.DomainHash = legacy
+	err = store.PostMessage(m)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating message: %v", err)
+	}
+	err = store.PostDomain(&Domain{OrgID: DefaultOrganizationID, DomainHash: legacy})
+	if err != nil {
+		t.Fatalf("Unexpected error when creating domain: %v", err)
+	}
+	_, err = store.PostAPIKey(&APIKey{
+		OrgID:        DefaultOrganizationID,
+		ScopeNames:   []string{ScopeRead},
+		DomainHashes: []string{"other", legacy},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error when creating API key: %v", err)
+	}
+
+	updated, err := store.RehashDomain("Example.com.")
+	if err != nil {
+		t.Fatalf("Unexpected error rehashing domain: %v", err)
+	}
+	if updated != 3 {
+		t.Fatalf("Unexpected number of updated rows. Expected 3 Got %d", updated)
+	}
+	got, err := store.GetMessage(m.MessageID)
+	if err != nil || got.DomainHash != id {
+		t.Fatalf("Message wasn't rehashed: %#v, %v", got, err)
+	}
+	d, err := store.GetDomain(DefaultOrganizationID, id)
+	if err != nil || d.Name != "example.com" {
+		t.Fatalf("Domain wasn't rehashed: %#v, %v", d, err)
+	}
+	keys, err := store.GetAPIKeys(DefaultOrganizationID)
+	if err != nil {
+		t.Fatalf("Unexpected error getting API keys: %v", err)
+	}
+	k := keys[len(keys)-1]
+	if len(k.DomainHashes) != 2 || k.DomainHashes[0] != "other" || k.DomainHashes[1] != id {
+		t.Fatalf("API key domains weren't rehashed: %v", k.DomainHashes)
+	}
+
+	// Rehashing twice is a no-op
+	updated, err = store.RehashDomain("example.com")
+	if err != nil || updated != 0 {
+		t.Fatalf("Unexpected rehash result. Got %d, %v", updated, err)
+	}
+}
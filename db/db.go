@@ -5,9 +5,12 @@ import (
 	log "github.com/gophish/gophish/logger"
 	"github.com/gophish/healthcheck/config"
 	"github.com/jinzhu/gorm"
-)
 
-var db *gorm.DB
+	// Register the supported database drivers
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
 
 func chooseDBDriver(name, openStr string) goose.DBDriver {
 	d := goose.DBDriver{Name: name, OpenStr: openStr}
@@ -17,6 +20,10 @@ func chooseDBDriver(name, openStr string) goose.DBDriver {
 		d.Import = "github.com/go-sql-driver/mysql"
 		d.Dialect = &goose.MySqlDialect{}
 
+	case "postgres":
+		d.Import = "github.com/lib/pq"
+		d.Dialect = &goose.PostgresDialect{}
+
 	// Default database is sqlite3
 	default:
 		d.Import = "github.com/mattn/go-sqlite3"
@@ -26,33 +33,58 @@ func chooseDBDriver(name, openStr string) goose.DBDriver {
 	return d
 }
 
-func Setup() error {
+// Setup opens the configured database, migrating it to the latest version,
+// and returns the store using it.
+func Setup() (*GormStore, error) {
+	store, err := Open(config.Config)
+	if err != nil {
+		return nil, err
+	}
+	err = store.encryptScheduleRecipients()
+	if err != nil {
+		log.Error(err)
+		store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// Open opens the database set in the provided configuration, migrating it to
+// the latest version, and returns the store using it. Unlike Setup, it
+// doesn't encrypt the recipients of the schedules saved before recipients
+// were encrypted, which needs the secret key of the global configuration.
+func Open(c config.Conf) (*GormStore, error) {
 	// Setup the goose configuration
 	migrateConf := &goose.DBConf{
-		MigrationsDir: config.Config.MigrationsPath,
+		MigrationsDir: c.MigrationsPath,
 		Env:           "production",
-		Driver:        chooseDBDriver(config.Config.DBName, config.Config.DBPath),
+		Driver:        chooseDBDriver(c.DBName, c.DBPath),
 	}
 	// Get the latest possible migration
 	latest, err := goose.GetMostRecentDBVersion(migrateConf.MigrationsDir)
 	if err != nil {
 		log.Error(err)
-		return err
+		return nil, err
 	}
 	// Open our database connection
-	db, err = gorm.Open(config.Config.DBName, config.Config.DBPath)
-	db.LogMode(false)
-	db.SetLogger(log.Logger)
-	db.DB().SetMaxOpenConns(1)
+	db, err := gorm.Open(c.DBName, c.DBPath)
 	if err != nil {
 		log.Error(err)
-		return err
+		return nil, err
+	}
+	db.LogMode(false)
+	db.SetLogger(log.Logger)
+	// SQLite only allows a single writer, so we serialize access through a
+	// single connection. The other databases can use a connection pool.
+	if _, ok := migrateConf.Driver.Dialect.(*goose.Sqlite3Dialect); ok {
+		db.DB().SetMaxOpenConns(1)
 	}
 	// Migrate up to the latest version
 	err = goose.RunMigrationsOnDb(migrateConf, migrateConf.MigrationsDir, latest, db.DB())
 	if err != nil {
 		log.Error(err)
-		return err
+		db.Close()
+		return nil, err
 	}
-	return nil
+	return &GormStore{db: db}, nil
 }
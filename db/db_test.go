@@ -0,0 +1,93 @@
+package db
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/gophish/healthcheck/config"
+)
+
+// dialects maps each supported database to the environment variable holding
+// the connection string used to run the integration tests against it. SQLite
+// always runs in memory, the other databases are only tested when a DSN is
+// provided.
+var dialects = map[string]string{
+	"sqlite3":  "",
+	"postgres": "HEALTHCHECK_TEST_POSTGRES_DSN",
+	"mysql":    "HEALTHCHECK_TEST_MYSQL_DSN",
+}
+
+func migrationNames(t *testing.T, dialect string) []string {
+	files, err := ioutil.ReadDir("../db/" + dialect + "/migrations/")
+	if err != nil {
+		t.Fatalf("Unexpected error when reading %s migrations: %v", dialect, err)
+	}
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	return names
+}
+
+func TestMigrationsMatch(t *testing.T) {
+	expected := migrationNames(t, "sqlite3")
+	for dialect := range dialects {
+		got := migrationNames(t, dialect)
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("Unexpected %s migrations.\nGot %v\nExpected %v", dialect, got, expected)
+		}
+	}
+}
+
+func TestDialects(t *testing.T) {
+	defer setupConfig(t)
+	for dialect, env := range dialects {
+		dsn := ":memory:"
+		if env != "" {
+			dsn = os.Getenv(env)
+			if dsn == "" {
+				t.Logf("Skipping %s, %s isn't set", dialect, env)
+				continue
+			}
+		}
+		config.Config.DBName = dialect
+		config.Config.DBPath = dsn
+		config.Config.MigrationsPath = "../db/" + dialect + "/migrations/"
+		store, err := Setup()
+		if err != nil {
+			t.Fatalf("Failed setting up the %s database: %v", dialect, err)
+		}
+
+		org := &Organization{Name: "Integration"}
+		err = store.PostOrganization(org)
+		if err != nil {
+			t.Fatalf("Unexpected error when creating %s organization: %v", dialect, err)
+		}
+		m := createMessage()
+		m.OrgID = org.ID
+		m.DomainHash = "hash"
+		m.Scenario = "baseline"
+		err = store.PostMessage(m)
+		if err != nil {
+			t.Fatalf("Unexpected error when creating %s message: %v", dialect, err)
+		}
+		messages, err := store.GetMessages(MessageFilter{OrgID: org.ID, DomainHash: "hash"})
+		if err != nil {
+			t.Fatalf("Unexpected error when getting %s messages: %v", dialect, err)
+		}
+		if len(messages) != 1 || messages[0].MessageID != m.MessageID {
+			t.Fatalf("Unexpected %s messages returned: %#v", dialect, messages)
+		}
+		domain := &Domain{OrgID: org.ID, DomainHash: "hash"}
+		err = store.PostDomain(domain)
+		if err != nil {
+			t.Fatalf("Unexpected error when creating %s domain: %v", dialect, err)
+		}
+		got, err := store.GetDomain(org.ID, "hash")
+		if err != nil || got.ID != domain.ID {
+			t.Fatalf("Unexpected %s domain returned: %#v, %v", dialect, got, err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gophish/healthcheck/util"
+)
+
+// VerificationPrefix is the prefix of the TXT record used to prove ownership
+// of a domain.
+const VerificationPrefix = "healthcheck-verification="
+
+// VerificationTokenLength is the number of bytes used when generating domain
+// verification tokens.
+const VerificationTokenLength = 16
+
+// ErrMissingDomain occurs when a domain request doesn't specify a domain.
+var ErrMissingDomain = errors.New("no domain specified")
+
+// ErrDomainNotVerified occurs when an action requires a verified domain.
+var ErrDomainNotVerified = errors.New("domain is not verified")
+
+// ErrVerificationFailed occurs when the verification TXT record for a domain
+// can't be found.
+var ErrVerificationFailed = errors.New("verification record not found")
+
+// Domain is a recipient domain which has been (or is being) verified by its
+// owner.
+type Domain struct {
+	ID                uint       `gorm:"primary_key" json:"id"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	OrgID             uint       `json:"org_id"`
+	DomainHash        string     `json:"domain_hash"`
+	Name              string     `gorm:"-" json:"name,omitempty"`
+	EncryptedName     string     `json:"-"`
+	VerificationToken string     `json:"verification_token"`
+	Verified          bool       `json:"verified"`
+	VerifiedAt        *time.Time `json:"verified_at,omitempty"`
+}
+
+// BeforeSave encrypts the domain name
+func (d *Domain) BeforeSave() (err error) {
+	d.EncryptedName, err = encrypt(d.Name)
+	return err
+}
+
+// AfterFind decrypts the domain name
+func (d *Domain) AfterFind() (err error) {
+	d.Name, err = decrypt(d.EncryptedName)
+	return err
+}
+
+// DisplayName returns the domain name, or its identifier for domains
+// registered before names were stored.
+func (d *Domain) DisplayName() string {
+	if d.Name != "" {
+		return d.Name
+	}
+	return d.DomainHash
+}
+
+// VerificationRecord returns the TXT record which must be published at the
+// domain to verify it.
+func (d *Domain) VerificationRecord() string {
+	return fmt.Sprintf("%s%s", VerificationPrefix, d.VerificationToken)
+}
+
+// Verify looks up the TXT records for the provided domain name, marking the
+// domain as verified if the verification record is found. The name must match
+// the stored domain identifier. The domain must then be saved with PutDomain.
+func (d *Domain) Verify(name string) error {
+	id, err := DomainID(name)
+	if err != nil || id != d.DomainHash {
+		return ErrVerificationFailed
+	}
+	records, err := net.LookupTXT(name)
+	if err != nil {
+		return ErrVerificationFailed
+	}
+	for _, record := range records {
+		if record == d.VerificationRecord() {
+			now := time.Now().UTC()
+			d.Name = name
+			d.Verified = true
+			d.VerifiedAt = &now
+			return nil
+		}
+	}
+	return ErrVerificationFailed
+}
+
+// GetDomain retrieves an organization's domain by its hash from the database
+func (s *GormStore) GetDomain(orgID uint, hash string) (*Domain, error) {
+	domain := &Domain{}
+	err := s.db.Where("org_id=? and domain_hash=?", orgID, hash).First(domain).Error
+	return domain, notFound(err)
+}
+
+// GetDomains returns the organization's domains
+func (s *GormStore) GetDomains(orgID uint) ([]Domain, error) {
+	domains := []Domain{}
+	err := s.db.Where("org_id=?", orgID).Order("id asc").Find(&domains).Error
+	return domains, err
+}
+
+// PostDomain saves a new domain into the database, generating the token
+// needed to verify it
+func (s *GormStore) PostDomain(d *Domain) error {
+	d.VerificationToken = util.GenerateSecureID(VerificationTokenLength)
+	return s.db.Save(d).Error
+}
+
+// PutDomain saves an existing domain into the database
+func (s *GormStore) PutDomain(d *Domain) error {
+	return s.db.Save(d).Error
+}
@@ -0,0 +1,837 @@
+package db
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gophish/healthcheck/events"
+	"github.com/gophish/healthcheck/util"
+)
+
+// ErrDomainExists occurs when the memory store already has the domain for the
+// organization, mirroring the unique constraint in the database.
+var ErrDomainExists = errors.New("domain already exists")
+
+// MemoryStore is a Store which keeps everything in memory, used by the tests
+// of this package which don't need to run the migrations. Records which
+// aren't found return ErrNotFound, like the GormStore, and the same
+// BeforeSave and AfterFind hooks are called, so fields which aren't stored in
+// the database aren't kept either.
+type MemoryStore struct {
+	mu            sync.Mutex
+	messages      []*Message
+	queries       []*DNSQuery
+	runs          []*Run
+	domains       []*Domain
+	postureEvents []*PostureEvent
+	schedules     []*Schedule
+	webhooks      []*Webhook
+	deliveries    []*WebhookDelivery
+	apiKeys       []*APIKey
+	sessions      []*Session
+	organizations []*Organization
+	lastID        uint
+}
+
+// NewMemoryStore returns an in-memory store which only has the default
+// organization, like a freshly migrated database.
+func NewMemoryStore() *MemoryStore {
+	now := time.Now().UTC()
+	return &MemoryStore{
+		organizations: []*Organization{{ID: DefaultOrganizationID, CreatedAt: now, UpdatedAt: now, Name: "Default"}},
+		lastID:        DefaultOrganizationID,
+	}
+}
+
+// nextID returns a new identifier. Identifiers are shared by every kind of
+// record, which is enough for them to be unique and increasing.
+func (s *MemoryStore) nextID() uint {
+	s.lastID++
+	return s.lastID
+}
+
+// saveMessage stores a copy of the message as the database would
+func (s *MemoryStore) saveMessage(m *Message) error {
+	err := m.BeforeSave()
+	if err != nil {
+		return err
+	}
+	m.store = s
+	m.UpdatedAt = time.Now().UTC()
+	message := *m
+	message.Recipient = ""
+	message.ErrorChan = nil
+	for i, existing := range s.messages {
+		if existing.ID == m.ID {
+			s.messages[i] = &message
+			return nil
+		}
+	}
+	s.messages = append(s.messages, &message)
+	return nil
+}
+
+// findMessage returns a copy of the stored message as the database would
+func (s *MemoryStore) findMessage(m *Message) (Message, error) {
+	message := *m
+	return message, message.AfterFind()
+}
+
+// GetMessage implements the Store interface.
+func (s *MemoryStore) GetMessage(id string) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getMessage(id)
+}
+
+func (s *MemoryStore) getMessage(id string) (*Message, error) {
+	for _, m := range s.messages {
+		if m.MessageID == id && m.DeletedAt == nil {
+			message, err := s.findMessage(m)
+			return &message, err
+		}
+	}
+	return &Message{}, ErrNotFound
+}
+
+// GetMessages implements the Store interface.
+func (s *MemoryStore) GetMessages(f MessageFilter) ([]Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	messages := []Message{}
+	for _, m := range s.messages {
+		switch {
+		case m.DeletedAt != nil,
+			f.OrgID != 0 && m.OrgID != f.OrgID,
+			f.DomainHash != "" && m.DomainHash != f.DomainHash,
+			f.Scenario != "" && m.Scenario != f.Scenario,
+			!f.From.IsZero() && m.CreatedAt.Before(f.From),
+			!f.To.IsZero() && !m.CreatedAt.Before(f.To),
+			!f.UpdatedSince.IsZero() && !m.UpdatedAt.After(f.UpdatedSince):
+			continue
+		}
+		message, err := s.findMessage(m)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// PostMessage implements the Store interface.
+func (s *MemoryStore) PostMessage(m *Message) error {
+	s.mu.Lock()
+	for {
+		m.MessageID = util.GenerateSecureID(MessageIDLength)
+		_, err := s.getMessage(m.MessageID)
+		if err == ErrNotFound {
+			break
+		}
+	}
+	m.ID = s.nextID()
+	m.Status = StatusQueued
+	m.CreatedAt = time.Now().UTC()
+	err := s.saveMessage(m)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	m.publish(events.MessageQueued)
+	return nil
+}
+
+// PutMessage implements the Store interface.
+func (s *MemoryStore) PutMessage(m *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveMessage(m)
+}
+
+// GetPreviousMessage implements the Store interface.
+func (s *MemoryStore) GetPreviousMessage(m *Message) (*Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		previous := s.messages[i]
+		switch {
+		case previous.DeletedAt != nil,
+			previous.ID >= m.ID,
+			previous.OrgID != m.OrgID,
+			previous.DomainHash != m.DomainHash,
+			previous.Scenario != m.Scenario,
+			previous.Outcome() == "":
+			continue
+		}
+		message, err := s.findMessage(previous)
+		return &message, err
+	}
+	return &Message{}, ErrNotFound
+}
+
+// DeleteMessagesBefore implements the Store interface.
+func (s *MemoryStore) DeleteMessagesBefore(t time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	deleted := int64(0)
+	for _, m := range s.messages {
+		if m.DeletedAt == nil && m.CreatedAt.Before(t) {
+			m.DeletedAt = &now
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// PurgePersonalDataBefore implements the Store interface.
+func (s *MemoryStore) PurgePersonalDataBefore(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.messages {
+		if m.CreatedAt.Before(t) {
+			m.EncryptedRecipient = ""
+			m.ErrorMessage = ""
+			m.TLS.CertificateError = ""
+		}
+	}
+	for _, q := range s.queries {
+		if q.CreatedAt.Before(t) {
+			q.RemoteIP = ""
+		}
+	}
+	return nil
+}
+
+// GetDNSQueries implements the Store interface.
+func (s *MemoryStore) GetDNSQueries(messageID string) ([]DNSQuery, error) {
+	return s.GetDNSQueriesSince([]string{messageID}, 0)
+}
+
+// GetDNSQueriesSince implements the Store interface.
+func (s *MemoryStore) GetDNSQueriesSince(messageIDs []string, since uint) ([]DNSQuery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queries := []DNSQuery{}
+	for _, q := range s.queries {
+		if q.ID <= since {
+			continue
+		}
+		for _, id := range messageIDs {
+			if q.MessageID == id {
+				queries = append(queries, *q)
+				break
+			}
+		}
+	}
+	return queries, nil
+}
+
+// PostDNSQuery implements the Store interface.
+func (s *MemoryStore) PostDNSQuery(m *Message, q *DNSQuery) error {
+	s.mu.Lock()
+	q.ID = s.nextID()
+	if m != nil {
+		q.MessageID = m.MessageID
+	}
+	q.CreatedAt = time.Now().UTC()
+	query := *q
+	s.queries = append(s.queries, &query)
+	s.mu.Unlock()
+	if m != nil {
+		events.Publish(q.Event(m))
+	}
+	return nil
+}
+
+// GetRun implements the Store interface.
+func (s *MemoryStore) GetRun(id, orgID uint) (*Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.runs {
+		if r.ID != id || r.OrgID != orgID {
+			continue
+		}
+		run := *r
+		run.Messages = []Message{}
+		for _, m := range s.messages {
+			if m.RunID != run.ID || m.DeletedAt != nil {
+				continue
+			}
+			message, err := s.findMessage(m)
+			if err != nil {
+				return &run, err
+			}
+			run.Messages = append(run.Messages, message)
+		}
+		return &run, nil
+	}
+	return &Run{}, ErrNotFound
+}
+
+// GetRuns implements the Store interface.
+func (s *MemoryStore) GetRuns(orgID uint, hash string) ([]Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Runs are stored in the order they were created, so we walk them
+	// backwards to return the most recent first.
+	runs := []Run{}
+	for i := len(s.runs) - 1; i >= 0; i-- {
+		r := s.runs[i]
+		if r.OrgID == orgID && r.DomainHash == hash {
+			runs = append(runs, *r)
+		}
+	}
+	return runs, nil
+}
+
+// PostRun implements the Store interface.
+func (s *MemoryStore) PostRun(r *Run) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.ID = s.nextID()
+	r.CreatedAt = time.Now().UTC()
+	r.UpdatedAt = r.CreatedAt
+	run := *r
+	run.Messages = nil
+	s.runs = append(s.runs, &run)
+	return nil
+}
+
+// saveDomain stores a copy of the domain as the database would
+func (s *MemoryStore) saveDomain(d *Domain) error {
+	err := d.BeforeSave()
+	if err != nil {
+		return err
+	}
+	d.UpdatedAt = time.Now().UTC()
+	domain := *d
+	domain.Name = ""
+	for i, existing := range s.domains {
+		if existing.ID == d.ID {
+			s.domains[i] = &domain
+			return nil
+		}
+	}
+	s.domains = append(s.domains, &domain)
+	return nil
+}
+
+// GetDomain implements the Store interface.
+func (s *MemoryStore) GetDomain(orgID uint, hash string) (*Domain, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, d := range s.domains {
+		if d.OrgID == orgID && d.DomainHash == hash {
+			domain := *d
+			return &domain, domain.AfterFind()
+		}
+	}
+	return &Domain{}, ErrNotFound
+}
+
+// GetDomains implements the Store interface.
+func (s *MemoryStore) GetDomains(orgID uint) ([]Domain, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	domains := []Domain{}
+	for _, d := range s.domains {
+		if d.OrgID != orgID {
+			continue
+		}
+		domain := *d
+		err := domain.AfterFind()
+		if err != nil {
+			return domains, err
+		}
+		domains = append(domains, domain)
+	}
+	return domains, nil
+}
+
+// PostDomain implements the Store interface.
+func (s *MemoryStore) PostDomain(d *Domain) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.domains {
+		if existing.OrgID == d.OrgID && existing.DomainHash == d.DomainHash {
+			return ErrDomainExists
+		}
+	}
+	d.VerificationToken = util.GenerateSecureID(VerificationTokenLength)
+	d.ID = s.nextID()
+	d.CreatedAt = time.Now().UTC()
+	return s.saveDomain(d)
+}
+
+// PutDomain implements the Store interface.
+func (s *MemoryStore) PutDomain(d *Domain) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveDomain(d)
+}
+
+// DeleteDomainData implements the Store interface.
+func (s *MemoryStore) DeleteDomainData(orgID uint, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	messageIDs := map[string]bool{}
+	messages := []*Message{}
+	for _, m := range s.messages {
+		if m.OrgID == orgID && m.DomainHash == hash {
+			messageIDs[m.MessageID] = true
+			continue
+		}
+		messages = append(messages, m)
+	}
+	s.messages = messages
+	queries := []*DNSQuery{}
+	for _, q := range s.queries {
+		if !messageIDs[q.MessageID] {
+			queries = append(queries, q)
+		}
+	}
+	s.queries = queries
+	webhookIDs := map[uint]bool{}
+	webhooks := []*Webhook{}
+	for _, w := range s.webhooks {
+		if w.OrgID == orgID && w.DomainHash == hash {
+			webhookIDs[w.ID] = true
+			continue
+		}
+		webhooks = append(webhooks, w)
+	}
+	s.webhooks = webhooks
+	deliveries := []*WebhookDelivery{}
+	for _, d := range s.deliveries {
+		if !webhookIDs[d.WebhookID] {
+			deliveries = append(deliveries, d)
+		}
+	}
+	s.deliveries = deliveries
+	runs := []*Run{}
+	for _, r := range s.runs {
+		if r.OrgID != orgID || r.DomainHash != hash {
+			runs = append(runs, r)
+		}
+	}
+	s.runs = runs
+	schedules := []*Schedule{}
+	for _, sc := range s.schedules {
+		if sc.OrgID != orgID || sc.DomainHash != hash {
+			schedules = append(schedules, sc)
+		}
+	}
+	s.schedules = schedules
+	postureEvents := []*PostureEvent{}
+	for _, e := range s.postureEvents {
+		if e.OrgID != orgID || e.DomainHash != hash {
+			postureEvents = append(postureEvents, e)
+		}
+	}
+	s.postureEvents = postureEvents
+	domains := []*Domain{}
+	for _, d := range s.domains {
+		if d.OrgID != orgID || d.DomainHash != hash {
+			domains = append(domains, d)
+		}
+	}
+	s.domains = domains
+	return nil
+}
+
+// GetPostureEvents implements the Store interface.
+func (s *MemoryStore) GetPostureEvents(orgID uint, hash string, since uint) ([]PostureEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	postureEvents := []PostureEvent{}
+	for _, e := range s.postureEvents {
+		if e.OrgID == orgID && e.ID > since && (hash == "" || e.DomainHash == hash) {
+			postureEvents = append(postureEvents, *e)
+		}
+	}
+	return postureEvents, nil
+}
+
+// PostPostureEvent implements the Store interface.
+func (s *MemoryStore) PostPostureEvent(e *PostureEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID = s.nextID()
+	e.CreatedAt = time.Now().UTC()
+	event := *e
+	s.postureEvents = append(s.postureEvents, &event)
+	return nil
+}
+
+// saveSchedule stores a copy of the schedule as the database would
+func (s *MemoryStore) saveSchedule(sc *Schedule) error {
+	err := sc.BeforeSave()
+	if err != nil {
+		return err
+	}
+	sc.UpdatedAt = time.Now().UTC()
+	schedule := *sc
+	schedule.Recipient = ""
+	schedule.ScenarioNames = nil
+	for i, existing := range s.schedules {
+		if existing.ID == sc.ID {
+			s.schedules[i] = &schedule
+			return nil
+		}
+	}
+	s.schedules = append(s.schedules, &schedule)
+	return nil
+}
+
+// findSchedules returns copies of the stored schedules matching the filter
+func (s *MemoryStore) findSchedules(match func(*Schedule) bool) ([]Schedule, error) {
+	schedules := []Schedule{}
+	for _, sc := range s.schedules {
+		if !match(sc) {
+			continue
+		}
+		schedule := *sc
+		err := schedule.AfterFind()
+		if err != nil {
+			return schedules, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
+
+// GetSchedules implements the Store interface.
+func (s *MemoryStore) GetSchedules(orgID uint, hash string) ([]Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.findSchedules(func(sc *Schedule) bool {
+		return sc.OrgID == orgID && sc.DomainHash == hash
+	})
+}
+
+// GetDueSchedules implements the Store interface.
+func (s *MemoryStore) GetDueSchedules(t time.Time) ([]Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.findSchedules(func(sc *Schedule) bool {
+		return !sc.Paused && !sc.NextRunAt.After(t)
+	})
+}
+
+// PostSchedule implements the Store interface.
+func (s *MemoryStore) PostSchedule(sc *Schedule) error {
+	err := sc.prepare()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc.ID = s.nextID()
+	sc.CreatedAt = time.Now().UTC()
+	return s.saveSchedule(sc)
+}
+
+// PutSchedule implements the Store interface.
+func (s *MemoryStore) PutSchedule(sc *Schedule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveSchedule(sc)
+}
+
+// DeleteSchedule implements the Store interface.
+func (s *MemoryStore) DeleteSchedule(id, orgID uint, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	schedules := []*Schedule{}
+	for _, sc := range s.schedules {
+		if sc.ID != id || sc.OrgID != orgID || sc.DomainHash != hash {
+			schedules = append(schedules, sc)
+		}
+	}
+	s.schedules = schedules
+	return nil
+}
+
+// findWebhooks returns copies of the stored webhooks matching the filter
+func (s *MemoryStore) findWebhooks(match func(*Webhook) bool) []Webhook {
+	webhooks := []Webhook{}
+	for _, w := range s.webhooks {
+		if match(w) {
+			webhooks = append(webhooks, *w)
+		}
+	}
+	return webhooks
+}
+
+// GetWebhooks implements the Store interface.
+func (s *MemoryStore) GetWebhooks(orgID uint, hash string) ([]Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.findWebhooks(func(w *Webhook) bool {
+		return w.OrgID == orgID && w.DomainHash == hash
+	}), nil
+}
+
+// GetEventWebhooks implements the Store interface.
+func (s *MemoryStore) GetEventWebhooks(orgID uint, hash string) ([]Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.findWebhooks(func(w *Webhook) bool {
+		return w.OrgID == orgID && (w.DomainHash == hash || w.DomainHash == "")
+	}), nil
+}
+
+// GetWebhook implements the Store interface.
+func (s *MemoryStore) GetWebhook(id, orgID uint, hash string) (*Webhook, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	webhooks := s.findWebhooks(func(w *Webhook) bool {
+		return w.ID == id && w.OrgID == orgID && w.DomainHash == hash
+	})
+	if len(webhooks) == 0 {
+		return &Webhook{}, ErrNotFound
+	}
+	return &webhooks[0], nil
+}
+
+// PostWebhook implements the Store interface.
+func (s *MemoryStore) PostWebhook(w *Webhook) error {
+	err := w.prepare()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.ID = s.nextID()
+	w.CreatedAt = time.Now().UTC()
+	w.UpdatedAt = w.CreatedAt
+	webhook := *w
+	s.webhooks = append(s.webhooks, &webhook)
+	return nil
+}
+
+// DeleteWebhook implements the Store interface.
+func (s *MemoryStore) DeleteWebhook(id, orgID uint, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	webhooks := []*Webhook{}
+	for _, w := range s.webhooks {
+		if w.ID != id || w.OrgID != orgID || w.DomainHash != hash {
+			webhooks = append(webhooks, w)
+		}
+	}
+	s.webhooks = webhooks
+	return nil
+}
+
+// GetWebhookDeliveries implements the Store interface.
+func (s *MemoryStore) GetWebhookDeliveries(id uint, limit int) ([]WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deliveries := []WebhookDelivery{}
+	for i := len(s.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if s.deliveries[i].WebhookID == id {
+			deliveries = append(deliveries, *s.deliveries[i])
+		}
+	}
+	return deliveries, nil
+}
+
+// PostWebhookDelivery implements the Store interface.
+func (s *MemoryStore) PostWebhookDelivery(d *WebhookDelivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d.ID = s.nextID()
+	d.CreatedAt = time.Now().UTC()
+	delivery := *d
+	s.deliveries = append(s.deliveries, &delivery)
+	return nil
+}
+
+// findAPIKey returns a copy of the first stored API key matching the filter
+func (s *MemoryStore) findAPIKey(match func(*APIKey) bool) (*APIKey, error) {
+	for _, k := range s.apiKeys {
+		if match(k) {
+			key := *k
+			return &key, key.AfterFind()
+		}
+	}
+	return &APIKey{}, ErrNotFound
+}
+
+// touch records that the key was just used, like the GormStore
+func (s *MemoryStore) touch(k *APIKey) {
+	now, ok := k.touch()
+	if !ok {
+		return
+	}
+	for _, existing := range s.apiKeys {
+		if existing.ID == k.ID {
+			existing.LastUsedAt = &now
+		}
+	}
+}
+
+// GetAPIKeys implements the Store interface.
+func (s *MemoryStore) GetAPIKeys(orgID uint) ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []APIKey{}
+	for _, k := range s.apiKeys {
+		if k.OrgID != orgID {
+			continue
+		}
+		key := *k
+		err := key.AfterFind()
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// GetAPIKeyByKey implements the Store interface.
+func (s *MemoryStore) GetAPIKeyByKey(key string) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash := hashAPIKey(key)
+	k, err := s.findAPIKey(func(k *APIKey) bool { return k.KeyHash == hash })
+	if err != nil {
+		return k, ErrInvalidAPIKey
+	}
+	s.touch(k)
+	return k, nil
+}
+
+// PostAPIKey implements the Store interface.
+func (s *MemoryStore) PostAPIKey(k *APIKey) (string, error) {
+	key, err := k.generate()
+	if err != nil {
+		return "", err
+	}
+	err = k.BeforeSave()
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k.ID = s.nextID()
+	k.CreatedAt = time.Now().UTC()
+	k.UpdatedAt = k.CreatedAt
+	stored := *k
+	stored.ScopeNames = nil
+	stored.DomainHashes = nil
+	s.apiKeys = append(s.apiKeys, &stored)
+	return key, nil
+}
+
+// DeleteAPIKey implements the Store interface.
+func (s *MemoryStore) DeleteAPIKey(id, orgID uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []*APIKey{}
+	for _, k := range s.apiKeys {
+		if k.ID != id || k.OrgID != orgID {
+			keys = append(keys, k)
+		}
+	}
+	s.apiKeys = keys
+	return nil
+}
+
+// PostSession implements the Store interface.
+func (s *MemoryStore) PostSession(k *APIKey) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, id := newSession(k)
+	session.ID = s.nextID()
+	s.sessions = append(s.sessions, session)
+	return id, nil
+}
+
+// GetSessionAPIKey implements the Store interface.
+func (s *MemoryStore) GetSessionAPIKey(id string) (*APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash := hashAPIKey(id)
+	now := time.Now().UTC()
+	for _, session := range s.sessions {
+		if session.IDHash != hash || !session.ExpiresAt.After(now) {
+			continue
+		}
+		k, err := s.findAPIKey(func(k *APIKey) bool { return k.ID == session.APIKeyID })
+		if err != nil {
+			return nil, ErrInvalidSession
+		}
+		s.touch(k)
+		return k, nil
+	}
+	return nil, ErrInvalidSession
+}
+
+// DeleteSession implements the Store interface.
+func (s *MemoryStore) DeleteSession(id string) error {
+	hash := hashAPIKey(id)
+	return s.deleteSessions(func(session *Session) bool { return session.IDHash == hash })
+}
+
+// DeleteSessionsBefore implements the Store interface.
+func (s *MemoryStore) DeleteSessionsBefore(t time.Time) error {
+	return s.deleteSessions(func(session *Session) bool { return session.ExpiresAt.Before(t) })
+}
+
+func (s *MemoryStore) deleteSessions(match func(*Session) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessions := []*Session{}
+	for _, session := range s.sessions {
+		if !match(session) {
+			sessions = append(sessions, session)
+		}
+	}
+	s.sessions = sessions
+	return nil
+}
+
+// GetOrganizations implements the Store interface.
+func (s *MemoryStore) GetOrganizations() ([]Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	orgs := []Organization{}
+	for _, o := range s.organizations {
+		orgs = append(orgs, *o)
+	}
+	return orgs, nil
+}
+
+// GetOrganization implements the Store interface.
+func (s *MemoryStore) GetOrganization(id uint) (*Organization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range s.organizations {
+		if o.ID == id {
+			org := *o
+			return &org, nil
+		}
+	}
+	return &Organization{}, ErrNotFound
+}
+
+// PostOrganization implements the Store interface.
+func (s *MemoryStore) PostOrganization(o *Organization) error {
+	err := o.Validate()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o.ID = s.nextID()
+	o.CreatedAt = time.Now().UTC()
+	o.UpdatedAt = o.CreatedAt
+	org := *o
+	s.organizations = append(s.organizations, &org)
+	return nil
+}
@@ -1,17 +1,23 @@
 package db
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/textproto"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/jinzhu/gorm"
-
 	"github.com/gophish/gomail"
+	log "github.com/gophish/gophish/logger"
 	"github.com/gophish/gophish/mailer"
 	"github.com/gophish/healthcheck/config"
+	"github.com/gophish/healthcheck/events"
+	"github.com/gophish/healthcheck/smtp"
 	"github.com/gophish/healthcheck/template"
 	"github.com/gophish/healthcheck/util"
 )
@@ -24,6 +30,14 @@ const (
 	// messages
 	DefaultSenderName = "Gophish Healthcheck"
 
+	// DefaultReplyTo is the sender part of the email address used in the
+	// Reply-To header when testing Reply-To divergence
+	DefaultReplyTo = "reply"
+
+	// DefaultImpersonatedSender is the sender part of the email address
+	// embedded in the display name when no display name is provided
+	DefaultImpersonatedSender = "ceo"
+
 	// DefaultSubject is the default subject used when sending messages
 	DefaultSubject = "Gophish Healthcheck - Test Email"
 
@@ -31,6 +45,10 @@ const (
 	// connections
 	DefaultSMTPPort = 25
 
+	// MailServerMX is the mail server value used to request delivery to the
+	// recipient domain's MX
+	MailServerMX = "mx"
+
 	// MessageIDLength is the number of bytes to use when generated message IDs
 	MessageIDLength = 16
 
@@ -56,6 +74,70 @@ const (
 	Neutral = "neutral"
 )
 
+const (
+	// StatusQueued indicates the message was created but no delivery attempt
+	// has completed yet
+	StatusQueued = "queued"
+	// StatusSent indicates the mail server accepted the message
+	StatusSent = "sent"
+	// StatusRejected indicates the mail server permanently rejected the
+	// message
+	StatusRejected = "rejected"
+	// StatusDeferred indicates the mail server temporarily rejected the
+	// message
+	StatusDeferred = "deferred"
+	// StatusFailed indicates the message couldn't be delivered for a reason
+	// other than an SMTP rejection (e.g. the mail server was unreachable)
+	StatusFailed = "failed"
+	// StatusReceived indicates the recipient reported the message reached
+	// their inbox
+	StatusReceived = "received"
+)
+
+const (
+	// FromExecutive sets the display name of the header From to the
+	// configured display name (e.g. an internal executive) while keeping our
+	// own address.
+	FromExecutive = "executive"
+	// FromEmbeddedAddress sets the display name of the header From to an
+	// email address at the recipient's domain.
+	FromEmbeddedAddress = "embedded_address"
+	// FromRecipientDomain sets the header From to an address at the
+	// recipient's own domain. The envelope sender is left untouched.
+	FromRecipientDomain = "recipient_domain"
+	// FromLookalike sets the header From to an address at the configured
+	// lookalike (cousin) domain, which is served by the DNS plugin.
+	FromLookalike = "lookalike"
+	// ReplyToDivergent sets a Reply-To header that differs from the header
+	// From.
+	ReplyToDivergent = "divergent"
+)
+
+const (
+	// EnvelopeSubdomain uses a subdomain of the header From domain as the
+	// envelope sender domain. This passes relaxed DMARC alignment but fails
+	// strict alignment.
+	EnvelopeSubdomain = "subdomain"
+	// EnvelopeMisaligned uses the configured envelope hostname as the
+	// envelope sender domain, which never aligns with the header From.
+	EnvelopeMisaligned = "misaligned"
+	// RelaxedAlignment sets the DMARC SPF and DKIM alignment modes to relaxed
+	RelaxedAlignment = "relaxed"
+	// StrictAlignment sets the DMARC SPF and DKIM alignment modes to strict
+	StrictAlignment = "strict"
+)
+
+const (
+	// TTLLong serves the message's records with the configured long TTL,
+	// and makes its SPF record include a record served with the long TTL
+	// under the same name in every run, to test whether cached records
+	// affect later deliveries.
+	TTLLong = "long"
+	// TTLZero serves the message's records with a TTL of zero, so that they
+	// can't be cached.
+	TTLZero = "zero"
+)
+
 // ErrMissingMailServer occurs when a message is received without specifying
 // a valid mail server.
 var ErrMissingMailServer = errors.New("no mail server specified")
@@ -64,17 +146,92 @@ var ErrMissingMailServer = errors.New("no mail server specified")
 // a valid recipient
 var ErrMissingRecipient = errors.New("no recipient specified")
 
-// Dialer is a wrapper around a standard gomail.Dialer in order
-// to implement the mailer.Dialer interface. This allows us to better
-// separate the mailer package as opposed to forcing a connection
+// ErrMissingDisplayName occurs when the executive sender scenario is
+// requested without a display name to impersonate.
+var ErrMissingDisplayName = errors.New("no display name specified")
+
+// ErrMissingLookalikeHostname occurs when the lookalike sender scenario is
+// requested but no lookalike hostname is configured.
+var ErrMissingLookalikeHostname = errors.New("no lookalike hostname configured")
+
+// ErrMissingEnvelopeHostname occurs when a misaligned envelope sender is
+// requested but no envelope hostname is configured.
+var ErrMissingEnvelopeHostname = errors.New("no envelope hostname configured")
+
+// ErrInvalidEnvelopeScenario occurs when an unknown envelope sender or
+// alignment option is requested.
+var ErrInvalidEnvelopeScenario = errors.New("invalid envelope scenario")
+
+// ErrInvalidTLSPolicy occurs when an unknown TLS policy is requested.
+var ErrInvalidTLSPolicy = errors.New("invalid TLS policy")
+
+// ErrInvalidSenderScenario occurs when an unknown header From or Reply-To
+// scenario is requested.
+var ErrInvalidSenderScenario = errors.New("invalid sender scenario")
+
+// ErrInvalidTTLScenario occurs when an unknown DNS TTL option is requested.
+var ErrInvalidTTLScenario = errors.New("invalid TTL scenario")
+
+// Dialer implements the mailer.Dialer interface using our own SMTP client,
+// which records the TLS properties of the connection. This allows us to
+// better separate the mailer package as opposed to forcing a connection
 // between mailer and gomail.
 type Dialer struct {
-	*gomail.Dialer
+	Host      string
+	Port      int
+	LocalName string
+	// From is the envelope sender (MAIL FROM) used for every message sent
+	// through this dialer, regardless of the header From.
+	From string
+	// TLSPolicy determines whether STARTTLS is required, used when offered
+	// or never used.
+	TLSPolicy string
+	// Report is filled in with the TLS properties of the connection
+	Report *smtp.TLSReport
+	// Resolver is the DNSSEC-validating resolver used for DANE lookups
+	Resolver string
+	// Domain is the recipient domain, used to look up its MTA-STS policy
+	Domain string
 }
 
-// Dial wraps the gomail dialer's Dial command
+// Dial connects to the mail server using the configured TLS policy
 func (d *Dialer) Dial() (mailer.Sender, error) {
-	return d.Dialer.Dial()
+	s, err := smtp.Dial(d.Host, d.Port, d.LocalName, d.TLSPolicy, d.Report)
+	if err != nil {
+		return nil, err
+	}
+	// Check the presented certificate against any TLSA records. There's
+	// nothing to check if we deliberately sent the message in cleartext, if
+	// no DNSSEC-validating resolver is configured, or if the MX lookup
+	// wasn't validated.
+	if d.TLSPolicy != smtp.TLSNone && d.Resolver != "" && d.Report.DANE != smtp.DANEInsecure {
+		d.Report.DANE, err = smtp.VerifyDANE(d.Resolver, d.Host, d.Port, d.Report.Certificates)
+		if err != nil {
+			log.Errorf("error verifying DANE for %s: %v", d.Host, err)
+		}
+	}
+	if d.Domain != "" {
+		d.Report.MTASTS, err = smtp.LookupMTASTS(d.Domain)
+		if err != nil {
+			log.Errorf("error looking up MTA-STS policy for %s: %v", d.Domain, err)
+		}
+	}
+	return &envelopeSender{Sender: s, from: d.From}, nil
+}
+
+// envelopeSender overrides the envelope sender gomail derives from the
+// message headers, so that the header From can be spoofed independently.
+type envelopeSender struct {
+	mailer.Sender
+	from string
+}
+
+// Send sends the message using the configured envelope sender
+func (s *envelopeSender) Send(from string, to []string, msg io.WriterTo) error {
+	if s.from != "" {
+		from = s.from
+	}
+	return s.Sender.Send(from, to, msg)
 }
 
 // MessageConfiguration is the configuration for the outbound message.
@@ -83,25 +240,83 @@ type MessageConfiguration struct {
 	DKIM  string `json:"dkim"`
 	DMARC string `json:"dmarc"`
 	MX    string `json:"mx"`
+
+	HeaderFrom  string `json:"header_from"`
+	DisplayName string `json:"display_name"`
+	ReplyTo     string `json:"reply_to"`
+
+	Envelope    string `json:"envelope"`
+	EnvelopeSPF string `json:"envelope_spf"`
+	Alignment   string `json:"alignment"`
+
+	TLSPolicy string `json:"tls_policy"`
+
+	TTL string `json:"ttl"`
+
+	Attachment string `json:"attachment"`
+	Link       string `json:"link"`
 }
 
 // Message is the base struct for handling per-message information.
 type Message struct {
-	ID           uint         `gorm:"primary_key" json:"id"`
-	CreatedAt    time.Time    `json:"created_at"`
-	UpdatedAt    time.Time    `json:"updated_at"`
-	DeletedAt    *time.Time   `json:"deleted_at,omitempty"`
-	Recipient    string       `gorm:"-" json:"recipient"`
-	MailServer   string       `json:"mail_server"`
-	MessageID    string       `json:"message_id"`
-	DomainHash   string       `json:"domain_hash"`
-	Successful   bool         `json:"successful"`
-	ErrorMessage string       `json:"error_message"`
-	ErrorChan    chan (error) `gorm:"-" json:"-"`
+	ID                 uint         `gorm:"primary_key" json:"id"`
+	CreatedAt          time.Time    `json:"created_at"`
+	UpdatedAt          time.Time    `json:"updated_at"`
+	DeletedAt          *time.Time   `json:"deleted_at,omitempty"`
+	Recipient          string       `gorm:"-" json:"recipient"`
+	EncryptedRecipient string       `json:"-"`
+	MailServer         string       `json:"mail_server"`
+	MessageID          string       `json:"message_id"`
+	OrgID              uint         `json:"org_id"`
+	DomainHash         string       `json:"domain_hash"`
+	RunID              uint         `json:"run_id,omitempty"`
+	Scenario           string       `json:"scenario,omitempty"`
+	Status             string       `json:"status"`
+	Successful         bool         `json:"successful"`
+	ErrorMessage       string       `json:"error_message"`
+	ErrorChan          chan (error) `gorm:"-" json:"-"`
+
+	// store is the store the message was loaded from or saved to, which
+	// saves the updates made as the message is sent
+	store Store
+
+	TLS smtp.TLSReport `gorm:"embedded;embedded_prefix:tls_" json:"tls"`
 
 	MessageConfiguration `gorm:"embedded" json:"configuration"`
 }
 
+// MarshalJSON adds the outcome of the message, and its grade against the
+// scenario, so that API clients don't need to know about scenarios.
+func (m Message) MarshalJSON() ([]byte, error) {
+	type message Message
+	return json.Marshal(struct {
+		message
+		Pending  bool   `json:"pending"`
+		Outcome  string `json:"outcome,omitempty"`
+		Expected string `json:"expected,omitempty"`
+		Result   string `json:"result,omitempty"`
+	}{message(m), m.Pending(), m.Outcome(), m.Expected(), m.Result()})
+}
+
+// BeforeSave encrypts the recipient if recipients are stored
+func (m *Message) BeforeSave() (err error) {
+	if !config.Config.StoreRecipients {
+		m.EncryptedRecipient = ""
+		return nil
+	}
+	m.EncryptedRecipient, err = encrypt(m.Recipient)
+	return err
+}
+
+// AfterFind decrypts the recipient, if it was stored
+func (m *Message) AfterFind() (err error) {
+	if m.EncryptedRecipient == "" {
+		return nil
+	}
+	m.Recipient, err = decrypt(m.EncryptedRecipient)
+	return err
+}
+
 // Validate ensures the message is correctly formatted with all the necessary
 // fields.
 func (m *Message) Validate() error {
@@ -111,40 +326,254 @@ func (m *Message) Validate() error {
 	if m.MailServer == "" {
 		return ErrMissingMailServer
 	}
+	switch m.MessageConfiguration.HeaderFrom {
+	case "", FromEmbeddedAddress, FromRecipientDomain:
+	case FromExecutive:
+		if m.MessageConfiguration.DisplayName == "" {
+			return ErrMissingDisplayName
+		}
+	case FromLookalike:
+		if config.Config.LookalikeHostname == "" {
+			return ErrMissingLookalikeHostname
+		}
+	default:
+		return ErrInvalidSenderScenario
+	}
+	switch m.MessageConfiguration.ReplyTo {
+	case "", None, ReplyToDivergent:
+	default:
+		return ErrInvalidSenderScenario
+	}
+	switch m.MessageConfiguration.Envelope {
+	case "", EnvelopeSubdomain:
+	case EnvelopeMisaligned:
+		if config.Config.EnvelopeHostname == "" {
+			return ErrMissingEnvelopeHostname
+		}
+	default:
+		return ErrInvalidEnvelopeScenario
+	}
+	switch m.MessageConfiguration.EnvelopeSPF {
+	case "", Pass, SoftFail, HardFail, Neutral:
+	default:
+		return ErrInvalidEnvelopeScenario
+	}
+	switch m.MessageConfiguration.Alignment {
+	case "", RelaxedAlignment, StrictAlignment:
+	default:
+		return ErrInvalidEnvelopeScenario
+	}
+	switch m.MessageConfiguration.TLSPolicy {
+	case "", smtp.TLSOpportunistic, smtp.TLSRequire, smtp.TLSNone:
+	default:
+		return ErrInvalidTLSPolicy
+	}
+	switch m.MessageConfiguration.TTL {
+	case "", TTLLong, TTLZero:
+	default:
+		return ErrInvalidTTLScenario
+	}
+	switch m.MessageConfiguration.Attachment {
+	case "", AttachmentExecutable, AttachmentMacro, AttachmentEICAR:
+	default:
+		return ErrInvalidContentScenario
+	}
+	switch m.MessageConfiguration.Link {
+	case "", LinkMalware:
+	default:
+		return ErrInvalidContentScenario
+	}
+	return nil
+}
+
+// ResolveMailServer looks up the recipient domain's MX if delivery to the MX
+// was requested, replacing the mail server with the most preferred host.
+//
+// When a DNSSEC-validating resolver is configured, the MX is looked up
+// through it. If the answer isn't validated, TLSA records found for the host
+// can't be trusted, so DANE is reported as insecure.
+func (m *Message) ResolveMailServer() error {
+	if m.MailServer != MailServerMX {
+		return nil
+	}
+	domain, err := util.DomainFromAddress(m.Recipient)
+	if err != nil {
+		return err
+	}
+	resolver := config.Config.DNSSECResolver
+	if resolver == "" {
+		mxs, err := net.LookupMX(domain)
+		if err != nil {
+			return err
+		}
+		if len(mxs) == 0 {
+			return ErrMissingMailServer
+		}
+		m.MailServer = strings.TrimSuffix(mxs[0].Host, ".")
+		return nil
+	}
+	hosts, secure, err := smtp.LookupMX(resolver, domain)
+	if err != nil {
+		return err
+	}
+	if len(hosts) == 0 {
+		return ErrMissingMailServer
+	}
+	m.MailServer = hosts[0]
+	if !secure {
+		m.TLS.DANE = smtp.DANEInsecure
+	}
 	return nil
 }
 
-func (m *Message) generateFromAddress() string {
-	return fmt.Sprintf("\"%s\" <%s@%s.%s>", DefaultSenderName, DefaultSender, m.MessageID, config.Config.EmailHostname)
+// messageDomain returns the domain used by default for both the header From
+// and the envelope sender.
+func (m *Message) messageDomain() string {
+	return fmt.Sprintf("%s.%s", m.MessageID, config.Config.EmailHostname)
+}
+
+// envelopeSender returns the address used as the envelope sender (MAIL FROM).
+// This is always an address under a domain served by the DNS plugin so that
+// bounces and SPF lookups come back to us.
+func (m *Message) envelopeSender() string {
+	switch m.MessageConfiguration.Envelope {
+	case EnvelopeSubdomain:
+		return fmt.Sprintf("%s@%s.%s", DefaultSender, config.BouncePrefix, m.messageDomain())
+	case EnvelopeMisaligned:
+		return fmt.Sprintf("%s@%s.%s", DefaultSender, m.MessageID, config.Config.EnvelopeHostname)
+	}
+	return fmt.Sprintf("%s@%s", DefaultSender, m.messageDomain())
+}
+
+func (m *Message) generateFromAddress() (string, error) {
+	from := mail.Address{
+		Name:    DefaultSenderName,
+		Address: fmt.Sprintf("%s@%s", DefaultSender, m.messageDomain()),
+	}
+	switch m.MessageConfiguration.HeaderFrom {
+	case FromExecutive:
+		from.Name = m.MessageConfiguration.DisplayName
+	case FromEmbeddedAddress:
+		from.Name = m.MessageConfiguration.DisplayName
+		if from.Name == "" {
+			domain, err := util.DomainFromAddress(m.Recipient)
+			if err != nil {
+				return "", err
+			}
+			from.Name = fmt.Sprintf("%s@%s", DefaultImpersonatedSender, domain)
+		}
+	case FromRecipientDomain:
+		domain, err := util.DomainFromAddress(m.Recipient)
+		if err != nil {
+			return "", err
+		}
+		from.Address = fmt.Sprintf("%s@%s", DefaultSender, domain)
+	case FromLookalike:
+		from.Address = fmt.Sprintf("%s@%s.%s", DefaultSender, m.MessageID, config.Config.LookalikeHostname)
+	}
+	return from.String(), nil
+}
+
+// generateReplyToAddress returns the Reply-To header value, or an empty
+// string if no Reply-To header should be set. The divergent address uses the
+// lookalike hostname when one is configured.
+func (m *Message) generateReplyToAddress() string {
+	if m.MessageConfiguration.ReplyTo != ReplyToDivergent {
+		return ""
+	}
+	hostname := config.Config.LookalikeHostname
+	if hostname == "" {
+		hostname = config.Config.EmailHostname
+	}
+	return fmt.Sprintf("%s@%s.%s", DefaultReplyTo, m.MessageID, hostname)
 }
 
 // Backoff simply errors out the message, since we don't handle exponential
 // backoffs yet.
 func (m *Message) Backoff(reason error) error {
 	m.Successful = false
+	m.Status = StatusDeferred
 	m.ErrorMessage = reason.Error()
 	m.ErrorChan <- reason
-	return db.Save(m).Error
+	err := m.store.PutMessage(m)
+	m.publish(events.MessageDeferred)
+	return err
 }
 
-// Error errors out the message.
+// Error errors out the message. Only permanent SMTP errors are considered a
+// rejection by the mail server.
 func (m *Message) Error(err error) error {
 	m.Successful = false
+	m.Status = StatusFailed
+	if te, ok := err.(*textproto.Error); ok && te.Code >= 500 {
+		m.Status = StatusRejected
+	}
 	m.ErrorMessage = err.Error()
 	m.ErrorChan <- err
-	return db.Save(m).Error
+	if m.Status == StatusRejected {
+		return m.saveResult(events.MessageRejected)
+	}
+	return m.saveResult(events.MessageFailed)
 }
 
 // Success saves the message as having been sent successfully.
 func (m *Message) Success() error {
 	m.Successful = true
+	m.Status = StatusSent
 	m.ErrorChan <- nil
-	return db.Save(m).Error
+	return m.saveResult(events.MessageSent)
+}
+
+// Receive saves the message as having been reported in the recipient's inbox.
+func (m *Message) Receive() error {
+	// If the mail server already accepted the message, the outcome hasn't
+	// changed so there's nothing new to compare.
+	delivered := m.Outcome() == OutcomeDelivered
+	m.Status = StatusReceived
+	if delivered {
+		err := m.store.PutMessage(m)
+		m.publish(events.MessageReported)
+		return err
+	}
+	return m.saveResult(events.MessageReported)
+}
+
+// saveResult saves the outcome of the message and publishes the provided
+// event, checking if the outcome changed since the last time the same
+// scenario was sent to the domain.
+func (m *Message) saveResult(eventType string) error {
+	err := m.store.PutMessage(m)
+	if err != nil {
+		return err
+	}
+	m.publish(eventType)
+	return CheckPosture(m.store, m)
+}
+
+// publish publishes a lifecycle event for the message
+func (m *Message) publish(eventType string) {
+	events.Publish(events.Event{
+		Type:       eventType,
+		MessageID:  m.MessageID,
+		OrgID:      m.OrgID,
+		DomainHash: m.DomainHash,
+		RunID:      m.RunID,
+		Scenario:   m.Scenario,
+		Status:     m.Status,
+		Error:      m.ErrorMessage,
+	})
 }
 
 // Generate creates a gomail.Message instance from the provided message.
 func (m *Message) Generate(msg *gomail.Message) error {
-	msg.SetHeader("From", m.generateFromAddress())
+	from, err := m.generateFromAddress()
+	if err != nil {
+		return err
+	}
+	msg.SetHeader("From", from)
+	if replyTo := m.generateReplyToAddress(); replyTo != "" {
+		msg.SetHeader("Reply-To", replyTo)
+	}
 	msg.SetHeader("To", m.Recipient)
 	msg.SetHeader("Subject", DefaultSubject)
 	// Sign with DKIM if needed
@@ -164,8 +593,7 @@ func (m *Message) Generate(msg *gomail.Message) error {
 	}
 	msg.SetBody("text/html", html)
 
-	// Handle attachments in future versions
-	return nil
+	return m.attach(msg)
 }
 
 // GetDialer creates a mailer.Dialer from the message configuration.
@@ -176,32 +604,114 @@ func (m *Message) GetDialer() (mailer.Dialer, error) {
 		port, _ = strconv.Atoi(hp[1])
 	}
 	d := &Dialer{
-		&gomail.Dialer{
-			Host: hp[0],
-			Port: port,
-		},
+		Host:      hp[0],
+		Port:      port,
+		LocalName: config.Config.EmailHostname,
+		From:      m.envelopeSender(),
+		TLSPolicy: m.MessageConfiguration.TLSPolicy,
+		Report:    &m.TLS,
+		Resolver:  config.Config.DNSSECResolver,
 	}
+	// The recipient was validated when the message was created, so the
+	// domain is only missing for messages loaded back from the database.
+	d.Domain, _ = util.DomainFromAddress(m.Recipient)
 	return d, nil
 }
 
+// ValidMessageID returns whether the ID has the format of the IDs we
+// generate, so that lookups for other names never reach the database.
+func ValidMessageID(id string) bool {
+	if len(id) != MessageIDLength*2 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
 // GetMessage retrieves a message by ID from the database
-func GetMessage(id string) (*Message, error) {
-	message := &Message{}
-	err := db.Where("message_id=?", id).First(message).Error
-	return message, err
+func (s *GormStore) GetMessage(id string) (*Message, error) {
+	message := &Message{store: s}
+	err := s.db.Where("message_id=?", id).First(message).Error
+	return message, notFound(err)
+}
+
+// MessageFilter restricts the messages returned by GetMessages. Empty fields
+// aren't used for filtering.
+type MessageFilter struct {
+	OrgID      uint
+	DomainHash string
+	Scenario   string
+	From       time.Time
+	To         time.Time
+	// UpdatedSince only returns the messages updated after the time
+	UpdatedSince time.Time
+}
+
+// GetMessages returns the messages matching the filter, oldest first
+func (s *GormStore) GetMessages(f MessageFilter) ([]Message, error) {
+	messages := []Message{}
+	query := s.db.Order("id asc")
+	if f.OrgID != 0 {
+		query = query.Where("org_id=?", f.OrgID)
+	}
+	if f.DomainHash != "" {
+		query = query.Where("domain_hash=?", f.DomainHash)
+	}
+	if f.Scenario != "" {
+		query = query.Where("scenario=?", f.Scenario)
+	}
+	if !f.From.IsZero() {
+		query = query.Where("created_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		query = query.Where("created_at < ?", f.To)
+	}
+	if !f.UpdatedSince.IsZero() {
+		query = query.Where("updated_at > ?", f.UpdatedSince)
+	}
+	err := query.Find(&messages).Error
+	for i := range messages {
+		messages[i].store = s
+	}
+	return messages, err
 }
 
 // PostMessage saves a message instance into the database
-func PostMessage(m *Message) error {
+func (s *GormStore) PostMessage(m *Message) error {
 	for {
 		// Generate a random ID for the message
 		m.MessageID = util.GenerateSecureID(MessageIDLength)
 		// Verify the ID doesn't already exist
-		_, err := GetMessage(m.MessageID)
-		if err == gorm.ErrRecordNotFound {
+		_, err := s.GetMessage(m.MessageID)
+		if err == ErrNotFound {
 			break
 		}
 	}
-	err := db.Save(m).Error
-	return err
+	m.Status = StatusQueued
+	m.store = s
+	err := s.db.Save(m).Error
+	if err != nil {
+		return err
+	}
+	m.publish(events.MessageQueued)
+	return nil
+}
+
+// PutMessage saves an existing message into the database
+func (s *GormStore) PutMessage(m *Message) error {
+	return s.db.Save(m).Error
+}
+
+// GetPreviousMessage returns the last message sent before the provided one to
+// the same domain using the same scenario, which has a known outcome.
+func (s *GormStore) GetPreviousMessage(m *Message) (*Message, error) {
+	previous := &Message{store: s}
+	err := s.db.Where("org_id=? and domain_hash=? and scenario=? and id < ? and status in (?)",
+		m.OrgID, m.DomainHash, m.Scenario, m.ID, []string{StatusSent, StatusReceived, StatusRejected}).
+		Order("id desc").First(previous).Error
+	return previous, notFound(err)
 }
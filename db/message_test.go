@@ -3,20 +3,25 @@ package db
 import (
 	"strconv"
 	"testing"
-
-	"github.com/jinzhu/gorm"
+	"time"
 
 	"github.com/gophish/healthcheck/config"
 )
 
-func setupConfig(t *testing.T) {
+// testSecretKey is the secret key used to identify and encrypt domains in
+// tests
+const testSecretKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func setupConfig(t *testing.T) *GormStore {
 	config.Config.DBName = "sqlite3"
 	config.Config.DBPath = ":memory:"
 	config.Config.MigrationsPath = "../db/sqlite3/migrations/"
-	err := Setup()
+	config.Config.SecretKey = testSecretKey
+	store, err := Setup()
 	if err != nil {
 		t.Fatalf("Failed setting up the database: %s", err.Error())
 	}
+	return store
 }
 
 func createMessage() *Message {
@@ -53,14 +58,14 @@ func TestMessageNoMailServer(t *testing.T) {
 }
 
 func TestGetMessage(t *testing.T) {
-	setupConfig(t)
+	store := setupConfig(t)
 	m := createMessage()
-	err := PostMessage(m)
+	err := store.PostMessage(m)
 	if err != nil {
 		t.Fatalf("Unexpected error when creating message: %s", err.Error())
 	}
 
-	got, err := GetMessage(m.MessageID)
+	got, err := store.GetMessage(m.MessageID)
 	if err != nil {
 		t.Fatalf("Unexpected error when getting message: %s", err.Error())
 	}
@@ -69,23 +74,42 @@ func TestGetMessage(t *testing.T) {
 	}
 }
 
+func TestTLSReportSaved(t *testing.T) {
+	store := setupConfig(t)
+	m := createMessage()
+	m.TLS.Version = "TLS 1.3"
+	m.TLS.DANE = "usable"
+	m.TLS.MTASTS = "enforce"
+	err := store.PostMessage(m)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating message: %s", err.Error())
+	}
+	got, err := store.GetMessage(m.MessageID)
+	if err != nil {
+		t.Fatalf("Unexpected error when getting message: %s", err.Error())
+	}
+	if got.TLS.Version != m.TLS.Version || got.TLS.DANE != m.TLS.DANE || got.TLS.MTASTS != m.TLS.MTASTS {
+		t.Fatalf("Unexpected TLS report. Expected %+v Got %+v", m.TLS, got.TLS)
+	}
+}
+
 func TestInvalidGetMessage(t *testing.T) {
-	setupConfig(t)
+	store := setupConfig(t)
 	m := createMessage()
-	err := PostMessage(m)
+	err := store.PostMessage(m)
 	if err != nil {
 		t.Fatalf("Unexpected error when creating message: %s", err.Error())
 	}
-	_, err = GetMessage("InvalidID")
-	if err != gorm.ErrRecordNotFound {
-		t.Fatalf("Unexpected error received when fetching invalid message. Expected %v Got %v", gorm.ErrRecordNotFound, err)
+	_, err = store.GetMessage("InvalidID")
+	if err != ErrNotFound {
+		t.Fatalf("Unexpected error received when fetching invalid message. Expected %v Got %v", ErrNotFound, err)
 	}
 }
 
 func TestMessageIDLength(t *testing.T) {
-	setupConfig(t)
+	store := setupConfig(t)
 	m := createMessage()
-	err := PostMessage(m)
+	err := store.PostMessage(m)
 	if err != nil {
 		t.Fatalf("Unexpected error when creating message: %s", err.Error())
 	}
@@ -102,7 +126,7 @@ func TestDialerCustomPort(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Unexpected error when creating message: %v", err)
 	}
-	got := d.(*Dialer).Dialer.Port
+	got := d.(*Dialer).Port
 	if got != DefaultSMTPPort {
 		t.Fatalf("Unexpected port found in dialer. Expected %d Got %d", DefaultSMTPPort, got)
 	}
@@ -113,8 +137,154 @@ func TestDialerCustomPort(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Unexpected error when creating message: %v", err)
 	}
-	got = d.(*Dialer).Dialer.Port
+	got = d.(*Dialer).Port
 	if got != expectedPort {
 		t.Fatalf("Unexpected port found in dialer. Expected %d Got %d", expectedPort, got)
 	}
 }
+
+func TestGenerateFromAddress(t *testing.T) {
+	config.Config.EmailHostname = "mail.example.org"
+	config.Config.LookalikeHostname = "mai1.example.org"
+	m := createMessage()
+	m.MessageID = "abc"
+	testSuite := map[string]string{
+		"":                  "\"Gophish Healthcheck\" <no-reply@abc.mail.example.org>",
+		FromEmbeddedAddress: "\"ceo@example.com\" <no-reply@abc.mail.example.org>",
+		FromRecipientDomain: "\"Gophish Healthcheck\" <no-reply@example.com>",
+		FromLookalike:       "\"Gophish Healthcheck\" <no-reply@abc.mai1.example.org>",
+	}
+	for scenario, expected := range testSuite {
+		m.MessageConfiguration.HeaderFrom = scenario
+		got, err := m.generateFromAddress()
+		if err != nil {
+			t.Fatalf("Unexpected error when generating %s from address: %v", scenario, err)
+		}
+		if got != expected {
+			t.Fatalf("Unexpected %s from address.\nGot %s\nExpected %s", scenario, got, expected)
+		}
+		if m.envelopeSender() != "no-reply@abc.mail.example.org" {
+			t.Fatalf("Unexpected envelope sender for %s scenario: %s", scenario, m.envelopeSender())
+		}
+	}
+
+	m.MessageConfiguration.HeaderFrom = FromExecutive
+	m.MessageConfiguration.DisplayName = "Jane Doe"
+	expected := "\"Jane Doe\" <no-reply@abc.mail.example.org>"
+	got, err := m.generateFromAddress()
+	if err != nil {
+		t.Fatalf("Unexpected error when generating executive from address: %v", err)
+	}
+	if got != expected {
+		t.Fatalf("Unexpected executive from address.\nGot %s\nExpected %s", got, expected)
+	}
+}
+
+func TestSenderScenarioValidation(t *testing.T) {
+	config.Config.LookalikeHostname = ""
+	m := createMessage()
+	m.MessageConfiguration.HeaderFrom = FromExecutive
+	err := m.Validate()
+	if err != ErrMissingDisplayName {
+		t.Fatalf("Didn't receive expected error with empty display name. Got: %v", err)
+	}
+	m.MessageConfiguration.HeaderFrom = FromLookalike
+	err = m.Validate()
+	if err != ErrMissingLookalikeHostname {
+		t.Fatalf("Didn't receive expected error with no lookalike hostname. Got: %v", err)
+	}
+	m.MessageConfiguration.HeaderFrom = "invalid"
+	err = m.Validate()
+	if err != ErrInvalidSenderScenario {
+		t.Fatalf("Didn't receive expected error with invalid scenario. Got: %v", err)
+	}
+}
+
+func TestTTLScenarioValidation(t *testing.T) {
+	m := createMessage()
+	for _, ttl := range []string{TTLLong, TTLZero} {
+		m.MessageConfiguration.TTL = ttl
+		err := m.Validate()
+		if err != nil {
+			t.Fatalf("Received unexpected error with the %s TTL: %v", ttl, err)
+		}
+	}
+	m.MessageConfiguration.TTL = "invalid"
+	err := m.Validate()
+	if err != ErrInvalidTTLScenario {
+		t.Fatalf("Didn't receive expected error with invalid TTL. Got: %v", err)
+	}
+}
+
+func TestEnvelopeSender(t *testing.T) {
+	config.Config.EmailHostname = "mail.example.org"
+	config.Config.EnvelopeHostname = "bounce.example.net"
+	m := createMessage()
+	m.MessageID = "abc"
+	testSuite := map[string]string{
+		"":                 "no-reply@abc.mail.example.org",
+		EnvelopeSubdomain:  "no-reply@bounce.abc.mail.example.org",
+		EnvelopeMisaligned: "no-reply@abc.bounce.example.net",
+	}
+	for scenario, expected := range testSuite {
+		m.MessageConfiguration.Envelope = scenario
+		got := m.envelopeSender()
+		if got != expected {
+			t.Fatalf("Unexpected %s envelope sender.\nGot %s\nExpected %s", scenario, got, expected)
+		}
+		from, err := m.generateFromAddress()
+		if err != nil {
+			t.Fatalf("Unexpected error when generating from address: %v", err)
+		}
+		if from != "\"Gophish Healthcheck\" <no-reply@abc.mail.example.org>" {
+			t.Fatalf("Unexpected from address for %s envelope: %s", scenario, from)
+		}
+	}
+	config.Config.EnvelopeHostname = ""
+	m.MessageConfiguration.Envelope = EnvelopeMisaligned
+	err := m.Validate()
+	if err != ErrMissingEnvelopeHostname {
+		t.Fatalf("Didn't receive expected error with no envelope hostname. Got: %v", err)
+	}
+	m.MessageConfiguration.Envelope = EnvelopeSubdomain
+	m.MessageConfiguration.EnvelopeSPF = "invalid"
+	err = m.Validate()
+	if err != ErrInvalidEnvelopeScenario {
+		t.Fatalf("Didn't receive expected error with an invalid envelope SPF. Got: %v", err)
+	}
+}
+
+func TestGetMessagesFilter(t *testing.T) {
+	store := setupConfig(t)
+	createScenarioMessage(t, store, "baseline", StatusSent)
+	createScenarioMessage(t, store, "spf_hardfail", StatusRejected)
+	messages, err := store.GetMessages(MessageFilter{DomainHash: "hash", Scenario: "spf_hardfail"})
+	if err != nil {
+		t.Fatalf("Unexpected error when getting messages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].Scenario != "spf_hardfail" {
+		t.Fatalf("Unexpected messages returned: %#v", messages)
+	}
+	messages, err = store.GetMessages(MessageFilter{DomainHash: "hash", From: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Unexpected error when getting messages: %v", err)
+	}
+	if len(messages) != 0 {
+		t.Fatalf("Unexpected number of messages. Got %d Expected 0", len(messages))
+	}
+}
+
+func TestValidMessageID(t *testing.T) {
+	tests := map[string]bool{
+		"0123456789abcdef0123456789abcdef": true,
+		"0123456789ABCDEF0123456789ABCDEF": false,
+		"0123456789abcdef":                 false,
+		"www":                              false,
+		"0123456789abcdef0123456789abcdeg": false,
+	}
+	for id, expected := range tests {
+		if ValidMessageID(id) != expected {
+			t.Fatalf("Unexpected result for %q. Expected %v", id, expected)
+		}
+	}
+}
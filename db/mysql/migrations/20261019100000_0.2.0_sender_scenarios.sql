@@ -0,0 +1,11 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE `messages` ADD COLUMN `header_from` varchar(255);
+ALTER TABLE `messages` ADD COLUMN `display_name` varchar(255);
+ALTER TABLE `messages` ADD COLUMN `reply_to` varchar(255);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE `messages` DROP COLUMN `header_from`;
+ALTER TABLE `messages` DROP COLUMN `display_name`;
+ALTER TABLE `messages` DROP COLUMN `reply_to`;
@@ -0,0 +1,42 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS `domains` (
+    `id` integer primary key auto_increment,
+    `created_at` datetime,
+    `updated_at` datetime,
+    `domain_hash` varchar(255) NOT NULL UNIQUE,
+    `verification_token` varchar(255) NOT NULL,
+    `verified` boolean,
+    `verified_at` datetime);
+
+CREATE TABLE IF NOT EXISTS `schedules` (
+    `id` integer primary key auto_increment,
+    `created_at` datetime,
+    `updated_at` datetime,
+    `domain_hash` varchar(255) NOT NULL,
+    `recipient` varchar(255) NOT NULL,
+    `mail_server` varchar(255) NOT NULL,
+    `scenarios` varchar(1024) NOT NULL,
+    `cron` varchar(255),
+    `interval` integer,
+    `paused` boolean,
+    `last_run_at` datetime,
+    `next_run_at` datetime);
+
+CREATE TABLE IF NOT EXISTS `runs` (
+    `id` integer primary key auto_increment,
+    `created_at` datetime,
+    `updated_at` datetime,
+    `domain_hash` varchar(255) NOT NULL,
+    `schedule_id` integer);
+
+ALTER TABLE `messages` ADD COLUMN `run_id` integer;
+ALTER TABLE `messages` ADD COLUMN `scenario` varchar(255);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE `messages` DROP COLUMN `run_id`;
+ALTER TABLE `messages` DROP COLUMN `scenario`;
+DROP TABLE `runs`;
+DROP TABLE `schedules`;
+DROP TABLE `domains`;
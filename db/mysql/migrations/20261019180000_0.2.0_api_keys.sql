@@ -0,0 +1,16 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS `api_keys` (
+    `id` integer primary key auto_increment,
+    `created_at` datetime,
+    `updated_at` datetime,
+    `last_used_at` datetime,
+    `name` varchar(255),
+    `hint` varchar(255),
+    `key_hash` varchar(255) NOT NULL UNIQUE,
+    `scopes` varchar(255),
+    `domains` text);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE `api_keys`;
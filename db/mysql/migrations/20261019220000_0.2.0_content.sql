@@ -0,0 +1,9 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE `messages` ADD COLUMN `attachment` varchar(255);
+ALTER TABLE `messages` ADD COLUMN `link` varchar(255);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE `messages` DROP COLUMN `link`;
+ALTER TABLE `messages` DROP COLUMN `attachment`;
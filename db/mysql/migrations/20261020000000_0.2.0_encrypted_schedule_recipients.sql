@@ -0,0 +1,13 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+-- Existing recipients are encrypted by the application
+ALTER TABLE `schedules` ADD COLUMN `encrypted_recipient` varchar(1024);
+ALTER TABLE `schedules` MODIFY `recipient` varchar(255);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+-- Encrypted recipients can't be decrypted here, so schedules saved since
+-- the upgrade are left without a recipient.
+UPDATE `schedules` SET `recipient` = '' WHERE `recipient` IS NULL;
+ALTER TABLE `schedules` MODIFY `recipient` varchar(255) NOT NULL;
+ALTER TABLE `schedules` DROP COLUMN `encrypted_recipient`;
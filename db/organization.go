@@ -0,0 +1,54 @@
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultOrganizationID is the organization created when upgrading, which
+// owns every domain, key and result created before organizations existed.
+const DefaultOrganizationID = 1
+
+// ErrMissingOrganizationName occurs when an organization is created without
+// a name.
+var ErrMissingOrganizationName = errors.New("no organization name specified")
+
+// Organization is a tenant owning domains, API keys, schedules and results.
+// Every query for tenant data is scoped to a single organization.
+type Organization struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Name      string    `json:"name"`
+}
+
+// Validate ensures the organization has a name
+func (o *Organization) Validate() error {
+	if o.Name == "" {
+		return ErrMissingOrganizationName
+	}
+	return nil
+}
+
+// GetOrganizations returns every organization
+func (s *GormStore) GetOrganizations() ([]Organization, error) {
+	orgs := []Organization{}
+	err := s.db.Order("id asc").Find(&orgs).Error
+	return orgs, err
+}
+
+// GetOrganization returns the organization with the provided ID
+func (s *GormStore) GetOrganization(id uint) (*Organization, error) {
+	org := &Organization{}
+	err := s.db.Where("id=?", id).First(org).Error
+	return org, notFound(err)
+}
+
+// PostOrganization saves a new organization into the database
+func (s *GormStore) PostOrganization(o *Organization) error {
+	err := o.Validate()
+	if err != nil {
+		return err
+	}
+	return s.db.Save(o).Error
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestPostOrganization(t *testing.T) {
+	store := setupConfig(t)
+	err := store.PostOrganization(&Organization{})
+	if err != ErrMissingOrganizationName {
+		t.Fatalf("Unexpected error. Got %v Expected %v", err, ErrMissingOrganizationName)
+	}
+	org := &Organization{Name: "Mail Team"}
+	err = store.PostOrganization(org)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating organization: %v", err)
+	}
+	got, err := store.GetOrganization(org.ID)
+	if err != nil || got.Name != org.Name {
+		t.Fatalf("Unexpected organization returned: %#v, %v", got, err)
+	}
+}
+
+func TestOrganizationIsolation(t *testing.T) {
+	store := setupConfig(t)
+	org := &Organization{Name: "Other"}
+	err := store.PostOrganization(org)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating organization: %v", err)
+	}
+	createScenarioMessage(t, store, "spf_hardfail", StatusRejected)
+	m := createScenarioMessage(t, store, "spf_hardfail", StatusSent)
+	m.OrgID = org.ID
+	err = store.PutMessage(m)
+	if err != nil {
+		t.Fatalf("Unexpected error when saving message: %v", err)
+	}
+
+	// The other organization's delivery isn't a regression for the default
+	// organization
+	err = CheckPosture(store, m)
+	if err != nil {
+		t.Fatalf("Unexpected error when checking posture: %v", err)
+	}
+	events, err := store.GetPostureEvents(DefaultOrganizationID, "hash", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error when getting events: %v", err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("Unexpected number of events. Got %d Expected 0", len(events))
+	}
+
+	messages, err := store.GetMessages(MessageFilter{OrgID: DefaultOrganizationID, DomainHash: "hash"})
+	if err != nil {
+		t.Fatalf("Unexpected error when getting messages: %v", err)
+	}
+	if len(messages) != 1 || messages[0].Status != StatusRejected {
+		t.Fatalf("Unexpected messages returned: %#v", messages)
+	}
+}
@@ -0,0 +1,11 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE "messages" ADD COLUMN "envelope" varchar(255);
+ALTER TABLE "messages" ADD COLUMN "envelope_spf" varchar(255);
+ALTER TABLE "messages" ADD COLUMN "alignment" varchar(255);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE "messages" DROP COLUMN "envelope";
+ALTER TABLE "messages" DROP COLUMN "envelope_spf";
+ALTER TABLE "messages" DROP COLUMN "alignment";
@@ -0,0 +1,23 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE "messages" ADD COLUMN "tls_policy" varchar(255);
+ALTER TABLE "messages" ADD COLUMN "tls_offered" boolean;
+ALTER TABLE "messages" ADD COLUMN "tls_used" boolean;
+ALTER TABLE "messages" ADD COLUMN "tls_version" varchar(255);
+ALTER TABLE "messages" ADD COLUMN "tls_cipher_suite" varchar(255);
+ALTER TABLE "messages" ADD COLUMN "tls_certificate_valid" boolean;
+ALTER TABLE "messages" ADD COLUMN "tls_name_match" boolean;
+ALTER TABLE "messages" ADD COLUMN "tls_certificate_error" varchar(1024);
+ALTER TABLE "messages" ADD COLUMN "tls_legacy_protocols" varchar(255);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE "messages" DROP COLUMN "tls_policy";
+ALTER TABLE "messages" DROP COLUMN "tls_offered";
+ALTER TABLE "messages" DROP COLUMN "tls_used";
+ALTER TABLE "messages" DROP COLUMN "tls_version";
+ALTER TABLE "messages" DROP COLUMN "tls_cipher_suite";
+ALTER TABLE "messages" DROP COLUMN "tls_certificate_valid";
+ALTER TABLE "messages" DROP COLUMN "tls_name_match";
+ALTER TABLE "messages" DROP COLUMN "tls_certificate_error";
+ALTER TABLE "messages" DROP COLUMN "tls_legacy_protocols";
@@ -0,0 +1,19 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE "messages" ADD COLUMN "status" varchar(255);
+
+CREATE TABLE IF NOT EXISTS "posture_events" (
+    "id" serial primary key,
+    "created_at" timestamp with time zone,
+    "domain_hash" varchar(255) NOT NULL,
+    "scenario" varchar(255) NOT NULL,
+    "type" varchar(255) NOT NULL,
+    "message_id" varchar(255) NOT NULL,
+    "outcome" varchar(255),
+    "previous_message_id" varchar(255),
+    "previous_outcome" varchar(255));
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE "posture_events";
+ALTER TABLE "messages" DROP COLUMN "status";
@@ -0,0 +1,33 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS "dns_queries" (
+    "id" serial primary key,
+    "created_at" timestamp with time zone,
+    "message_id" varchar(255) NOT NULL,
+    "name" varchar(255),
+    "type" varchar(255),
+    "remote_ip" varchar(255));
+
+CREATE TABLE IF NOT EXISTS "webhooks" (
+    "id" serial primary key,
+    "created_at" timestamp with time zone,
+    "updated_at" timestamp with time zone,
+    "domain_hash" varchar(255),
+    "url" varchar(1024) NOT NULL,
+    "secret" varchar(255) NOT NULL);
+
+CREATE TABLE IF NOT EXISTS "webhook_deliveries" (
+    "id" serial primary key,
+    "created_at" timestamp with time zone,
+    "webhook_id" integer NOT NULL,
+    "event" varchar(255),
+    "message_id" varchar(255),
+    "attempt" integer,
+    "status_code" integer,
+    "error" varchar(1024));
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE "webhook_deliveries";
+DROP TABLE "webhooks";
+DROP TABLE "dns_queries";
@@ -0,0 +1,16 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS "api_keys" (
+    "id" serial primary key,
+    "created_at" timestamp with time zone,
+    "updated_at" timestamp with time zone,
+    "last_used_at" timestamp with time zone,
+    "name" varchar(255),
+    "hint" varchar(255),
+    "key_hash" varchar(255) NOT NULL UNIQUE,
+    "scopes" varchar(255),
+    "domains" text);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE "api_keys";
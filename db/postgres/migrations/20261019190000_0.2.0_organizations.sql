@@ -0,0 +1,38 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS "organizations" (
+    "id" serial primary key,
+    "created_at" timestamp with time zone,
+    "updated_at" timestamp with time zone,
+    "name" varchar(255) NOT NULL);
+
+-- Everything created before organizations existed belongs to the default
+-- organization
+INSERT INTO "organizations" ("id", "created_at", "updated_at", "name")
+    VALUES (1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 'Default');
+SELECT setval('organizations_id_seq', (SELECT MAX("id") FROM "organizations"));
+
+ALTER TABLE "messages" ADD COLUMN "org_id" integer NOT NULL DEFAULT 1;
+ALTER TABLE "schedules" ADD COLUMN "org_id" integer NOT NULL DEFAULT 1;
+ALTER TABLE "runs" ADD COLUMN "org_id" integer NOT NULL DEFAULT 1;
+ALTER TABLE "posture_events" ADD COLUMN "org_id" integer NOT NULL DEFAULT 1;
+ALTER TABLE "webhooks" ADD COLUMN "org_id" integer NOT NULL DEFAULT 1;
+ALTER TABLE "api_keys" ADD COLUMN "org_id" integer NOT NULL DEFAULT 1;
+
+-- Domains are unique per organization rather than globally
+ALTER TABLE "domains" ADD COLUMN "org_id" integer NOT NULL DEFAULT 1;
+ALTER TABLE "domains" DROP CONSTRAINT "domains_domain_hash_key";
+ALTER TABLE "domains" ADD CONSTRAINT "domains_org_id_domain_hash_key" UNIQUE ("org_id", "domain_hash");
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE "domains" DROP CONSTRAINT "domains_org_id_domain_hash_key";
+ALTER TABLE "domains" ADD CONSTRAINT "domains_domain_hash_key" UNIQUE ("domain_hash");
+ALTER TABLE "domains" DROP COLUMN "org_id";
+ALTER TABLE "api_keys" DROP COLUMN "org_id";
+ALTER TABLE "webhooks" DROP COLUMN "org_id";
+ALTER TABLE "posture_events" DROP COLUMN "org_id";
+ALTER TABLE "runs" DROP COLUMN "org_id";
+ALTER TABLE "schedules" DROP COLUMN "org_id";
+ALTER TABLE "messages" DROP COLUMN "org_id";
+DROP TABLE "organizations";
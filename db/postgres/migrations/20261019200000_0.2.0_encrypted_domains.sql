@@ -0,0 +1,9 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE "domains" ADD COLUMN "encrypted_name" varchar(1024);
+ALTER TABLE "messages" ADD COLUMN "encrypted_recipient" varchar(1024);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE "domains" DROP COLUMN "encrypted_name";
+ALTER TABLE "messages" DROP COLUMN "encrypted_recipient";
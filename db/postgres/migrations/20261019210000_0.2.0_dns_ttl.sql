@@ -0,0 +1,9 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE "messages" ADD COLUMN "ttl" varchar(255);
+ALTER TABLE "dns_queries" ADD COLUMN "ttl" integer default 0;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+ALTER TABLE "dns_queries" DROP COLUMN "ttl";
+ALTER TABLE "messages" DROP COLUMN "ttl";
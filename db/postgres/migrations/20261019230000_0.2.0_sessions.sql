@@ -0,0 +1,12 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS "sessions" (
+    "id" serial primary key,
+    "created_at" timestamp with time zone,
+    "expires_at" timestamp with time zone,
+    "api_key_id" integer NOT NULL,
+    "id_hash" varchar(255) NOT NULL UNIQUE);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE "sessions";
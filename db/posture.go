@@ -0,0 +1,149 @@
+package db
+
+import (
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+)
+
+const (
+	// OutcomeBlocked indicates the mail server rejected the message
+	OutcomeBlocked = "blocked"
+	// OutcomeDelivered indicates the mail server accepted the message
+	OutcomeDelivered = "delivered"
+)
+
+const (
+	// EventRegression indicates a scenario which should be blocked is now
+	// delivered, or a scenario which should be delivered is now blocked
+	EventRegression = "regression"
+	// EventImprovement indicates a scenario changed to the expected outcome
+	EventImprovement = "improvement"
+)
+
+// PostureEvent is raised when the outcome of a scenario sent to a domain
+// differs from the previous time the scenario was sent.
+type PostureEvent struct {
+	ID                uint      `gorm:"primary_key" json:"id"`
+	CreatedAt         time.Time `json:"created_at"`
+	OrgID             uint      `json:"org_id"`
+	DomainHash        string    `json:"domain_hash"`
+	Scenario          string    `json:"scenario"`
+	Type              string    `json:"type"`
+	MessageID         string    `json:"message_id"`
+	Outcome           string    `json:"outcome"`
+	PreviousMessageID string    `json:"previous_message_id"`
+	PreviousOutcome   string    `json:"previous_outcome"`
+}
+
+// Outcome returns whether the message was blocked or delivered. An empty
+// string is returned if the outcome isn't known (yet).
+func (m *Message) Outcome() string {
+	switch m.Status {
+	case StatusSent, StatusReceived:
+		return OutcomeDelivered
+	case StatusRejected:
+		return OutcomeBlocked
+	}
+	return ""
+}
+
+// Pending returns whether the message may still change status, because it
+// hasn't been sent yet or the mail server asked us to try again later.
+func (m *Message) Pending() bool {
+	return m.Status == StatusQueued || m.Status == StatusDeferred
+}
+
+// Pending returns whether any of the messages may still change status
+func Pending(messages ...Message) bool {
+	for _, m := range messages {
+		if m.Pending() {
+			return true
+		}
+	}
+	return false
+}
+
+// Expected returns the outcome expected from the message's scenario. An empty
+// string is returned if the message wasn't sent using a scenario.
+func (m *Message) Expected() string {
+	scenario, err := GetScenario(m.Scenario)
+	if err != nil {
+		return ""
+	}
+	if scenario.Blocked {
+		return OutcomeBlocked
+	}
+	return OutcomeDelivered
+}
+
+// Result returns the grade of the message against its scenario. An empty
+// string is returned if the message wasn't sent using a scenario.
+func (m *Message) Result() string {
+	scenario, err := GetScenario(m.Scenario)
+	if err != nil {
+		return ""
+	}
+	return scenario.Grade(m)
+}
+
+// CheckPosture compares the outcome of the message with the previous message
+// sent to the same domain using the same scenario, saving an event in the
+// store if the outcome changed.
+func CheckPosture(s Store, m *Message) error {
+	if m.Scenario == "" {
+		return nil
+	}
+	scenario, err := GetScenario(m.Scenario)
+	if err != nil {
+		return nil
+	}
+	outcome := m.Outcome()
+	if outcome == "" {
+		return nil
+	}
+	previous, err := s.GetPreviousMessage(m)
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if previous.Outcome() == outcome {
+		return nil
+	}
+	e := &PostureEvent{
+		OrgID:             m.OrgID,
+		DomainHash:        m.DomainHash,
+		Scenario:          m.Scenario,
+		Type:              EventImprovement,
+		MessageID:         m.MessageID,
+		Outcome:           outcome,
+		PreviousMessageID: previous.MessageID,
+		PreviousOutcome:   previous.Outcome(),
+	}
+	if !scenario.Expects(outcome) {
+		e.Type = EventRegression
+		log.Warnf("regression detected for scenario %s on domain %s: %s is now %s",
+			m.Scenario, m.DomainHash, previous.Outcome(), outcome)
+	}
+	return s.PostPostureEvent(e)
+}
+
+// PostPostureEvent saves a posture event into the database
+func (s *GormStore) PostPostureEvent(e *PostureEvent) error {
+	return s.db.Save(e).Error
+}
+
+// GetPostureEvents returns the organization's posture events with an ID
+// greater than since, oldest first. If a domain hash is provided, only events
+// for that domain are returned.
+func (s *GormStore) GetPostureEvents(orgID uint, hash string, since uint) ([]PostureEvent, error) {
+	events := []PostureEvent{}
+	query := s.db.Where("org_id=? and id > ?", orgID, since)
+	if hash != "" {
+		query = query.Where("domain_hash=?", hash)
+	}
+	err := query.Order("id asc").Find(&events).Error
+	return events, err
+}
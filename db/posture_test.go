@@ -0,0 +1,108 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func createScenarioMessage(t *testing.T, store Store, scenario, status string) *Message {
+	m := createMessage()
+	m.OrgID = DefaultOrganizationID
+	m.DomainHash = "hash"
+	m.Scenario = scenario
+	err := store.PostMessage(m)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating message: %v", err)
+	}
+	m.Status = status
+	err = store.PutMessage(m)
+	if err != nil {
+		t.Fatalf("Unexpected error when saving message: %v", err)
+	}
+	return m
+}
+
+func TestMessageOutcome(t *testing.T) {
+	testSuite := map[string]string{
+		StatusQueued:   "",
+		StatusDeferred: "",
+		StatusFailed:   "",
+		StatusSent:     OutcomeDelivered,
+		StatusReceived: OutcomeDelivered,
+		StatusRejected: OutcomeBlocked,
+	}
+	m := createMessage()
+	for status, expected := range testSuite {
+		m.Status = status
+		if got := m.Outcome(); got != expected {
+			t.Fatalf("Unexpected outcome for %s status. Got %s Expected %s", status, got, expected)
+		}
+	}
+}
+
+func TestMessageJSON(t *testing.T) {
+	m := createMessage()
+	m.Scenario = "spf_hardfail"
+	m.Status = StatusReceived
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatalf("Unexpected error marshaling message: %v", err)
+	}
+	got := map[string]interface{}{}
+	err = json.Unmarshal(b, &got)
+	if err != nil {
+		t.Fatalf("Unexpected error unmarshaling message: %v", err)
+	}
+	expected := map[string]interface{}{
+		"message_id": m.MessageID,
+		"pending":    false,
+		"outcome":    OutcomeDelivered,
+		"expected":   OutcomeBlocked,
+		"result":     GradeFail,
+	}
+	for field, value := range expected {
+		if got[field] != value {
+			t.Fatalf("Unexpected %s field. Expected %v Got %v", field, value, got[field])
+		}
+	}
+	if _, ok := got["configuration"]; !ok {
+		t.Fatalf("Unexpected message JSON without a configuration: %s", b)
+	}
+}
+
+func TestCheckPostureRegression(t *testing.T) {
+	store := setupConfig(t)
+	createScenarioMessage(t, store, "spf_hardfail", StatusRejected)
+	m := createScenarioMessage(t, store, "spf_hardfail", StatusSent)
+	err := CheckPosture(store, m)
+	if err != nil {
+		t.Fatalf("Unexpected error when checking posture: %v", err)
+	}
+	events, err := store.GetPostureEvents(DefaultOrganizationID, "hash", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error when getting events: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Unexpected number of events. Got %d Expected 1", len(events))
+	}
+	if events[0].Type != EventRegression || events[0].MessageID != m.MessageID {
+		t.Fatalf("Unexpected event: %#v", events[0])
+	}
+}
+
+func TestCheckPostureUnchanged(t *testing.T) {
+	store := setupConfig(t)
+	createScenarioMessage(t, store, "spf_hardfail", StatusRejected)
+	m := createScenarioMessage(t, store, "spf_hardfail", StatusRejected)
+	err := CheckPosture(store, m)
+	if err != nil {
+		t.Fatalf("Unexpected error when checking posture: %v", err)
+	}
+	events, err := store.GetPostureEvents(DefaultOrganizationID, "hash", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error when getting events: %v", err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("Unexpected number of events. Got %d Expected 0", len(events))
+	}
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"time"
+
+	"github.com/gophish/healthcheck/events"
+)
+
+// DNSQuery is a DNS query for a message received by the DNS plugin.
+type DNSQuery struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	MessageID string    `json:"message_id"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	RemoteIP  string    `json:"remote_ip"`
+	TTL       uint32    `json:"ttl"`
+}
+
+// GetDNSQueries returns the DNS queries received for a message, oldest first
+func (s *GormStore) GetDNSQueries(messageID string) ([]DNSQuery, error) {
+	queries := []DNSQuery{}
+	err := s.db.Where("message_id=?", messageID).Order("id asc").Find(&queries).Error
+	return queries, err
+}
+
+// GetDNSQueriesSince returns the DNS queries received for any of the
+// messages with an ID greater than since, oldest first
+func (s *GormStore) GetDNSQueriesSince(messageIDs []string, since uint) ([]DNSQuery, error) {
+	queries := []DNSQuery{}
+	err := s.db.Where("message_id in (?) and id > ?", messageIDs, since).Order("id asc").Find(&queries).Error
+	return queries, err
+}
+
+// Event returns the DNS lookup event for the query, which was received for
+// the provided message.
+func (q *DNSQuery) Event(m *Message) events.Event {
+	return events.Event{
+		Type:       events.DNSLookup,
+		Time:       q.CreatedAt,
+		MessageID:  m.MessageID,
+		OrgID:      m.OrgID,
+		DomainHash: m.DomainHash,
+		RunID:      m.RunID,
+		Scenario:   m.Scenario,
+		Query: &events.Query{
+			Name:     q.Name,
+			Type:     q.Type,
+			RemoteIP: q.RemoteIP,
+			TTL:      q.TTL,
+		},
+	}
+}
+
+// PostDNSQuery saves a DNS query for the provided message into the database,
+// publishing a DNS lookup event. Queries for the record shared by messages
+// testing long TTLs aren't tied to a message, so m is nil and no event is
+// published.
+func (s *GormStore) PostDNSQuery(m *Message, q *DNSQuery) error {
+	if m != nil {
+		q.MessageID = m.MessageID
+	}
+	err := s.db.Save(q).Error
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		events.Publish(q.Event(m))
+	}
+	return nil
+}
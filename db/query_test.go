@@ -0,0 +1,56 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/gophish/healthcheck/events"
+)
+
+func TestGetDNSQueriesSince(t *testing.T) {
+	store := setupConfig(t)
+	m := createScenarioMessage(t, store, "baseline", StatusSent)
+	other := createScenarioMessage(t, store, "baseline", StatusSent)
+	for _, name := range []string{"a", "b"} {
+		err := store.PostDNSQuery(m, &DNSQuery{Name: name, Type: "TXT"})
+		if err != nil {
+			t.Fatalf("Unexpected error when saving DNS query: %v", err)
+		}
+	}
+	err := store.PostDNSQuery(other, &DNSQuery{Name: "c", Type: "TXT"})
+	if err != nil {
+		t.Fatalf("Unexpected error when saving DNS query: %v", err)
+	}
+	queries, err := store.GetDNSQueriesSince([]string{m.MessageID}, 0)
+	if err != nil {
+		t.Fatalf("Unexpected error when getting DNS queries: %v", err)
+	}
+	if len(queries) != 2 {
+		t.Fatalf("Unexpected number of queries. Got %d Expected 2", len(queries))
+	}
+	queries, err = store.GetDNSQueriesSince([]string{m.MessageID}, queries[0].ID)
+	if err != nil {
+		t.Fatalf("Unexpected error when getting DNS queries: %v", err)
+	}
+	if len(queries) != 1 || queries[0].Name != "b" {
+		t.Fatalf("Unexpected queries returned: %#v", queries)
+	}
+	e := queries[0].Event(m)
+	if e.Type != events.DNSLookup || e.MessageID != m.MessageID || e.Query.Name != "b" {
+		t.Fatalf("Unexpected event for query: %#v", e)
+	}
+}
+
+func TestPostDNSQueryWithoutMessage(t *testing.T) {
+	store := setupConfig(t)
+	err := store.PostDNSQuery(nil, &DNSQuery{Name: "_ttl.example.com.", Type: "TXT", TTL: 86400})
+	if err != nil {
+		t.Fatalf("Unexpected error when saving DNS query: %v", err)
+	}
+	queries, err := store.GetDNSQueries("")
+	if err != nil {
+		t.Fatalf("Unexpected error when getting DNS queries: %v", err)
+	}
+	if len(queries) != 1 || queries[0].Name != "_ttl.example.com." || queries[0].TTL != 86400 {
+		t.Fatalf("Unexpected queries returned: %#v", queries)
+	}
+}
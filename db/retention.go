@@ -0,0 +1,108 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gophish/healthcheck/config"
+)
+
+// ErrMessageExpired occurs when a DNS query is received for a message older
+// than the DNS retention period.
+var ErrMessageExpired = errors.New("message is too old to be served")
+
+// retentionCutoff returns the time before which data kept for the provided
+// number of days expires. It returns the zero time if the data is kept
+// forever.
+func retentionCutoff(now time.Time, days int) time.Time {
+	if days <= 0 {
+		return time.Time{}
+	}
+	return now.AddDate(0, 0, -days)
+}
+
+// MessageCutoff returns the time before which messages are deleted, or the
+// zero time if messages are kept forever.
+func MessageCutoff(now time.Time) time.Time {
+	return retentionCutoff(now, config.Config.MessageRetentionDays)
+}
+
+// PersonalDataCutoff returns the time before which the personal data
+// collected for messages is erased, or the zero time if it's kept forever.
+func PersonalDataCutoff(now time.Time) time.Time {
+	return retentionCutoff(now, config.Config.PersonalDataRetentionDays)
+}
+
+// DNSExpired returns whether the DNS records for the message stopped being
+// served.
+func (m *Message) DNSExpired(now time.Time) bool {
+	cutoff := retentionCutoff(now, config.Config.DNSRetentionDays)
+	return !cutoff.IsZero() && m.CreatedAt.Before(cutoff)
+}
+
+// DeleteMessagesBefore soft deletes the messages created before t, hiding
+// them from results. It returns the number of deleted messages.
+func (s *GormStore) DeleteMessagesBefore(t time.Time) (int64, error) {
+	result := s.db.Where("created_at < ?", t).Delete(&Message{})
+	return result.RowsAffected, result.Error
+}
+
+// PurgePersonalDataBefore erases the personal data collected for messages
+// created before t, including deleted messages. This covers the recipient,
+// the responses from the mail server, which may include the recipient
+// address, and the IP addresses of the resolvers which looked up the message.
+func (s *GormStore) PurgePersonalDataBefore(t time.Time) error {
+	err := s.db.Unscoped().Model(&Message{}).Where("created_at < ?", t).UpdateColumns(map[string]interface{}{
+		"encrypted_recipient":   "",
+		"error_message":         "",
+		"tls_certificate_error": "",
+	}).Error
+	if err != nil {
+		return err
+	}
+	return s.db.Model(&DNSQuery{}).Where("created_at < ?", t).UpdateColumn("remote_ip", "").Error
+}
+
+// DeleteDomainData permanently deletes everything the organization stored
+// for the domain: messages and their DNS queries, runs, schedules, posture
+// events, webhooks and the domain itself.
+func (s *GormStore) DeleteDomainData(orgID uint, hash string) error {
+	tx := s.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	var messageIDs []string
+	err := tx.Unscoped().Model(&Message{}).Where("org_id=? and domain_hash=?", orgID, hash).Pluck("message_id", &messageIDs).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if len(messageIDs) > 0 {
+		err = tx.Where("message_id in (?)", messageIDs).Delete(&DNSQuery{}).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	var webhookIDs []uint
+	err = tx.Model(&Webhook{}).Where("org_id=? and domain_hash=?", orgID, hash).Pluck("id", &webhookIDs).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if len(webhookIDs) > 0 {
+		err = tx.Where("webhook_id in (?)", webhookIDs).Delete(&WebhookDelivery{}).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, model := range []interface{}{&Message{}, &Run{}, &Schedule{}, &PostureEvent{}, &Webhook{}, &Domain{}} {
+		err = tx.Unscoped().Where("org_id=? and domain_hash=?", orgID, hash).Delete(model).Error
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gophish/healthcheck/config"
+)
+
+func TestDNSExpired(t *testing.T) {
+	defer func() { config.Config.DNSRetentionDays = 0 }()
+	now := time.Now()
+	m := createMessage()
+	m.CreatedAt = now.AddDate(0, 0, -10)
+	if m.DNSExpired(now) {
+		t.Fatalf("Message expired without a DNS retention period")
+	}
+	config.Config.DNSRetentionDays = 30
+	if m.DNSExpired(now) {
+		t.Fatalf("Message expired before the DNS retention period")
+	}
+	config.Config.DNSRetentionDays = 7
+	if !m.DNSExpired(now) {
+		t.Fatalf("Message didn't expire after the DNS retention period")
+	}
+}
+
+func TestDeleteMessagesBefore(t *testing.T) {
+	store := setupConfig(t)
+	m := createScenarioMessage(t, store, "baseline", StatusSent)
+	deleted, err := store.DeleteMessagesBefore(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Unexpected error when deleting messages: %v", err)
+	}
+	if deleted != 1 {
+		t.Fatalf("Unexpected number of deleted messages. Got %d Expected 1", deleted)
+	}
+	_, err = store.GetMessage(m.MessageID)
+	if err != ErrNotFound {
+		t.Fatalf("Deleted message was still returned: %v", err)
+	}
+}
+
+func TestPurgePersonalDataBefore(t *testing.T) {
+	store := setupConfig(t)
+	m := createScenarioMessage(t, store, "baseline", StatusRejected)
+	m.ErrorMessage = "550 no such user test@example.com"
+	store.db.Save(m)
+	err := store.PostDNSQuery(m, &DNSQuery{Name: "example.com.", Type: "TXT", RemoteIP: "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("Unexpected error when creating DNS query: %v", err)
+	}
+	err = store.PurgePersonalDataBefore(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Unexpected error when purging personal data: %v", err)
+	}
+	got, err := store.GetMessage(m.MessageID)
+	if err != nil || got.ErrorMessage != "" || got.Status != StatusRejected {
+		t.Fatalf("Unexpected message after purge: %#v, %v", got, err)
+	}
+	queries, err := store.GetDNSQueries(m.MessageID)
+	if err != nil || len(queries) != 1 || queries[0].RemoteIP != "" {
+		t.Fatalf("Unexpected DNS queries after purge: %#v, %v", queries, err)
+	}
+}
+
+func TestDeleteDomainData(t *testing.T) {
+	store := setupConfig(t)
+	m := createScenarioMessage(t, store, "baseline", StatusSent)
+	err := store.PostDNSQuery(m, &DNSQuery{Name: "example.com.", Type: "TXT"})
+	if err != nil {
+		t.Fatalf("Unexpected error when creating DNS query: %v", err)
+	}
+	err = store.PostDomain(&Domain{OrgID: DefaultOrganizationID, DomainHash: "hash"})
+	if err != nil {
+		t.Fatalf("Unexpected error when creating domain: %v", err)
+	}
+	other := createScenarioMessage(t, store, "baseline", StatusSent)
+	other.OrgID = DefaultOrganizationID + 1
+	store.db.Save(other)
+
+	err = store.DeleteDomainData(DefaultOrganizationID, "hash")
+	if err != nil {
+		t.Fatalf("Unexpected error when deleting domain data: %v", err)
+	}
+	messages, err := store.GetMessages(MessageFilter{DomainHash: "hash"})
+	if err != nil || len(messages) != 1 || messages[0].ID != other.ID {
+		t.Fatalf("Unexpected messages after deleting domain data: %#v, %v", messages, err)
+	}
+	queries, err := store.GetDNSQueries(m.MessageID)
+	if err != nil || len(queries) != 0 {
+		t.Fatalf("Unexpected DNS queries after deleting domain data: %#v, %v", queries, err)
+	}
+	_, err = store.GetDomain(DefaultOrganizationID, "hash")
+	if err != ErrNotFound {
+		t.Fatalf("Domain wasn't deleted: %v", err)
+	}
+}
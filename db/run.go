@@ -0,0 +1,43 @@
+package db
+
+import "time"
+
+// Run is a single execution of a set of scenarios against a domain, either
+// launched by a schedule or on demand.
+type Run struct {
+	ID         uint      `gorm:"primary_key" json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	OrgID      uint      `json:"org_id"`
+	DomainHash string    `json:"domain_hash"`
+	ScheduleID uint      `json:"schedule_id"`
+	Messages   []Message `gorm:"-" json:"messages,omitempty"`
+}
+
+// GetRun returns the organization's run with the provided ID, along with its
+// messages
+func (s *GormStore) GetRun(id, orgID uint) (*Run, error) {
+	run := &Run{}
+	err := s.db.Where("id=? and org_id=?", id, orgID).First(run).Error
+	if err != nil {
+		return run, notFound(err)
+	}
+	err = s.db.Where("run_id=?", run.ID).Find(&run.Messages).Error
+	for i := range run.Messages {
+		run.Messages[i].store = s
+	}
+	return run, err
+}
+
+// GetRuns returns the organization's runs for the provided domain, most
+// recent first
+func (s *GormStore) GetRuns(orgID uint, hash string) ([]Run, error) {
+	runs := []Run{}
+	err := s.db.Where("org_id=? and domain_hash=?", orgID, hash).Order("created_at desc").Find(&runs).Error
+	return runs, err
+}
+
+// PostRun saves a new run into the database
+func (s *GormStore) PostRun(r *Run) error {
+	return s.db.Save(r).Error
+}
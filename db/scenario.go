@@ -0,0 +1,264 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/gophish/healthcheck/smtp"
+)
+
+// ErrInvalidScenario occurs when an unknown scenario is requested.
+var ErrInvalidScenario = errors.New("invalid scenario")
+
+const (
+	// CategoryAuthentication groups scenarios testing SPF, DKIM and DMARC
+	CategoryAuthentication = "authentication"
+	// CategoryImpersonation groups scenarios testing sender impersonation
+	CategoryImpersonation = "impersonation"
+	// CategoryTransport groups scenarios testing transport security
+	CategoryTransport = "transport"
+	// CategoryContent groups scenarios testing attachment and URL scanning
+	CategoryContent = "content"
+)
+
+const (
+	// GradePass indicates the message had the outcome expected for its
+	// scenario
+	GradePass = "pass"
+	// GradeWarn indicates we can't tell whether the message had the expected
+	// outcome, since it was accepted but never reported in the inbox
+	GradeWarn = "warn"
+	// GradeFail indicates the message didn't have the expected outcome
+	GradeFail = "fail"
+)
+
+// Scenario is a named message configuration which can be run on its own or
+// as part of a schedule.
+type Scenario struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Category    string `json:"category"`
+	// Blocked is true if a correctly configured mail server should block
+	// (reject or quarantine) the message.
+	Blocked bool `json:"blocked"`
+	// Quarantine is true if a correctly configured mail server may accept
+	// the message, as long as it delivers it to a quarantine or junk folder
+	// rather than the inbox.
+	Quarantine bool `json:"quarantine"`
+	// Remediation describes how to configure the mail server if the
+	// scenario doesn't have the expected outcome.
+	Remediation   string               `json:"remediation"`
+	Configuration MessageConfiguration `json:"configuration"`
+}
+
+// Scenarios are the scenarios available to schedules.
+var Scenarios = []Scenario{
+	{
+		Name:        "baseline",
+		Description: "A message passing SPF and DMARC, which should be delivered",
+		Category:    CategoryAuthentication,
+		Remediation: "Legitimate mail is being blocked. Check that your mail server isn't rejecting mail which passes SPF and DMARC.",
+		Configuration: MessageConfiguration{
+			SPF: Pass, DKIM: None, DMARC: Reject, MX: Pass,
+		},
+	},
+	{
+		Name:        "spf_softfail",
+		Description: "A message which softfails SPF with a DMARC policy of reject",
+		Category:    CategoryAuthentication,
+		Blocked:     true,
+		Remediation: "Enforce DMARC on inbound mail so that messages failing SPF from domains publishing p=reject are rejected.",
+		Configuration: MessageConfiguration{
+			SPF: SoftFail, DKIM: None, DMARC: Reject, MX: Pass,
+		},
+	},
+	{
+		Name:        "spf_hardfail",
+		Description: "A message which hardfails SPF with a DMARC policy of reject",
+		Category:    CategoryAuthentication,
+		Blocked:     true,
+		Remediation: "Reject messages which hardfail SPF, and enforce DMARC policies on inbound mail.",
+		Configuration: MessageConfiguration{
+			SPF: HardFail, DKIM: None, DMARC: Reject, MX: Pass,
+		},
+	},
+	{
+		Name:        "dmarc_quarantine",
+		Description: "A message which hardfails SPF with a DMARC policy of quarantine",
+		Category:    CategoryAuthentication,
+		Blocked:     true,
+		Quarantine:  true,
+		Remediation: "Honor DMARC p=quarantine by delivering failing messages to a quarantine or junk folder rather than the inbox.",
+		Configuration: MessageConfiguration{
+			SPF: HardFail, DKIM: None, DMARC: Quarantine, MX: Pass,
+		},
+	},
+	{
+		Name:        "no_mx",
+		Description: "A message sent from a domain without an MX record",
+		Category:    CategoryAuthentication,
+		Blocked:     true,
+		Remediation: "Reject mail from sender domains which can't receive replies (no MX record).",
+		Configuration: MessageConfiguration{
+			SPF: Pass, DKIM: None, DMARC: Reject, MX: None,
+		},
+	},
+	{
+		Name:        "misaligned_envelope",
+		Description: "A message whose envelope sender passes SPF but doesn't align with the header From",
+		Category:    CategoryAuthentication,
+		Blocked:     true,
+		Remediation: "Make sure DMARC is evaluated against the header From domain, so that an SPF pass for an unrelated envelope sender doesn't count.",
+		Configuration: MessageConfiguration{
+			SPF: HardFail, DKIM: None, DMARC: Reject, MX: Pass,
+			Envelope: EnvelopeMisaligned, EnvelopeSPF: Pass,
+		},
+	},
+	{
+		Name:        "recipient_domain",
+		Description: "A message with a header From address at the recipient's own domain",
+		Category:    CategoryImpersonation,
+		Blocked:     true,
+		Remediation: "Block inbound mail claiming to come from your own domain unless it was sent by your own mail servers, and publish a DMARC policy of reject for your domain.",
+		Configuration: MessageConfiguration{
+			SPF: Pass, DKIM: None, DMARC: Reject, MX: Pass,
+			HeaderFrom: FromRecipientDomain,
+		},
+	},
+	{
+		Name:        "embedded_address",
+		Description: "A message whose display name contains an address at the recipient's domain",
+		Category:    CategoryImpersonation,
+		Blocked:     true,
+		Remediation: "Enable display name impersonation protection on your mail gateway, or tag external messages whose display name contains an internal address.",
+		Configuration: MessageConfiguration{
+			SPF: Pass, DKIM: None, DMARC: Reject, MX: Pass,
+			HeaderFrom: FromEmbeddedAddress,
+		},
+	},
+	{
+		Name:        "cleartext",
+		Description: "A message deliberately sent without STARTTLS",
+		Category:    CategoryTransport,
+		Blocked:     true,
+		Remediation: "Require STARTTLS for inbound mail, or publish an MTA-STS policy in enforce mode so that senders refuse to deliver in cleartext.",
+		Configuration: MessageConfiguration{
+			SPF: Pass, DKIM: None, DMARC: Reject, MX: Pass,
+			TLSPolicy: smtp.TLSNone,
+		},
+	},
+	{
+		Name:        "long_ttl",
+		Description: "A message passing SPF and DMARC whose SPF record includes a record served with a long TTL under the same name in every run, which should be delivered",
+		Category:    CategoryAuthentication,
+		Remediation: "Legitimate mail is being blocked when your resolver answers its SPF include from the cache left by earlier runs. Check that your resolver serves cached records correctly until their TTL expires.",
+		Configuration: MessageConfiguration{
+			SPF: Pass, DKIM: None, DMARC: Reject, MX: Pass,
+			TTL: TTLLong,
+		},
+	},
+	{
+		Name:        "zero_ttl",
+		Description: "A message passing SPF and DMARC whose DNS records are served with a TTL of zero, which should be delivered",
+		Category:    CategoryAuthentication,
+		Remediation: "Legitimate mail is being blocked when its DNS records can't be cached. Check that your resolver and mail server accept records with a TTL of zero.",
+		Configuration: MessageConfiguration{
+			SPF: Pass, DKIM: None, DMARC: Reject, MX: Pass,
+			TTL: TTLZero,
+		},
+	},
+	{
+		Name:        "executable_attachment",
+		Description: "A message with a Windows executable attached",
+		Category:    CategoryContent,
+		Blocked:     true,
+		Remediation: "Block or strip executable attachments on your mail gateway.",
+		Configuration: MessageConfiguration{
+			SPF: Pass, DKIM: None, DMARC: Reject, MX: Pass,
+			Attachment: AttachmentExecutable,
+		},
+	},
+	{
+		Name:        "macro_attachment",
+		Description: "A message with a macro-enabled Word document attached",
+		Category:    CategoryContent,
+		Blocked:     true,
+		Remediation: "Block or quarantine macro-enabled Office documents from external senders.",
+		Configuration: MessageConfiguration{
+			SPF: Pass, DKIM: None, DMARC: Reject, MX: Pass,
+			Attachment: AttachmentMacro,
+		},
+	},
+	{
+		Name:        "eicar_attachment",
+		Description: "A message with the EICAR anti-virus test file attached",
+		Category:    CategoryContent,
+		Blocked:     true,
+		Remediation: "Scan inbound attachments with an anti-virus engine and reject messages carrying malware.",
+		Configuration: MessageConfiguration{
+			SPF: Pass, DKIM: None, DMARC: Reject, MX: Pass,
+			Attachment: AttachmentEICAR,
+		},
+	},
+	{
+		Name:        "malware_link",
+		Description: "A message linking to a URL flagged as malware by Google Safe Browsing",
+		Category:    CategoryContent,
+		Blocked:     true,
+		Remediation: "Enable URL scanning or rewriting on your mail gateway so that links to known malicious sites are blocked.",
+		Configuration: MessageConfiguration{
+			SPF: Pass, DKIM: None, DMARC: Reject, MX: Pass,
+			Link: LinkMalware,
+		},
+	},
+}
+
+// Expects returns whether the outcome of a message is the expected outcome
+// for the scenario. Since we can't tell whether an accepted message reached
+// the inbox, accepting a message which should be quarantined passes.
+func (s Scenario) Expects(outcome string) bool {
+	switch outcome {
+	case OutcomeBlocked:
+		return s.Blocked
+	case OutcomeDelivered:
+		return !s.Blocked || s.Quarantine
+	}
+	return false
+}
+
+// Grade returns whether the message had the outcome expected for the
+// scenario, or an empty string if the message hasn't been delivered or
+// rejected (yet). Messages which should be delivered pass once the recipient
+// reports them in their inbox, while messages which should be blocked pass
+// when they're rejected. An accepted message may have been quarantined, or
+// simply not reported yet, so it only passes if quarantining the message is
+// enough.
+func (s Scenario) Grade(m *Message) string {
+	switch m.Status {
+	case StatusRejected:
+		if s.Blocked {
+			return GradePass
+		}
+		return GradeFail
+	case StatusSent:
+		if s.Quarantine {
+			return GradePass
+		}
+		return GradeWarn
+	case StatusReceived:
+		if s.Blocked {
+			return GradeFail
+		}
+		return GradePass
+	}
+	return ""
+}
+
+// GetScenario returns the scenario with the provided name
+func GetScenario(name string) (Scenario, error) {
+	for _, s := range Scenarios {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return Scenario{}, ErrInvalidScenario
+}
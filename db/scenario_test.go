@@ -0,0 +1,78 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/gophish/healthcheck/config"
+)
+
+func TestScenariosValid(t *testing.T) {
+	config.Config.EnvelopeHostname = "example.net"
+	for _, s := range Scenarios {
+		m := createMessage()
+		m.MessageConfiguration = s.Configuration
+		err := m.Validate()
+		if err != nil {
+			t.Fatalf("Invalid configuration for scenario %s: %v", s.Name, err)
+		}
+	}
+	_, err := GetScenario("invalid")
+	if err != ErrInvalidScenario {
+		t.Fatalf("Didn't receive expected error with an invalid scenario. Got: %v", err)
+	}
+}
+
+func TestScenarioExpects(t *testing.T) {
+	tests := []struct {
+		scenario string
+		outcome  string
+		expected bool
+	}{
+		{"baseline", OutcomeDelivered, true},
+		{"baseline", OutcomeBlocked, false},
+		{"spf_hardfail", OutcomeBlocked, true},
+		{"spf_hardfail", OutcomeDelivered, false},
+		{"dmarc_quarantine", OutcomeBlocked, true},
+		{"dmarc_quarantine", OutcomeDelivered, true},
+		{"baseline", "", false},
+	}
+	for _, test := range tests {
+		s, err := GetScenario(test.scenario)
+		if err != nil {
+			t.Fatalf("Unexpected error getting scenario %s: %v", test.scenario, err)
+		}
+		if s.Expects(test.outcome) != test.expected {
+			t.Fatalf("Unexpected result for %s %s. Expected %v", test.scenario, test.outcome, test.expected)
+		}
+	}
+}
+
+func TestScenarioGrade(t *testing.T) {
+	tests := []struct {
+		scenario string
+		status   string
+		expected string
+	}{
+		{"baseline", StatusReceived, GradePass},
+		{"baseline", StatusSent, GradeWarn},
+		{"baseline", StatusRejected, GradeFail},
+		{"spf_hardfail", StatusRejected, GradePass},
+		{"spf_hardfail", StatusSent, GradeWarn},
+		{"spf_hardfail", StatusReceived, GradeFail},
+		{"dmarc_quarantine", StatusRejected, GradePass},
+		{"dmarc_quarantine", StatusSent, GradePass},
+		{"dmarc_quarantine", StatusReceived, GradeFail},
+		{"baseline", StatusQueued, ""},
+		{"baseline", StatusFailed, ""},
+	}
+	for _, test := range tests {
+		s, err := GetScenario(test.scenario)
+		if err != nil {
+			t.Fatalf("Unexpected error getting scenario %s: %v", test.scenario, err)
+		}
+		got := s.Grade(&Message{Status: test.status})
+		if got != test.expected {
+			t.Fatalf("Unexpected grade for %s %s. Expected %q Got %q", test.scenario, test.status, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+// MinScheduleInterval is the shortest interval allowed between two runs of
+// the same schedule.
+const MinScheduleInterval = 5 * time.Minute
+
+// maxScheduleChecks is the number of runs of a cron expression checked
+// against MinScheduleInterval.
+const maxScheduleChecks = 10000
+
+// ErrMissingScenarios occurs when a schedule doesn't specify any scenarios.
+var ErrMissingScenarios = errors.New("no scenarios specified")
+
+// ErrInvalidScheduleTiming occurs when a schedule specifies both (or
+// neither) a cron expression and an interval.
+var ErrInvalidScheduleTiming = errors.New("exactly one of cron or interval must be specified")
+
+// ErrScheduleIntervalTooShort occurs when a schedule runs more often than
+// MinScheduleInterval.
+var ErrScheduleIntervalTooShort = errors.New("schedule interval is too short")
+
+// Schedule automatically runs a set of scenarios against a verified domain,
+// either on a cron expression or at a fixed interval.
+type Schedule struct {
+	ID         uint       `gorm:"primary_key" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	OrgID      uint       `json:"org_id"`
+	DomainHash string     `json:"domain_hash"`
+	Recipient  string     `gorm:"-" json:"recipient"`
+	MailServer string     `json:"mail_server"`
+	Cron       string     `json:"cron"`
+	Interval   int        `json:"interval"`
+	Paused     bool       `json:"paused"`
+	LastRunAt  *time.Time `json:"last_run_at,omitempty"`
+	NextRunAt  time.Time  `json:"next_run_at"`
+
+	// EncryptedRecipient is the recipient stored in the database. Schedules
+	// need the recipient for every run, so it's always stored, unlike the
+	// recipients of messages.
+	EncryptedRecipient string `json:"-"`
+
+	// Scenarios is the comma-separated list of scenario names stored in the
+	// database
+	Scenarios     string   `json:"-"`
+	ScenarioNames []string `gorm:"-" json:"scenarios"`
+}
+
+// BeforeSave stores the scenario names as a comma-separated list and
+// encrypts the recipient
+func (s *Schedule) BeforeSave() (err error) {
+	s.Scenarios = strings.Join(s.ScenarioNames, ",")
+	s.EncryptedRecipient, err = encrypt(s.Recipient)
+	return err
+}
+
+// AfterFind parses the comma-separated list of scenario names and decrypts
+// the recipient
+func (s *Schedule) AfterFind() (err error) {
+	s.ScenarioNames = []string{}
+	if s.Scenarios != "" {
+		s.ScenarioNames = strings.Split(s.Scenarios, ",")
+	}
+	s.Recipient, err = decrypt(s.EncryptedRecipient)
+	return err
+}
+
+// Validate ensures the schedule is correctly formatted with all the
+// necessary fields.
+func (s *Schedule) Validate() error {
+	if s.Recipient == "" {
+		return ErrMissingRecipient
+	}
+	if s.MailServer == "" {
+		return ErrMissingMailServer
+	}
+	if len(s.ScenarioNames) == 0 {
+		return ErrMissingScenarios
+	}
+	// Validate the messages the schedule will send now, rather than failing
+	// every run later on (e.g. if a scenario needs an envelope hostname which
+	// isn't configured).
+	for _, name := range s.ScenarioNames {
+		scenario, err := GetScenario(name)
+		if err != nil {
+			return err
+		}
+		m := &Message{
+			Recipient:            s.Recipient,
+			MailServer:           s.MailServer,
+			MessageConfiguration: scenario.Configuration,
+		}
+		err = m.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	if (s.Cron == "") == (s.Interval == 0) {
+		return ErrInvalidScheduleTiming
+	}
+	if s.Cron != "" {
+		spec, err := cron.ParseStandard(s.Cron)
+		if err != nil {
+			return err
+		}
+		// Cron expressions may run at irregular intervals, so we check the
+		// gap between consecutive runs over the next year, up to
+		// maxScheduleChecks runs.
+		next := spec.Next(time.Now().UTC())
+		end := next.AddDate(1, 0, 0)
+		for i := 0; i < maxScheduleChecks && !next.IsZero() && next.Before(end); i++ {
+			following := spec.Next(next)
+			if !following.IsZero() && following.Sub(next) < MinScheduleInterval {
+				return ErrScheduleIntervalTooShort
+			}
+			next = following
+		}
+		return nil
+	}
+	if time.Duration(s.Interval)*time.Second < MinScheduleInterval {
+		return ErrScheduleIntervalTooShort
+	}
+	return nil
+}
+
+// ScheduleNext sets the next time the schedule should run, relative to the
+// provided time.
+func (s *Schedule) ScheduleNext(from time.Time) error {
+	if s.Cron == "" {
+		s.NextRunAt = from.Add(time.Duration(s.Interval) * time.Second)
+		return nil
+	}
+	spec, err := cron.ParseStandard(s.Cron)
+	if err != nil {
+		return err
+	}
+	s.NextRunAt = spec.Next(from)
+	return nil
+}
+
+// prepare validates a new schedule and sets when it should first run
+func (s *Schedule) prepare() error {
+	err := s.Validate()
+	if err != nil {
+		return err
+	}
+	return s.ScheduleNext(time.Now().UTC())
+}
+
+// encryptScheduleRecipients encrypts the recipients of the schedules saved
+// before recipients were encrypted, erasing the plaintext addresses.
+func (s *GormStore) encryptScheduleRecipients() error {
+	legacy := []struct {
+		ID        uint
+		Recipient string
+	}{}
+	err := s.db.Table("schedules").Select("id, recipient").Where("recipient is not null and recipient <> ?", "").Scan(&legacy).Error
+	if err != nil {
+		return err
+	}
+	for _, l := range legacy {
+		recipient, err := encrypt(l.Recipient)
+		if err != nil {
+			return err
+		}
+		err = s.db.Table("schedules").Where("id=?", l.ID).UpdateColumns(map[string]interface{}{
+			"encrypted_recipient": recipient,
+			"recipient":           nil,
+		}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSchedules returns the schedules for the provided domain
+func (s *GormStore) GetSchedules(orgID uint, hash string) ([]Schedule, error) {
+	schedules := []Schedule{}
+	err := s.db.Where("org_id=? and domain_hash=?", orgID, hash).Find(&schedules).Error
+	return schedules, err
+}
+
+// GetDueSchedules returns the schedules which should have run by the provided
+// time
+func (s *GormStore) GetDueSchedules(t time.Time) ([]Schedule, error) {
+	schedules := []Schedule{}
+	err := s.db.Where("paused=? and next_run_at <= ?", false, t).Find(&schedules).Error
+	return schedules, err
+}
+
+// PostSchedule validates and saves a new schedule into the database
+func (s *GormStore) PostSchedule(schedule *Schedule) error {
+	err := schedule.prepare()
+	if err != nil {
+		return err
+	}
+	return s.db.Save(schedule).Error
+}
+
+// PutSchedule saves an existing schedule into the database
+func (s *GormStore) PutSchedule(schedule *Schedule) error {
+	return s.db.Save(schedule).Error
+}
+
+// DeleteSchedule deletes the schedule with the provided ID for a domain
+func (s *GormStore) DeleteSchedule(id, orgID uint, hash string) error {
+	return s.db.Where("id=? and org_id=? and domain_hash=?", id, orgID, hash).Delete(&Schedule{}).Error
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gophish/healthcheck/config"
+)
+
+func createSchedule() *Schedule {
+	return &Schedule{
+		Recipient:     "test@example.com",
+		MailServer:    "localhost",
+		ScenarioNames: []string{"baseline", "spf_hardfail"},
+		Interval:      3600,
+	}
+}
+
+func TestScheduleValidation(t *testing.T) {
+	s := createSchedule()
+	err := s.Validate()
+	if err != nil {
+		t.Fatalf("Received unexpected error during schedule validation: %v", err)
+	}
+
+	s.Cron = "0 * * * *"
+	err = s.Validate()
+	if err != ErrInvalidScheduleTiming {
+		t.Fatalf("Didn't receive expected error with both cron and interval. Got: %v", err)
+	}
+
+	s = createSchedule()
+	s.Interval = 60
+	err = s.Validate()
+	if err != ErrScheduleIntervalTooShort {
+		t.Fatalf("Didn't receive expected error with a short interval. Got: %v", err)
+	}
+
+	for expression, expected := range map[string]error{
+		"0 * * * *":     nil,
+		"*/5 * * * *":   nil,
+		"* * * * *":     ErrScheduleIntervalTooShort,
+		"*/2 * * * *":   ErrScheduleIntervalTooShort,
+		"0,1 9 * * 1":   ErrScheduleIntervalTooShort,
+		"58 23,0 * * *": nil,
+		// Runs close to each other across midnight
+		"0,58 0,23 * * *": ErrScheduleIntervalTooShort,
+	} {
+		s = createSchedule()
+		s.Interval = 0
+		s.Cron = expression
+		err = s.Validate()
+		if err != expected {
+			t.Fatalf("Unexpected error for the cron expression %q. Expected %v Got %v", expression, expected, err)
+		}
+	}
+	s = createSchedule()
+	s.Interval = 0
+	s.Cron = "not a cron entry"
+	err = s.Validate()
+	if err == nil {
+		t.Fatal("Didn't receive expected error with an invalid cron expression")
+	}
+
+	s = createSchedule()
+	s.ScenarioNames = []string{"invalid"}
+	err = s.Validate()
+	if err != ErrInvalidScenario {
+		t.Fatalf("Didn't receive expected error with an invalid scenario. Got: %v", err)
+	}
+
+	config.Config.EnvelopeHostname = ""
+	s = createSchedule()
+	s.ScenarioNames = []string{"misaligned_envelope"}
+	err = s.Validate()
+	if err != ErrMissingEnvelopeHostname {
+		t.Fatalf("Didn't receive expected error without an envelope hostname. Got: %v", err)
+	}
+}
+
+func TestScheduleNextInterval(t *testing.T) {
+	s := createSchedule()
+	now := time.Now().UTC()
+	err := s.ScheduleNext(now)
+	if err != nil {
+		t.Fatalf("Unexpected error scheduling next run: %v", err)
+	}
+	expected := now.Add(time.Hour)
+	if !s.NextRunAt.Equal(expected) {
+		t.Fatalf("Unexpected next run. Got %s Expected %s", s.NextRunAt, expected)
+	}
+}
+
+func TestScheduleScenarioNames(t *testing.T) {
+	config.Config.SecretKey = testSecretKey
+	s := createSchedule()
+	s.BeforeSave()
+	if s.Scenarios != "baseline,spf_hardfail" {
+		t.Fatalf("Unexpected stored scenarios: %s", s.Scenarios)
+	}
+	s.ScenarioNames = nil
+	s.AfterFind()
+	if len(s.ScenarioNames) != 2 {
+		t.Fatalf("Unexpected number of scenarios. Got %d Expected 2", len(s.ScenarioNames))
+	}
+}
+
+// rawScheduleRecipients returns the recipient columns of the schedule as
+// stored in the database
+func rawScheduleRecipients(t *testing.T, store *GormStore, id uint) string {
+	row := struct {
+		Recipient          *string
+		EncryptedRecipient string
+	}{}
+	err := store.db.Table("schedules").Select("recipient, encrypted_recipient").Where("id=?", id).Scan(&row).Error
+	if err != nil {
+		t.Fatalf("Unexpected error reading the schedule: %v", err)
+	}
+	if row.Recipient != nil {
+		return *row.Recipient + " " + row.EncryptedRecipient
+	}
+	return row.EncryptedRecipient
+}
+
+func TestScheduleRecipientEncrypted(t *testing.T) {
+	store := setupConfig(t)
+	s := createSchedule()
+	s.OrgID = DefaultOrganizationID
+	s.DomainHash = "hash"
+	err := store.PostSchedule(s)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating schedule: %v", err)
+	}
+	raw := rawScheduleRecipients(t, store, s.ID)
+	if raw == "" || strings.Contains(raw, "example.com") {
+		t.Fatalf("Schedule recipient wasn't encrypted: %s", raw)
+	}
+	schedules, err := store.GetSchedules(DefaultOrganizationID, "hash")
+	if err != nil || len(schedules) != 1 || schedules[0].Recipient != s.Recipient {
+		t.Fatalf("Unexpected schedules returned: %#v, %v", schedules, err)
+	}
+}
+
+func TestEncryptScheduleRecipients(t *testing.T) {
+	store := setupConfig(t)
+	// Schedules saved before recipients were encrypted
+	err := store.db.Exec(`INSERT INTO schedules (org_id, domain_hash, recipient, mail_server, scenarios, interval) VALUES (?, ?, ?, ?, ?, ?)`,
+		DefaultOrganizationID, "hash", "test@example.com", "localhost", "baseline", 3600).Error
+	if err != nil {
+		t.Fatalf("Unexpected error when creating schedule: %v", err)
+	}
+	err = store.encryptScheduleRecipients()
+	if err != nil {
+		t.Fatalf("Unexpected error encrypting the recipients: %v", err)
+	}
+	schedules, err := store.GetSchedules(DefaultOrganizationID, "hash")
+	if err != nil || len(schedules) != 1 || schedules[0].Recipient != "test@example.com" {
+		t.Fatalf("Unexpected schedules returned: %#v, %v", schedules, err)
+	}
+	raw := rawScheduleRecipients(t, store, schedules[0].ID)
+	if strings.Contains(raw, "example.com") {
+		t.Fatalf("Schedule recipient wasn't encrypted: %s", raw)
+	}
+}
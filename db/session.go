@@ -0,0 +1,81 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gophish/healthcheck/util"
+)
+
+// SessionIDLength is the number of random bytes in a dashboard session ID.
+const SessionIDLength = 32
+
+// SessionDuration is how long a dashboard session stays valid after signing
+// in.
+const SessionDuration = 12 * time.Hour
+
+// ErrInvalidSession occurs when a session doesn't exist or has expired.
+var ErrInvalidSession = errors.New("invalid session")
+
+// Session is a dashboard session, signed in with an API key. Like API keys,
+// only the SHA-256 hash of the session ID is stored. Sessions end when they
+// expire, when signing out, or when their API key is deleted.
+type Session struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	APIKeyID  uint `gorm:"column:api_key_id"`
+	IDHash    string
+}
+
+// newSession returns a new session for the API key, along with the generated
+// session ID.
+func newSession(k *APIKey) (*Session, string) {
+	id := util.GenerateSecureID(SessionIDLength)
+	return &Session{
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: time.Now().UTC().Add(SessionDuration),
+		APIKeyID:  k.ID,
+		IDHash:    hashAPIKey(id),
+	}, id
+}
+
+// PostSession starts a new session for the API key, returning the generated
+// session ID. This is the only time the ID is available.
+func (s *GormStore) PostSession(k *APIKey) (string, error) {
+	session, id := newSession(k)
+	return id, s.db.Save(session).Error
+}
+
+// GetSessionAPIKey returns the API key the session was started with, updating
+// when it was last used like GetAPIKeyByKey. ErrInvalidSession is only
+// returned if the session or its key doesn't exist.
+func (s *GormStore) GetSessionAPIKey(id string) (*APIKey, error) {
+	session := &Session{}
+	err := notFound(s.db.Where("id_hash=? and expires_at>?", hashAPIKey(id), time.Now().UTC()).First(session).Error)
+	if err == ErrNotFound {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	k := &APIKey{}
+	err = notFound(s.db.Where("id=?", session.APIKeyID).First(k).Error)
+	if err == ErrNotFound {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, s.touch(k)
+}
+
+// DeleteSession ends the session
+func (s *GormStore) DeleteSession(id string) error {
+	return s.db.Where("id_hash=?", hashAPIKey(id)).Delete(&Session{}).Error
+}
+
+// DeleteSessionsBefore deletes the sessions which expired before t
+func (s *GormStore) DeleteSessionsBefore(t time.Time) error {
+	return s.db.Where("expires_at<?", t).Delete(&Session{}).Error
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSession(t *testing.T) {
+	store := setupConfig(t)
+	k := &APIKey{OrgID: DefaultOrganizationID, ScopeNames: []string{ScopeRead}}
+	_, err := store.PostAPIKey(k)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating API key: %v", err)
+	}
+	id, err := store.PostSession(k)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating session: %v", err)
+	}
+	got, err := store.GetSessionAPIKey(id)
+	if err != nil || got.ID != k.ID {
+		t.Fatalf("Unexpected API key for session: %#v, %v", got, err)
+	}
+	_, err = store.GetSessionAPIKey("invalid")
+	if err != ErrInvalidSession {
+		t.Fatalf("Unexpected error for an invalid session. Expected %v Got %v", ErrInvalidSession, err)
+	}
+
+	// Signing out ends the session
+	err = store.DeleteSession(id)
+	if err != nil {
+		t.Fatalf("Unexpected error when deleting session: %v", err)
+	}
+	_, err = store.GetSessionAPIKey(id)
+	if err != ErrInvalidSession {
+		t.Fatalf("Unexpected error after signing out. Expected %v Got %v", ErrInvalidSession, err)
+	}
+
+	// So does deleting the API key
+	id, err = store.PostSession(k)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating session: %v", err)
+	}
+	err = store.DeleteAPIKey(k.ID, DefaultOrganizationID)
+	if err != nil {
+		t.Fatalf("Unexpected error when deleting API key: %v", err)
+	}
+	_, err = store.GetSessionAPIKey(id)
+	if err != ErrInvalidSession {
+		t.Fatalf("Unexpected error after deleting the key. Expected %v Got %v", ErrInvalidSession, err)
+	}
+}
+
+func TestSessionExpired(t *testing.T) {
+	store := setupConfig(t)
+	k := &APIKey{OrgID: DefaultOrganizationID, ScopeNames: []string{ScopeRead}}
+	_, err := store.PostAPIKey(k)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating API key: %v", err)
+	}
+	id, err := store.PostSession(k)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating session: %v", err)
+	}
+	err = store.db.Model(&Session{}).Where("id_hash=?", hashAPIKey(id)).UpdateColumn("expires_at", time.Now().UTC().Add(-time.Minute)).Error
+	if err != nil {
+		t.Fatalf("Unexpected error expiring session: %v", err)
+	}
+	_, err = store.GetSessionAPIKey(id)
+	if err != ErrInvalidSession {
+		t.Fatalf("Unexpected error for an expired session. Expected %v Got %v", ErrInvalidSession, err)
+	}
+	err = store.DeleteSessionsBefore(time.Now().UTC())
+	if err != nil {
+		t.Fatalf("Unexpected error deleting expired sessions: %v", err)
+	}
+	count := 0
+	store.db.Model(&Session{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("Expired sessions weren't deleted. Got %d sessions", count)
+	}
+}
+
+func TestSessionDatabaseError(t *testing.T) {
+	store := setupConfig(t)
+	k := &APIKey{OrgID: DefaultOrganizationID, ScopeNames: []string{ScopeRead}}
+	_, err := store.PostAPIKey(k)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating API key: %v", err)
+	}
+	id, err := store.PostSession(k)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating session: %v", err)
+	}
+	// Database errors aren't reported as invalid sessions
+	store.Close()
+	_, err = store.GetSessionAPIKey(id)
+	if err == nil || err == ErrInvalidSession {
+		t.Fatalf("Unexpected error with the database closed. Got %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE "messages" ADD COLUMN "envelope" varchar(255);
+ALTER TABLE "messages" ADD COLUMN "envelope_spf" varchar(255);
+ALTER TABLE "messages" ADD COLUMN "alignment" varchar(255);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+-- SQLite can't drop columns, so the table is rebuilt without them
+ALTER TABLE "messages" RENAME TO "messages_old";
+CREATE TABLE "messages" (
+    "id" integer primary key autoincrement,
+    "domain_hash" varchar(255) NOT NULL,
+    "message_id" varchar(255) NOT NULL,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "deleted_at" datetime,
+    "spf" varchar(255),
+    "dkim" varchar(255),
+    "dmarc" varchar(255),
+    "mail_server" varchar(255),
+    "error_message" varchar(1024),
+    "successful" boolean,
+    "mx" varchar(255),
+    "header_from" varchar(255),
+    "display_name" varchar(255),
+    "reply_to" varchar(255));
+INSERT INTO "messages" ("id", "domain_hash", "message_id", "created_at", "updated_at", "deleted_at", "spf", "dkim", "dmarc", "mail_server", "error_message", "successful", "mx", "header_from", "display_name", "reply_to")
+    SELECT "id", "domain_hash", "message_id", "created_at", "updated_at", "deleted_at", "spf", "dkim", "dmarc", "mail_server", "error_message", "successful", "mx", "header_from", "display_name", "reply_to" FROM "messages_old";
+DROP TABLE "messages_old";
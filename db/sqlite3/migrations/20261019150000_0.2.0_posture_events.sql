@@ -0,0 +1,55 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE "messages" ADD COLUMN "status" varchar(255);
+
+CREATE TABLE IF NOT EXISTS "posture_events" (
+    "id" integer primary key autoincrement,
+    "created_at" datetime,
+    "domain_hash" varchar(255) NOT NULL,
+    "scenario" varchar(255) NOT NULL,
+    "type" varchar(255) NOT NULL,
+    "message_id" varchar(255) NOT NULL,
+    "outcome" varchar(255),
+    "previous_message_id" varchar(255),
+    "previous_outcome" varchar(255));
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+-- SQLite can't drop columns, so the table is rebuilt without them
+ALTER TABLE "messages" RENAME TO "messages_old";
+CREATE TABLE "messages" (
+    "id" integer primary key autoincrement,
+    "domain_hash" varchar(255) NOT NULL,
+    "message_id" varchar(255) NOT NULL,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "deleted_at" datetime,
+    "spf" varchar(255),
+    "dkim" varchar(255),
+    "dmarc" varchar(255),
+    "mail_server" varchar(255),
+    "error_message" varchar(1024),
+    "successful" boolean,
+    "mx" varchar(255),
+    "header_from" varchar(255),
+    "display_name" varchar(255),
+    "reply_to" varchar(255),
+    "envelope" varchar(255),
+    "envelope_spf" varchar(255),
+    "alignment" varchar(255),
+    "tls_policy" varchar(255),
+    "tls_offered" boolean,
+    "tls_used" boolean,
+    "tls_version" varchar(255),
+    "tls_cipher_suite" varchar(255),
+    "tls_certificate_valid" boolean,
+    "tls_name_match" boolean,
+    "tls_certificate_error" varchar(1024),
+    "tls_legacy_protocols" varchar(255),
+    "tls_dane" varchar(255),
+    "run_id" integer,
+    "scenario" varchar(255));
+INSERT INTO "messages" ("id", "domain_hash", "message_id", "created_at", "updated_at", "deleted_at", "spf", "dkim", "dmarc", "mail_server", "error_message", "successful", "mx", "header_from", "display_name", "reply_to", "envelope", "envelope_spf", "alignment", "tls_policy", "tls_offered", "tls_used", "tls_version", "tls_cipher_suite", "tls_certificate_valid", "tls_name_match", "tls_certificate_error", "tls_legacy_protocols", "tls_dane", "run_id", "scenario")
+    SELECT "id", "domain_hash", "message_id", "created_at", "updated_at", "deleted_at", "spf", "dkim", "dmarc", "mail_server", "error_message", "successful", "mx", "header_from", "display_name", "reply_to", "envelope", "envelope_spf", "alignment", "tls_policy", "tls_offered", "tls_used", "tls_version", "tls_cipher_suite", "tls_certificate_valid", "tls_name_match", "tls_certificate_error", "tls_legacy_protocols", "tls_dane", "run_id", "scenario" FROM "messages_old";
+DROP TABLE "messages_old";
+DROP TABLE "posture_events";
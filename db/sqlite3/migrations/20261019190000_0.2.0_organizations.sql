@@ -0,0 +1,158 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS "organizations" (
+    "id" integer primary key autoincrement,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "name" varchar(255) NOT NULL);
+
+-- Everything created before organizations existed belongs to the default
+-- organization
+INSERT INTO "organizations" ("id", "created_at", "updated_at", "name")
+    VALUES (1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, 'Default');
+
+ALTER TABLE "messages" ADD COLUMN "org_id" integer NOT NULL DEFAULT 1;
+ALTER TABLE "schedules" ADD COLUMN "org_id" integer NOT NULL DEFAULT 1;
+ALTER TABLE "runs" ADD COLUMN "org_id" integer NOT NULL DEFAULT 1;
+ALTER TABLE "posture_events" ADD COLUMN "org_id" integer NOT NULL DEFAULT 1;
+ALTER TABLE "webhooks" ADD COLUMN "org_id" integer NOT NULL DEFAULT 1;
+ALTER TABLE "api_keys" ADD COLUMN "org_id" integer NOT NULL DEFAULT 1;
+
+-- Domains are unique per organization rather than globally, so the table is
+-- rebuilt to change the constraint
+CREATE TABLE "domains_new" (
+    "id" integer primary key autoincrement,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "org_id" integer NOT NULL DEFAULT 1,
+    "domain_hash" varchar(255) NOT NULL,
+    "verification_token" varchar(255) NOT NULL,
+    "verified" boolean,
+    "verified_at" datetime,
+    UNIQUE ("org_id", "domain_hash"));
+INSERT INTO "domains_new" ("id", "created_at", "updated_at", "domain_hash", "verification_token", "verified", "verified_at")
+    SELECT "id", "created_at", "updated_at", "domain_hash", "verification_token", "verified", "verified_at" FROM "domains";
+DROP TABLE "domains";
+ALTER TABLE "domains_new" RENAME TO "domains";
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+-- SQLite can't drop columns, so the tables are rebuilt without the org_id
+-- column. This also restores the global uniqueness of domains.
+ALTER TABLE "domains" RENAME TO "domains_old";
+CREATE TABLE "domains" (
+    "id" integer primary key autoincrement,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "domain_hash" varchar(255) NOT NULL UNIQUE,
+    "verification_token" varchar(255) NOT NULL,
+    "verified" boolean,
+    "verified_at" datetime);
+INSERT INTO "domains" ("id", "created_at", "updated_at", "domain_hash", "verification_token", "verified", "verified_at")
+    SELECT "id", "created_at", "updated_at", "domain_hash", "verification_token", "verified", "verified_at" FROM "domains_old";
+DROP TABLE "domains_old";
+ALTER TABLE "api_keys" RENAME TO "api_keys_old";
+CREATE TABLE "api_keys" (
+    "id" integer primary key autoincrement,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "last_used_at" datetime,
+    "name" varchar(255),
+    "hint" varchar(255),
+    "key_hash" varchar(255) NOT NULL UNIQUE,
+    "scopes" varchar(255),
+    "domains" text);
+INSERT INTO "api_keys" ("id", "created_at", "updated_at", "last_used_at", "name", "hint", "key_hash", "scopes", "domains")
+    SELECT "id", "created_at", "updated_at", "last_used_at", "name", "hint", "key_hash", "scopes", "domains" FROM "api_keys_old";
+DROP TABLE "api_keys_old";
+ALTER TABLE "webhooks" RENAME TO "webhooks_old";
+CREATE TABLE "webhooks" (
+    "id" integer primary key autoincrement,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "domain_hash" varchar(255),
+    "url" varchar(1024) NOT NULL,
+    "secret" varchar(255) NOT NULL);
+INSERT INTO "webhooks" ("id", "created_at", "updated_at", "domain_hash", "url", "secret")
+    SELECT "id", "created_at", "updated_at", "domain_hash", "url", "secret" FROM "webhooks_old";
+DROP TABLE "webhooks_old";
+ALTER TABLE "posture_events" RENAME TO "posture_events_old";
+CREATE TABLE "posture_events" (
+    "id" integer primary key autoincrement,
+    "created_at" datetime,
+    "domain_hash" varchar(255) NOT NULL,
+    "scenario" varchar(255) NOT NULL,
+    "type" varchar(255) NOT NULL,
+    "message_id" varchar(255) NOT NULL,
+    "outcome" varchar(255),
+    "previous_message_id" varchar(255),
+    "previous_outcome" varchar(255));
+INSERT INTO "posture_events" ("id", "created_at", "domain_hash", "scenario", "type", "message_id", "outcome", "previous_message_id", "previous_outcome")
+    SELECT "id", "created_at", "domain_hash", "scenario", "type", "message_id", "outcome", "previous_message_id", "previous_outcome" FROM "posture_events_old";
+DROP TABLE "posture_events_old";
+ALTER TABLE "runs" RENAME TO "runs_old";
+CREATE TABLE "runs" (
+    "id" integer primary key autoincrement,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "domain_hash" varchar(255) NOT NULL,
+    "schedule_id" integer);
+INSERT INTO "runs" ("id", "created_at", "updated_at", "domain_hash", "schedule_id")
+    SELECT "id", "created_at", "updated_at", "domain_hash", "schedule_id" FROM "runs_old";
+DROP TABLE "runs_old";
+ALTER TABLE "schedules" RENAME TO "schedules_old";
+CREATE TABLE "schedules" (
+    "id" integer primary key autoincrement,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "domain_hash" varchar(255) NOT NULL,
+    "recipient" varchar(255) NOT NULL,
+    "mail_server" varchar(255) NOT NULL,
+    "scenarios" varchar(1024) NOT NULL,
+    "cron" varchar(255),
+    "interval" integer,
+    "paused" boolean,
+    "last_run_at" datetime,
+    "next_run_at" datetime);
+INSERT INTO "schedules" ("id", "created_at", "updated_at", "domain_hash", "recipient", "mail_server", "scenarios", "cron", "interval", "paused", "last_run_at", "next_run_at")
+    SELECT "id", "created_at", "updated_at", "domain_hash", "recipient", "mail_server", "scenarios", "cron", "interval", "paused", "last_run_at", "next_run_at" FROM "schedules_old";
+DROP TABLE "schedules_old";
+ALTER TABLE "messages" RENAME TO "messages_old";
+CREATE TABLE "messages" (
+    "id" integer primary key autoincrement,
+    "domain_hash" varchar(255) NOT NULL,
+    "message_id" varchar(255) NOT NULL,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "deleted_at" datetime,
+    "spf" varchar(255),
+    "dkim" varchar(255),
+    "dmarc" varchar(255),
+    "mail_server" varchar(255),
+    "error_message" varchar(1024),
+    "successful" boolean,
+    "mx" varchar(255),
+    "header_from" varchar(255),
+    "display_name" varchar(255),
+    "reply_to" varchar(255),
+    "envelope" varchar(255),
+    "envelope_spf" varchar(255),
+    "alignment" varchar(255),
+    "tls_policy" varchar(255),
+    "tls_offered" boolean,
+    "tls_used" boolean,
+    "tls_version" varchar(255),
+    "tls_cipher_suite" varchar(255),
+    "tls_certificate_valid" boolean,
+    "tls_name_match" boolean,
+    "tls_certificate_error" varchar(1024),
+    "tls_legacy_protocols" varchar(255),
+    "tls_dane" varchar(255),
+    "run_id" integer,
+    "scenario" varchar(255),
+    "status" varchar(255),
+    "tls_mta_sts" varchar(255));
+INSERT INTO "messages" ("id", "domain_hash", "message_id", "created_at", "updated_at", "deleted_at", "spf", "dkim", "dmarc", "mail_server", "error_message", "successful", "mx", "header_from", "display_name", "reply_to", "envelope", "envelope_spf", "alignment", "tls_policy", "tls_offered", "tls_used", "tls_version", "tls_cipher_suite", "tls_certificate_valid", "tls_name_match", "tls_certificate_error", "tls_legacy_protocols", "tls_dane", "run_id", "scenario", "status", "tls_mta_sts")
+    SELECT "id", "domain_hash", "message_id", "created_at", "updated_at", "deleted_at", "spf", "dkim", "dmarc", "mail_server", "error_message", "successful", "mx", "header_from", "display_name", "reply_to", "envelope", "envelope_spf", "alignment", "tls_policy", "tls_offered", "tls_used", "tls_version", "tls_cipher_suite", "tls_certificate_valid", "tls_name_match", "tls_certificate_error", "tls_legacy_protocols", "tls_dane", "run_id", "scenario", "status", "tls_mta_sts" FROM "messages_old";
+DROP TABLE "messages_old";
+DROP TABLE "organizations";
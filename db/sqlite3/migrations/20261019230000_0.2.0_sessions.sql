@@ -0,0 +1,12 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE IF NOT EXISTS "sessions" (
+    "id" integer primary key autoincrement,
+    "created_at" datetime,
+    "expires_at" datetime,
+    "api_key_id" integer NOT NULL,
+    "id_hash" varchar(255) NOT NULL UNIQUE);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE "sessions";
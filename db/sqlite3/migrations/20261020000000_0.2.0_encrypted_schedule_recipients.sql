@@ -0,0 +1,46 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+-- SQLite can't change columns, so the table is rebuilt with a nullable
+-- recipient. Existing recipients are encrypted by the application.
+ALTER TABLE "schedules" RENAME TO "schedules_old";
+CREATE TABLE "schedules" (
+    "id" integer primary key autoincrement,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "org_id" integer NOT NULL DEFAULT 1,
+    "domain_hash" varchar(255) NOT NULL,
+    "recipient" varchar(255),
+    "encrypted_recipient" varchar(1024),
+    "mail_server" varchar(255) NOT NULL,
+    "scenarios" varchar(1024) NOT NULL,
+    "cron" varchar(255),
+    "interval" integer,
+    "paused" boolean,
+    "last_run_at" datetime,
+    "next_run_at" datetime);
+INSERT INTO "schedules" ("id", "created_at", "updated_at", "org_id", "domain_hash", "recipient", "mail_server", "scenarios", "cron", "interval", "paused", "last_run_at", "next_run_at")
+    SELECT "id", "created_at", "updated_at", "org_id", "domain_hash", "recipient", "mail_server", "scenarios", "cron", "interval", "paused", "last_run_at", "next_run_at" FROM "schedules_old";
+DROP TABLE "schedules_old";
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+-- Encrypted recipients can't be decrypted here, so schedules saved since
+-- the upgrade are left without a recipient.
+ALTER TABLE "schedules" RENAME TO "schedules_old";
+CREATE TABLE "schedules" (
+    "id" integer primary key autoincrement,
+    "created_at" datetime,
+    "updated_at" datetime,
+    "domain_hash" varchar(255) NOT NULL,
+    "recipient" varchar(255) NOT NULL,
+    "mail_server" varchar(255) NOT NULL,
+    "scenarios" varchar(1024) NOT NULL,
+    "cron" varchar(255),
+    "interval" integer,
+    "paused" boolean,
+    "last_run_at" datetime,
+    "next_run_at" datetime,
+    "org_id" integer NOT NULL DEFAULT 1);
+INSERT INTO "schedules" ("id", "created_at", "updated_at", "domain_hash", "recipient", "mail_server", "scenarios", "cron", "interval", "paused", "last_run_at", "next_run_at", "org_id")
+    SELECT "id", "created_at", "updated_at", "domain_hash", coalesce("recipient", ''), "mail_server", "scenarios", "cron", "interval", "paused", "last_run_at", "next_run_at", "org_id" FROM "schedules_old";
+DROP TABLE "schedules_old";
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrNotFound is returned by the stores when the requested record doesn't
+// exist.
+var ErrNotFound = errors.New("record not found")
+
+// notFound returns ErrNotFound if err is gorm's error for a missing record,
+// so that callers don't depend on the store being backed by gorm.
+func notFound(err error) error {
+	if gorm.IsRecordNotFoundError(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Store is the storage used by the DNS plugin, the API and the background
+// workers. Every component is given the store it should use, so that they
+// can be tested with their own store and several databases can be used in
+// the same process.
+type Store interface {
+	GetMessage(id string) (*Message, error)
+	GetMessages(f MessageFilter) ([]Message, error)
+	PostMessage(m *Message) error
+	PutMessage(m *Message) error
+	GetPreviousMessage(m *Message) (*Message, error)
+	DeleteMessagesBefore(t time.Time) (int64, error)
+	PurgePersonalDataBefore(t time.Time) error
+
+	GetDNSQueries(messageID string) ([]DNSQuery, error)
+	GetDNSQueriesSince(messageIDs []string, since uint) ([]DNSQuery, error)
+	PostDNSQuery(m *Message, q *DNSQuery) error
+
+	GetRun(id, orgID uint) (*Run, error)
+	GetRuns(orgID uint, hash string) ([]Run, error)
+	PostRun(r *Run) error
+
+	GetDomain(orgID uint, hash string) (*Domain, error)
+	GetDomains(orgID uint) ([]Domain, error)
+	PostDomain(d *Domain) error
+	PutDomain(d *Domain) error
+	DeleteDomainData(orgID uint, hash string) error
+
+	GetPostureEvents(orgID uint, hash string, since uint) ([]PostureEvent, error)
+	PostPostureEvent(e *PostureEvent) error
+
+	GetSchedules(orgID uint, hash string) ([]Schedule, error)
+	GetDueSchedules(t time.Time) ([]Schedule, error)
+	PostSchedule(s *Schedule) error
+	PutSchedule(s *Schedule) error
+	DeleteSchedule(id, orgID uint, hash string) error
+
+	GetWebhooks(orgID uint, hash string) ([]Webhook, error)
+	GetEventWebhooks(orgID uint, hash string) ([]Webhook, error)
+	GetWebhook(id, orgID uint, hash string) (*Webhook, error)
+	PostWebhook(w *Webhook) error
+	DeleteWebhook(id, orgID uint, hash string) error
+	GetWebhookDeliveries(id uint, limit int) ([]WebhookDelivery, error)
+	PostWebhookDelivery(d *WebhookDelivery) error
+
+	GetAPIKeys(orgID uint) ([]APIKey, error)
+	GetAPIKeyByKey(key string) (*APIKey, error)
+	PostAPIKey(k *APIKey) (string, error)
+	DeleteAPIKey(id, orgID uint) error
+
+	PostSession(k *APIKey) (string, error)
+	GetSessionAPIKey(id string) (*APIKey, error)
+	DeleteSession(id string) error
+	DeleteSessionsBefore(t time.Time) error
+
+	GetOrganizations() ([]Organization, error)
+	GetOrganization(id uint) (*Organization, error)
+	PostOrganization(o *Organization) error
+}
+
+// GormStore is the Store backed by the database opened by Setup.
+type GormStore struct {
+	db *gorm.DB
+}
+
+// Close closes the database.
+func (s *GormStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,107 @@
+package db
+
+import "testing"
+
+// testStore checks the behavior shared by every Store implementation.
+func testStore(t *testing.T, s Store) {
+	_, err := s.GetMessage("missing")
+	if err != ErrNotFound {
+		t.Fatalf("Unexpected error for missing message. Got %v Expected %v", err, ErrNotFound)
+	}
+
+	run := &Run{OrgID: DefaultOrganizationID, DomainHash: "hash"}
+	err = s.PostRun(run)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating run: %v", err)
+	}
+	m := createMessage()
+	m.OrgID = DefaultOrganizationID
+	m.DomainHash = "hash"
+	m.RunID = run.ID
+	err = s.PostMessage(m)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating message: %v", err)
+	}
+	got, err := s.GetMessage(m.MessageID)
+	if err != nil || got.ID != m.ID || got.Status != StatusQueued {
+		t.Fatalf("Unexpected message returned: %#v, %v", got, err)
+	}
+	// Recipients are only kept when they are configured to be stored
+	if got.Recipient != "" {
+		t.Fatalf("Unexpected recipient stored. Expected \"\" Got %s", got.Recipient)
+	}
+	messages, err := s.GetMessages(MessageFilter{OrgID: DefaultOrganizationID, DomainHash: "hash"})
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("Unexpected messages returned: %#v, %v", messages, err)
+	}
+
+	gotRun, err := s.GetRun(run.ID, DefaultOrganizationID)
+	if err != nil || len(gotRun.Messages) != 1 {
+		t.Fatalf("Unexpected run returned: %#v, %v", gotRun, err)
+	}
+	_, err = s.GetRun(run.ID, DefaultOrganizationID+1)
+	if err != ErrNotFound {
+		t.Fatalf("Unexpected error for another organization's run. Got %v Expected %v", err, ErrNotFound)
+	}
+
+	q := &DNSQuery{Name: "example.com.", Type: "TXT", RemoteIP: "127.0.0.1"}
+	err = s.PostDNSQuery(m, q)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating DNS query: %v", err)
+	}
+	queries, err := s.GetDNSQueries(m.MessageID)
+	if err != nil || len(queries) != 1 || queries[0].MessageID != m.MessageID {
+		t.Fatalf("Unexpected DNS queries returned: %#v, %v", queries, err)
+	}
+	queries, err = s.GetDNSQueriesSince([]string{m.MessageID}, q.ID)
+	if err != nil || len(queries) != 0 {
+		t.Fatalf("Unexpected DNS queries returned: %#v, %v", queries, err)
+	}
+
+	d := &Domain{OrgID: DefaultOrganizationID, DomainHash: "hash"}
+	err = s.PostDomain(d)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating domain: %v", err)
+	}
+	if d.VerificationToken == "" {
+		t.Fatalf("No verification token generated for domain")
+	}
+	domains, err := s.GetDomains(DefaultOrganizationID)
+	if err != nil || len(domains) != 1 || domains[0].ID != d.ID {
+		t.Fatalf("Unexpected domains returned: %#v, %v", domains, err)
+	}
+
+	k := &APIKey{OrgID: DefaultOrganizationID, ScopeNames: []string{ScopeRead}}
+	key, err := s.PostAPIKey(k)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating API key: %v", err)
+	}
+	gotKey, err := s.GetAPIKeyByKey(key)
+	if err != nil || gotKey.ID != k.ID || !gotKey.HasScope(ScopeRead) {
+		t.Fatalf("Unexpected API key returned: %#v, %v", gotKey, err)
+	}
+	session, err := s.PostSession(gotKey)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating session: %v", err)
+	}
+	gotKey, err = s.GetSessionAPIKey(session)
+	if err != nil || gotKey.ID != k.ID {
+		t.Fatalf("Unexpected session API key returned: %#v, %v", gotKey, err)
+	}
+	err = s.DeleteAPIKey(k.ID, DefaultOrganizationID)
+	if err != nil {
+		t.Fatalf("Unexpected error when deleting API key: %v", err)
+	}
+	_, err = s.GetAPIKeyByKey(key)
+	if err == nil {
+		t.Fatalf("Revoked API key was returned")
+	}
+}
+
+func TestGormStore(t *testing.T) {
+	testStore(t, setupConfig(t))
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gophish/healthcheck/util"
+)
+
+// WebhookSecretLength is the number of bytes used when generating webhook
+// secrets.
+const WebhookSecretLength = 32
+
+// ErrInvalidWebhookURL occurs when a webhook doesn't specify a valid HTTP(S)
+// URL.
+var ErrInvalidWebhookURL = errors.New("invalid webhook URL")
+
+// ErrForbiddenWebhookAddress occurs when a webhook points to a loopback,
+// link-local or private address, which would let API users reach internal
+// services.
+var ErrForbiddenWebhookAddress = errors.New("webhooks can't be sent to internal addresses")
+
+// Webhook is a URL which receives signed message lifecycle events. Webhooks
+// without a domain hash receive events for every domain in the organization.
+// The secret is only returned when the webhook is created.
+type Webhook struct {
+	ID         uint      `gorm:"primary_key" json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	OrgID      uint      `json:"org_id"`
+	DomainHash string    `json:"domain_hash"`
+	URL        string    `json:"url"`
+	Secret     string    `json:"-"`
+}
+
+// WebhookDelivery is a single attempt at delivering an event to a webhook.
+type WebhookDelivery struct {
+	ID         uint      `gorm:"primary_key" json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	WebhookID  uint      `json:"webhook_id"`
+	Event      string    `json:"event"`
+	MessageID  string    `json:"message_id"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Validate ensures the webhook has a valid URL, which doesn't point to an
+// internal address. Host names are checked again once they're resolved, when
+// the webhook is delivered.
+func (w *Webhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return ErrInvalidWebhookURL
+	}
+	// IPv6 literals may include a zone, which isn't part of the address
+	host := strings.SplitN(u.Hostname(), "%", 2)[0]
+	if ip := net.ParseIP(host); (ip != nil && !util.IsPublicIP(ip)) || host == "localhost" {
+		return ErrForbiddenWebhookAddress
+	}
+	return nil
+}
+
+// prepare validates a new webhook and generates its secret if none was
+// provided
+func (w *Webhook) prepare() error {
+	err := w.Validate()
+	if err != nil {
+		return err
+	}
+	if w.Secret == "" {
+		w.Secret = util.GenerateSecureID(WebhookSecretLength)
+	}
+	return nil
+}
+
+// GetWebhooks returns the organization's webhooks registered for the
+// provided domain. An empty domain hash returns the organization-wide
+// webhooks.
+func (s *GormStore) GetWebhooks(orgID uint, hash string) ([]Webhook, error) {
+	webhooks := []Webhook{}
+	err := s.db.Where("org_id=? and domain_hash=?", orgID, hash).Find(&webhooks).Error
+	return webhooks, err
+}
+
+// GetEventWebhooks returns every webhook which should receive events for the
+// organization's domain, including the organization-wide webhooks.
+func (s *GormStore) GetEventWebhooks(orgID uint, hash string) ([]Webhook, error) {
+	webhooks := []Webhook{}
+	err := s.db.Where("org_id=? and (domain_hash=? or domain_hash=?)", orgID, hash, "").Find(&webhooks).Error
+	return webhooks, err
+}
+
+// GetWebhook returns the organization's webhook with the provided ID for a
+// domain
+func (s *GormStore) GetWebhook(id, orgID uint, hash string) (*Webhook, error) {
+	webhook := &Webhook{}
+	err := s.db.Where("id=? and org_id=? and domain_hash=?", id, orgID, hash).First(webhook).Error
+	return webhook, notFound(err)
+}
+
+// PostWebhook validates and saves a new webhook into the database. A secret
+// is generated if none was provided.
+func (s *GormStore) PostWebhook(w *Webhook) error {
+	err := w.prepare()
+	if err != nil {
+		return err
+	}
+	return s.db.Save(w).Error
+}
+
+// DeleteWebhook deletes the organization's webhook with the provided ID for a
+// domain
+func (s *GormStore) DeleteWebhook(id, orgID uint, hash string) error {
+	return s.db.Where("id=? and org_id=? and domain_hash=?", id, orgID, hash).Delete(&Webhook{}).Error
+}
+
+// GetWebhookDeliveries returns the most recent delivery attempts for a
+// webhook
+func (s *GormStore) GetWebhookDeliveries(id uint, limit int) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	err := s.db.Where("webhook_id=?", id).Order("id desc").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+// PostWebhookDelivery saves a delivery attempt into the database
+func (s *GormStore) PostWebhookDelivery(d *WebhookDelivery) error {
+	return s.db.Save(d).Error
+}
@@ -0,0 +1,27 @@
+package db
+
+import "testing"
+
+func TestWebhookValidate(t *testing.T) {
+	testSuite := map[string]error{
+		"https://example.com/hook":    nil,
+		"http://93.184.216.34:8080/":  nil,
+		"ftp://example.com/hook":      ErrInvalidWebhookURL,
+		"https:///hook":               ErrInvalidWebhookURL,
+		"http://localhost:3333/":      ErrForbiddenWebhookAddress,
+		"http://127.0.0.1/":           ErrForbiddenWebhookAddress,
+		"http://[::1]/":               ErrForbiddenWebhookAddress,
+		"http://169.254.169.254/":     ErrForbiddenWebhookAddress,
+		"http://10.0.0.1/":            ErrForbiddenWebhookAddress,
+		"http://192.168.1.1/":         ErrForbiddenWebhookAddress,
+		"http://0.0.0.0/":             ErrForbiddenWebhookAddress,
+		"http://[fe80::1%25eth0]:80/": ErrForbiddenWebhookAddress,
+	}
+	for u, expected := range testSuite {
+		w := &Webhook{URL: u}
+		got := w.Validate()
+		if got != expected {
+			t.Fatalf("Unexpected error validating %s. Expected %v Got %v", u, expected, got)
+		}
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/fall"
 	"github.com/coredns/coredns/request"
+	log "github.com/gophish/gophish/logger"
 	"github.com/gophish/healthcheck/config"
 	"github.com/gophish/healthcheck/db"
 	"github.com/miekg/dns"
@@ -19,7 +22,19 @@ const HealthCheckPluginName = "healthcheck"
 // HealthCheckPlugin is a CoreDNS plugin that emulate various email
 // authentication states.
 type HealthCheckPlugin struct {
-	Next plugin.Handler
+	Next  plugin.Handler
+	Store db.Store
+
+	// Zones are the zones the plugin is authoritative for
+	Zones []string
+	// TTL is the TTL of the records we serve, unless the message requests
+	// a long or zero TTL
+	TTL uint32
+	// LogQueries records every query for a message in the DNS query log
+	LogQueries bool
+	// Fall sets the names passed to the next plugin when we have no
+	// record for them, instead of answering NXDOMAIN
+	Fall fall.F
 }
 
 // Name implements the Handler interface.
@@ -27,9 +42,66 @@ func (hc HealthCheckPlugin) Name() string {
 	return HealthCheckPluginName
 }
 
+// parseName returns the message ID referenced by the queried name, along with
+// whether the name is an envelope sender domain rather than the header From
+// domain.
+func (hc HealthCheckPlugin) parseName(name string) (string, bool) {
+	parts := strings.Split(name, ".")
+	if parts[0] == config.BouncePrefix && len(parts) > 1 {
+		return parts[1], true
+	}
+	if config.Config.EnvelopeHostname != "" && strings.HasSuffix(name, "."+strings.ToLower(dns.Fqdn(config.Config.EnvelopeHostname))) {
+		return parts[0], true
+	}
+	return parts[0], false
+}
+
+// messageID returns the message ID referenced by the queried name, whichever
+// record is requested. DMARC and DKIM records may be requested for the
+// envelope sender domain as well (e.g. _dmarc.bounce.<id>...), so the prefix
+// is removed before parsing the rest of the name.
+func (hc HealthCheckPlugin) messageID(name string) string {
+	parts := strings.SplitN(name, ".", 2)
+	switch parts[0] {
+	case config.DMARCPrefix, config.DKIMPrefix:
+		if len(parts) > 1 {
+			name = parts[1]
+		}
+	}
+	messageID, _ := hc.parseName(name)
+	return messageID
+}
+
+// longTTLName returns the name served with the long TTL, which is included
+// by the SPF record of messages testing long TTLs.
+func longTTLName() string {
+	return strings.ToLower(dns.Fqdn(config.LongTTLPrefix + "." + config.Config.EmailHostname))
+}
+
+// generateSPFTemplate returns the SPF record served for the header From
+// domain. Messages testing long TTLs include the record served with the long
+// TTL, whose name stays the same across runs, since their own records are
+// never looked up again.
 func (hc HealthCheckPlugin) generateSPFTemplate(message *db.Message) string {
+	if message.MessageConfiguration.TTL == db.TTLLong && message.MessageConfiguration.SPF == db.Pass {
+		return fmt.Sprintf("v=spf1 include:%s -all", strings.TrimSuffix(longTTLName(), "."))
+	}
+	return hc.generateSPFRecord(message.MessageConfiguration.SPF)
+}
+
+// generateEnvelopeSPFTemplate returns the SPF record served for the envelope
+// sender domain. If no envelope SPF policy is set, the header From policy is
+// used.
+func (hc HealthCheckPlugin) generateEnvelopeSPFTemplate(message *db.Message) string {
+	if message.MessageConfiguration.EnvelopeSPF == "" {
+		return hc.generateSPFTemplate(message)
+	}
+	return hc.generateSPFRecord(message.MessageConfiguration.EnvelopeSPF)
+}
+
+func (hc HealthCheckPlugin) generateSPFRecord(policy string) string {
 	response := "v=spf1 "
-	switch message.MessageConfiguration.SPF {
+	switch policy {
 	case db.Pass:
 		response += fmt.Sprintf("%s -all", config.Config.EmailHostname)
 		// Return a valid SPF record
@@ -64,26 +136,82 @@ func (hc HealthCheckPlugin) generateDMARCTemplate(message *db.Message) string {
 	switch message.MessageConfiguration.DMARC {
 	case db.Neutral:
 		// Return the DMARC policy set to none
-		response += " p=none; sp=none; adkim=r; aspf=r;"
+		response += " p=none; sp=none;"
 	case db.Quarantine:
 		// Return the DMARC policy set to quarantine
-		response += " p=quarantine; sp=quarantine; adkim=r; aspf=r;"
+		response += " p=quarantine; sp=quarantine;"
 	case db.Reject:
 		// Return the DMARC policy set to reject
-		response += " p=reject; sp=reject; adkim=r; aspf=r;"
+		response += " p=reject; sp=reject;"
 	}
-	response += " pct=100;"
+	// Alignment is relaxed unless strict alignment is requested, which lets
+	// us test subdomain envelope senders.
+	alignment := "r"
+	if message.MessageConfiguration.Alignment == db.StrictAlignment {
+		alignment = "s"
+	}
+	response += fmt.Sprintf(" adkim=%s; aspf=%s; pct=100;", alignment, alignment)
 	return response
 }
 
+// getMessage returns the message with the provided ID, unless it's older
+// than the DNS retention period.
+func (hc HealthCheckPlugin) getMessage(id string) (*db.Message, error) {
+	message, err := hc.Store.GetMessage(id)
+	if err != nil {
+		return message, err
+	}
+	if message.DNSExpired(time.Now()) {
+		return message, db.ErrMessageExpired
+	}
+	return message, nil
+}
+
+// ttl returns the TTL of the records served for the message. Messages
+// testing long TTLs use the long TTL for their own records as well as for the
+// record included by their SPF record.
+func (hc HealthCheckPlugin) ttl(message *db.Message) uint32 {
+	switch message.MessageConfiguration.TTL {
+	case db.TTLZero:
+		return 0
+	case db.TTLLong:
+		return config.Config.DNSLongTTL
+	}
+	return hc.TTL
+}
+
+// logQuery records the query for the message in the DNS query log. Queries
+// for the record shared by messages testing long TTLs are recorded without a
+// message, since the record is the same for every message.
+func (hc HealthCheckPlugin) logQuery(state request.Request, message *db.Message) {
+	if !hc.LogQueries {
+		return
+	}
+	ttl := config.Config.DNSLongTTL
+	if message != nil {
+		ttl = hc.ttl(message)
+	}
+	q := &db.DNSQuery{
+		Name:     state.Name(),
+		Type:     state.Type(),
+		RemoteIP: state.IP(),
+		TTL:      ttl,
+	}
+	err := hc.Store.PostDNSQuery(message, q)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
 func (hc HealthCheckPlugin) processDMARCRecord(state request.Request, messageID string) ([]dns.RR, error) {
 	rrs := []dns.RR{}
-	message, err := db.GetMessage(messageID)
+	message, err := hc.getMessage(messageID)
 	if err != nil {
 		return rrs, err
 	}
+	hc.logQuery(state, message)
 	rr := new(dns.TXT)
-	rr.Hdr = dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeTXT, Class: state.QClass()}
+	rr.Hdr = dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeTXT, Class: state.QClass(), Ttl: hc.ttl(message)}
 	rr.Txt = []string{hc.generateDMARCTemplate(message)}
 	rrs = append(rrs, rr)
 	return rrs, nil
@@ -91,12 +219,13 @@ func (hc HealthCheckPlugin) processDMARCRecord(state request.Request, messageID
 
 func (hc HealthCheckPlugin) processDKIMRecord(state request.Request, messageID string) ([]dns.RR, error) {
 	rrs := []dns.RR{}
-	message, err := db.GetMessage(messageID)
+	message, err := hc.getMessage(messageID)
 	if err != nil {
 		return rrs, err
 	}
+	hc.logQuery(state, message)
 	rr := new(dns.TXT)
-	rr.Hdr = dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeTXT, Class: state.QClass()}
+	rr.Hdr = dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeTXT, Class: state.QClass(), Ttl: hc.ttl(message)}
 	rr.Txt = []string{hc.generateDKIMTemplate(message)}
 	rrs = append(rrs, rr)
 	return rrs, nil
@@ -104,52 +233,71 @@ func (hc HealthCheckPlugin) processDKIMRecord(state request.Request, messageID s
 
 func (hc HealthCheckPlugin) processSPFRecord(state request.Request) ([]dns.RR, error) {
 	rrs := []dns.RR{}
-	messageID := strings.Split(state.QName(), ".")[0]
-	message, err := db.GetMessage(messageID)
+	messageID, envelope := hc.parseName(state.Name())
+	message, err := hc.getMessage(messageID)
 	if err != nil {
 		return rrs, err
 	}
+	hc.logQuery(state, message)
 	rr := new(dns.SPF)
-	rr.Hdr = dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeSPF, Class: state.QClass()}
+	rr.Hdr = dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeSPF, Class: state.QClass(), Ttl: hc.ttl(message)}
 	rr.Txt = []string{hc.generateSPFTemplate(message)}
+	if envelope {
+		rr.Txt = []string{hc.generateEnvelopeSPFTemplate(message)}
+	}
 	rrs = append(rrs, rr)
 	return rrs, nil
 }
 
 func (hc HealthCheckPlugin) processTXTRecord(state request.Request) ([]dns.RR, error) {
 	rrs := []dns.RR{}
-	var messageID string
-	parts := strings.Split(state.QName(), ".")
+	parts := strings.Split(state.Name(), ".")
 	switch parts[0] {
 	case config.DMARCPrefix:
-		messageID = parts[1]
-		return hc.processDMARCRecord(state, messageID)
+		return hc.processDMARCRecord(state, hc.messageID(state.Name()))
 	case config.DKIMPrefix:
-		messageID = parts[1]
-		return hc.processDKIMRecord(state, messageID)
+		return hc.processDKIMRecord(state, hc.messageID(state.Name()))
 	}
-	messageID = parts[0]
-	message, err := db.GetMessage(messageID)
+	messageID, envelope := hc.parseName(state.Name())
+	message, err := hc.getMessage(messageID)
 	if err != nil {
 		return rrs, err
 	}
+	hc.logQuery(state, message)
 	// Process the SPF (as a TXT record) response
 	rr := new(dns.TXT)
-	rr.Hdr = dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeTXT, Class: state.QClass()}
+	rr.Hdr = dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeTXT, Class: state.QClass(), Ttl: hc.ttl(message)}
 	rr.Txt = []string{hc.generateSPFTemplate(message)}
+	if envelope {
+		rr.Txt = []string{hc.generateEnvelopeSPFTemplate(message)}
+	}
 	rrs = append(rrs, rr)
 	return rrs, nil
 }
 
+// processLongTTLRecord returns the SPF record included by messages testing
+// long TTLs. It's recorded in the query log without a message, since it isn't
+// specific to one.
+func (hc HealthCheckPlugin) processLongTTLRecord(state request.Request) []dns.RR {
+	hc.logQuery(state, nil)
+	hdr := dns.RR_Header{Name: state.QName(), Rrtype: state.QType(), Class: state.QClass(), Ttl: config.Config.DNSLongTTL}
+	txt := []string{hc.generateSPFRecord(db.Pass)}
+	if state.QType() == dns.TypeSPF {
+		return []dns.RR{&dns.SPF{Hdr: hdr, Txt: txt}}
+	}
+	return []dns.RR{&dns.TXT{Hdr: hdr, Txt: txt}}
+}
+
 func (hc HealthCheckPlugin) processMXRecord(state request.Request) ([]dns.RR, error) {
 	rrs := []dns.RR{}
-	messageID := strings.Split(state.QName(), ".")[0]
-	message, err := db.GetMessage(messageID)
+	messageID, _ := hc.parseName(state.Name())
+	message, err := hc.getMessage(messageID)
 	if err != nil {
 		return rrs, err
 	}
+	hc.logQuery(state, message)
 	rr := new(dns.MX)
-	rr.Hdr = dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeMX, Class: state.QClass()}
+	rr.Hdr = dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeMX, Class: state.QClass(), Ttl: hc.ttl(message)}
 	rr.Preference = 10
 	fmt.Println(message.MessageConfiguration)
 	switch message.MessageConfiguration.MX {
@@ -172,6 +320,14 @@ func (hc HealthCheckPlugin) ServeDNS(ctx context.Context, w dns.ResponseWriter,
 	if state.QType() != dns.TypeTXT && state.QType() != dns.TypeSPF && state.QType() != dns.TypeMX {
 		return plugin.NextOrFailure(hc.Name(), hc.Next, ctx, w, r)
 	}
+	// Names are matched case-insensitively since resolvers may randomize
+	// the case of the names they query. Only names for one of our messages
+	// are looked up, so that unrelated queries never reach the database.
+	qname := state.Name()
+	longTTL := qname == longTTLName() && state.QType() != dns.TypeMX
+	if plugin.Zones(hc.Zones).Matches(qname) == "" || !longTTL && !db.ValidMessageID(hc.messageID(qname)) {
+		return plugin.NextOrFailure(hc.Name(), hc.Next, ctx, w, r)
+	}
 
 	a := new(dns.Msg)
 	a.SetReply(r)
@@ -180,24 +336,27 @@ func (hc HealthCheckPlugin) ServeDNS(ctx context.Context, w dns.ResponseWriter,
 
 	var err error
 
-	switch state.QType() {
-	case dns.TypeTXT:
+	switch {
+	case longTTL:
+		a.Answer = hc.processLongTTLRecord(state)
+	case state.QType() == dns.TypeTXT:
 		a.Answer, err = hc.processTXTRecord(state)
-		if err != nil {
-			return plugin.NextOrFailure(hc.Name(), hc.Next, ctx, w, r)
-		}
-	case dns.TypeMX:
+	case state.QType() == dns.TypeMX:
 		a.Answer, err = hc.processMXRecord(state)
-		if err != nil {
-			return plugin.NextOrFailure(hc.Name(), hc.Next, ctx, w, r)
-		}
 	// This is really only supported for odd legacy issues. Per RFC 7208, SPF
 	// records must be TXT records
-	case dns.TypeSPF:
+	case state.QType() == dns.TypeSPF:
 		a.Answer, err = hc.processSPFRecord(state)
-		if err != nil {
+	}
+	switch err {
+	case nil:
+	case db.ErrNotFound, db.ErrMessageExpired:
+		if hc.Fall.Through(qname) {
 			return plugin.NextOrFailure(hc.Name(), hc.Next, ctx, w, r)
 		}
+		a.Rcode = dns.RcodeNameError
+	default:
+		return dns.RcodeServerFailure, plugin.Error(hc.Name(), err)
 	}
 
 	state.SizeAndDo(a)
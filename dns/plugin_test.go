@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strings"
 	"testing"
 
 	"github.com/coredns/coredns/request"
@@ -22,8 +23,7 @@ func (w *MockDNSResponseWriter) LocalAddr() net.Addr {
 }
 
 func (w *MockDNSResponseWriter) RemoteAddr() net.Addr {
-	panic("not implemented")
-	return &net.IPAddr{}
+	return &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 53}
 }
 
 func (w *MockDNSResponseWriter) WriteMsg(m *dns.Msg) error {
@@ -59,15 +59,25 @@ var acceptedTypes = map[uint16]bool{
 	dns.TypeSPF: true,
 }
 
+const testSecretKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
 func setupConfig(t *testing.T) {
+	config.Config.EmailHostname = "example.com"
+}
+
+// setupStore returns a store backed by an in-memory SQLite database, which is
+// closed when the test finishes.
+func setupStore(t *testing.T) db.Store {
 	config.Config.DBName = "sqlite3"
 	config.Config.DBPath = ":memory:"
 	config.Config.MigrationsPath = "../db/sqlite3/migrations/"
-	config.Config.EmailHostname = "example.com"
-	err := db.Setup()
+	config.Config.SecretKey = testSecretKey
+	store, err := db.Setup()
 	if err != nil {
 		t.Fatalf("Failed setting up the database: %s", err.Error())
 	}
+	t.Cleanup(func() { store.Close() })
+	return store
 }
 
 func createMessage() *db.Message {
@@ -131,20 +141,110 @@ func TestGenerateDMARCTemplate(t *testing.T) {
 	}
 }
 
+func TestGenerateStrictDMARCTemplate(t *testing.T) {
+	hc := HealthCheckPlugin{}
+	m := &db.Message{}
+	m.MessageConfiguration.DMARC = db.Reject
+	m.MessageConfiguration.Alignment = db.StrictAlignment
+	expected := "v=DMARC1; p=reject; sp=reject; adkim=s; aspf=s; pct=100;"
+	got := hc.generateDMARCTemplate(m)
+	if got != expected {
+		t.Fatalf("Unexpected strict DMARC response.\nGot %s\nExpected %s", got, expected)
+	}
+}
+
+func TestGenerateEnvelopeSPFTemplate(t *testing.T) {
+	hc := HealthCheckPlugin{}
+	m := &db.Message{}
+	m.MessageConfiguration.SPF = db.HardFail
+	got := hc.generateEnvelopeSPFTemplate(m)
+	if got != "v=spf1 -all" {
+		t.Fatalf("Unexpected envelope SPF fallback response. Got %s", got)
+	}
+	m.MessageConfiguration.EnvelopeSPF = db.SoftFail
+	got = hc.generateEnvelopeSPFTemplate(m)
+	if got != "v=spf1 ~all" {
+		t.Fatalf("Unexpected envelope SPF response. Got %s", got)
+	}
+}
+
+func TestParseName(t *testing.T) {
+	config.Config.EnvelopeHostname = "example.net"
+	testSuite := []struct {
+		name     string
+		id       string
+		envelope bool
+	}{
+		{"abc.example.com.", "abc", false},
+		{"bounce.abc.example.com.", "abc", true},
+		{"abc.example.net.", "abc", true},
+	}
+	hc := HealthCheckPlugin{}
+	for _, test := range testSuite {
+		id, envelope := hc.parseName(test.name)
+		if id != test.id || envelope != test.envelope {
+			t.Fatalf("Unexpected result parsing %s. Got (%s, %v) Expected (%s, %v)", test.name, id, envelope, test.id, test.envelope)
+		}
+	}
+}
+
+func TestMessageID(t *testing.T) {
+	config.Config.EnvelopeHostname = "example.net"
+	testSuite := map[string]string{
+		"abc.example.com.":               "abc",
+		"_dmarc.abc.example.com.":        "abc",
+		"_dmarc.bounce.abc.example.com.": "abc",
+		"_dkim.bounce.abc.example.com.":  "abc",
+		"_dmarc.abc.example.net.":        "abc",
+	}
+	hc := HealthCheckPlugin{}
+	for name, expected := range testSuite {
+		got := hc.messageID(name)
+		if got != expected {
+			t.Fatalf("Unexpected message ID for %s. Got %s Expected %s", name, got, expected)
+		}
+	}
+}
+
+func TestBounceDMARC(t *testing.T) {
+	setupConfig(t)
+	hc := HealthCheckPlugin{Store: setupStore(t), Zones: []string{"example.com."}}
+	message := createMessage()
+	message.MessageConfiguration.DMARC = db.Reject
+	err := hc.Store.PostMessage(message)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating message: %v", err)
+	}
+	w := &MockDNSResponseWriter{}
+	m := new(dns.Msg)
+	m.SetQuestion(fmt.Sprintf("_dmarc.bounce.%s.example.com.", message.MessageID), dns.TypeTXT)
+	_, err = hc.ServeDNS(context.Background(), w, m)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(w.msgs) != 1 || len(w.msgs[0].Answer) != 1 {
+		t.Fatalf("Expected a DMARC answer for the envelope domain, got %v", w.msgs)
+	}
+	txt := w.msgs[0].Answer[0].(*dns.TXT).Txt[0]
+	if !strings.Contains(txt, "p=reject") {
+		t.Fatalf("Unexpected DMARC record: %s", txt)
+	}
+}
+
 func TestProcessMX(t *testing.T) {
 	setupConfig(t)
 	testSuite := map[string]string{
 		db.HardFail: dns.Fqdn(fmt.Sprintf("invalid.%s", config.Config.EmailHostname)),
 		db.Pass:     dns.Fqdn(config.Config.EmailHostname),
 	}
-	hc := HealthCheckPlugin{}
+	hc := HealthCheckPlugin{Store: setupStore(t)}
 	r := new(dns.Msg)
 	w := &MockDNSResponseWriter{}
 	state := request.Request{W: w, Req: r}
 	for valid, expected := range testSuite {
 		m := createMessage()
 		m.MessageConfiguration.MX = valid
-		err := db.PostMessage(m)
+		err := hc.Store.PostMessage(m)
 		if err != nil {
 			t.Fatalf("Unexpected error when creating message: %v", err)
 		}
@@ -162,3 +262,168 @@ func TestProcessMX(t *testing.T) {
 		}
 	}
 }
+
+// unknownMessageID has the format of a message ID but no message
+const unknownMessageID = "0123456789abcdef0123456789abcdef"
+
+func TestUnknownMessage(t *testing.T) {
+	setupConfig(t)
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(fmt.Sprintf("%s.%s", unknownMessageID, config.Config.EmailHostname)), dns.TypeTXT)
+
+	w := &MockDNSResponseWriter{}
+	hc := HealthCheckPlugin{Store: setupStore(t), Zones: []string{"example.com."}}
+	_, err := hc.ServeDNS(context.Background(), w, m)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(w.msgs) != 1 || w.msgs[0].Rcode != dns.RcodeNameError {
+		t.Fatalf("Expected an NXDOMAIN response, got %v", w.msgs)
+	}
+
+	w = &MockDNSResponseWriter{}
+	hc.Fall.SetZonesFromArgs(nil)
+	response, _ := hc.ServeDNS(context.Background(), w, m)
+	if response != dns.RcodeServerFailure || len(w.msgs) != 0 {
+		t.Fatalf("Expected the query to fall through, got %d", response)
+	}
+}
+
+func TestIgnoredNames(t *testing.T) {
+	setupConfig(t)
+	hc := HealthCheckPlugin{Store: setupStore(t), Zones: []string{"example.com."}}
+	names := []string{
+		"www.google.com.",
+		fmt.Sprintf("%s.example.org.", unknownMessageID),
+		"www.example.com.",
+		"example.com.",
+		"_dmarc.www.example.com.",
+		fmt.Sprintf("%sff.example.com.", unknownMessageID),
+	}
+	for _, name := range names {
+		w := &MockDNSResponseWriter{}
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeTXT)
+		response, _ := hc.ServeDNS(context.Background(), w, m)
+		if response != dns.RcodeServerFailure || len(w.msgs) != 0 {
+			t.Fatalf("Expected %s to be passed to the next plugin, got %d", name, response)
+		}
+	}
+}
+
+func TestMixedCaseName(t *testing.T) {
+	setupConfig(t)
+	hc := HealthCheckPlugin{Store: setupStore(t), Zones: []string{"example.com."}}
+	message := createMessage()
+	message.MessageConfiguration.SPF = db.HardFail
+	err := hc.Store.PostMessage(message)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating message: %v", err)
+	}
+	name := strings.ToUpper(fmt.Sprintf("%s.Example.COM.", message.MessageID))
+	w := &MockDNSResponseWriter{}
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeTXT)
+	_, err = hc.ServeDNS(context.Background(), w, m)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(w.msgs) != 1 || len(w.msgs[0].Answer) != 1 {
+		t.Fatalf("Expected an answer for %s, got %v", name, w.msgs)
+	}
+	if w.msgs[0].Answer[0].Header().Name != name {
+		t.Fatalf("Unexpected answer name. Expected %s Got %s", name, w.msgs[0].Answer[0].Header().Name)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	setupConfig(t)
+	config.Config.DNSLongTTL = 86400
+	hc := HealthCheckPlugin{Store: setupStore(t), Zones: []string{"example.com."}, TTL: 300, LogQueries: true}
+	testSuite := map[string]uint32{
+		"":         300,
+		db.TTLLong: 86400,
+		db.TTLZero: 0,
+	}
+	for option, expected := range testSuite {
+		message := createMessage()
+		message.MessageConfiguration.SPF = db.Pass
+		message.MessageConfiguration.TTL = option
+		err := hc.Store.PostMessage(message)
+		if err != nil {
+			t.Fatalf("Unexpected error when creating message: %v", err)
+		}
+		w := &MockDNSResponseWriter{}
+		m := new(dns.Msg)
+		m.SetQuestion(fmt.Sprintf("%s.example.com.", message.MessageID), dns.TypeTXT)
+		_, err = hc.ServeDNS(context.Background(), w, m)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(w.msgs) != 1 || len(w.msgs[0].Answer) != 1 {
+			t.Fatalf("Expected an answer for the %q TTL, got %v", option, w.msgs)
+		}
+		got := w.msgs[0].Answer[0].Header().Ttl
+		if got != expected {
+			t.Fatalf("Unexpected TTL for %q. Expected %d Got %d", option, expected, got)
+		}
+		queries, err := hc.Store.GetDNSQueries(message.MessageID)
+		if err != nil {
+			t.Fatalf("Unexpected error getting the DNS queries: %v", err)
+		}
+		if len(queries) != 1 || queries[0].TTL != expected {
+			t.Fatalf("Expected the query log to record a TTL of %d, got %v", expected, queries)
+		}
+	}
+}
+
+func TestLongTTL(t *testing.T) {
+	setupConfig(t)
+	config.Config.DNSLongTTL = 86400
+	hc := HealthCheckPlugin{Store: setupStore(t), Zones: []string{"example.com."}, TTL: 300, LogQueries: true}
+	message := createMessage()
+	message.MessageConfiguration.SPF = db.Pass
+	message.MessageConfiguration.TTL = db.TTLLong
+	err := hc.Store.PostMessage(message)
+	if err != nil {
+		t.Fatalf("Unexpected error when creating message: %v", err)
+	}
+	expected := "v=spf1 include:_ttl.example.com -all"
+	got := hc.generateSPFTemplate(message)
+	if got != expected {
+		t.Fatalf("Unexpected SPF record. Expected %s Got %s", expected, got)
+	}
+
+	// The included name is the same for every message, and served with the
+	// long TTL
+	for _, qtype := range []uint16{dns.TypeTXT, dns.TypeSPF} {
+		w := &MockDNSResponseWriter{}
+		m := new(dns.Msg)
+		m.SetQuestion("_TTL.example.com.", qtype)
+		_, err = hc.ServeDNS(context.Background(), w, m)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(w.msgs) != 1 || len(w.msgs[0].Answer) != 1 {
+			t.Fatalf("Expected an answer for the long TTL name, got %v", w.msgs)
+		}
+		answer := w.msgs[0].Answer[0]
+		if answer.Header().Ttl != 86400 || answer.Header().Rrtype != qtype {
+			t.Fatalf("Unexpected answer for the long TTL name: %v", answer)
+		}
+	}
+
+	// The lookups are logged with the long TTL, without a message
+	queries, err := hc.Store.GetDNSQueries("")
+	if err != nil {
+		t.Fatalf("Unexpected error getting the DNS queries: %v", err)
+	}
+	if len(queries) != 2 {
+		t.Fatalf("Unexpected number of logged queries. Expected 2 Got %d", len(queries))
+	}
+	for _, q := range queries {
+		if q.Name != "_ttl.example.com." || q.TTL != 86400 {
+			t.Fatalf("Unexpected logged query for the long TTL name: %v", q)
+		}
+	}
+}
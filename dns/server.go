@@ -0,0 +1,97 @@
+package dns
+
+import (
+	"context"
+	"net"
+
+	"github.com/coredns/coredns/plugin"
+	log "github.com/gophish/gophish/logger"
+	"github.com/gophish/healthcheck/config"
+	"github.com/gophish/healthcheck/db"
+	"github.com/miekg/dns"
+)
+
+// Server serves the health check plugin without CoreDNS, so that it can run
+// in the same process as the API and share its database handle.
+type Server struct {
+	udp *dns.Server
+	tcp *dns.Server
+}
+
+// NewServer returns a server answering queries on the address over both UDP
+// and TCP, using the messages in the provided store.
+func NewServer(addr string, store db.Store) *Server {
+	hc := HealthCheckPlugin{
+		Store:      store,
+		Zones:      configZones(),
+		TTL:        config.Config.DNSTTL,
+		LogQueries: true,
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		serveDNS(hc, w, r)
+	})
+	return &Server{
+		udp: &dns.Server{Addr: addr, Net: "udp", Handler: handler},
+		tcp: &dns.Server{Addr: addr, Net: "tcp", Handler: handler},
+	}
+}
+
+// serveDNS answers a query using the health check plugin. There's no next
+// plugin, so queries the plugin doesn't answer get an error response, which
+// CoreDNS would otherwise write for us.
+func serveDNS(hc HealthCheckPlugin, w dns.ResponseWriter, r *dns.Msg) {
+	rcode, err := hc.ServeDNS(context.Background(), w, r)
+	if err != nil {
+		log.Error(err)
+	}
+	if !plugin.ClientWrite(rcode) {
+		m := new(dns.Msg)
+		m.SetRcode(r, rcode)
+		w.WriteMsg(m)
+	}
+}
+
+// ListenAndServe starts answering queries, blocking until the server is shut
+// down or fails to start.
+func (s *Server) ListenAndServe() error {
+	// Both listeners are opened before serving either protocol, so that an
+	// address already in use fails without leaving the other one running.
+	pc, err := net.ListenPacket("udp", s.udp.Addr)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", s.tcp.Addr)
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	s.udp.PacketConn = pc
+	s.tcp.Listener = l
+	errs := make(chan error, 2)
+	for _, srv := range []*dns.Server{s.udp, s.tcp} {
+		go func(srv *dns.Server) {
+			errs <- srv.ActivateAndServe()
+		}(srv)
+	}
+	// If either server fails, stop the other one so that we don't keep
+	// serving on a single protocol. The listeners are closed directly since
+	// Shutdown fails if the other server hasn't started yet.
+	err = <-errs
+	if err != nil {
+		pc.Close()
+		l.Close()
+		<-errs
+		return err
+	}
+	return <-errs
+}
+
+// Shutdown gracefully stops the server.
+func (s *Server) Shutdown(ctx context.Context) error {
+	udpErr := s.udp.ShutdownContext(ctx)
+	tcpErr := s.tcp.ShutdownContext(ctx)
+	if udpErr != nil {
+		return udpErr
+	}
+	return tcpErr
+}
@@ -0,0 +1,76 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestServeDNSWritesFailure(t *testing.T) {
+	w := &MockDNSResponseWriter{}
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	serveDNS(HealthCheckPlugin{Store: setupStore(t)}, w, m)
+	if len(w.msgs) != 1 {
+		t.Fatalf("Unexpected number of responses written. Expected 1 Got %d", len(w.msgs))
+	}
+	if w.msgs[0].Rcode != dns.RcodeServerFailure {
+		t.Fatalf("Unexpected response code. Expected %d Got %d", dns.RcodeServerFailure, w.msgs[0].Rcode)
+	}
+}
+
+func TestListenAndServeAddressInUse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error listening: %v", err)
+	}
+	defer l.Close()
+	s := NewServer(l.Addr().String(), setupStore(t))
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Fatalf("Unexpected nil error when the TCP address is in use")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Unexpected hang when the TCP address is in use")
+	}
+	// The UDP listener must have been closed, so the address is free again
+	pc, err := net.ListenPacket("udp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Unexpected error reusing the UDP address: %v", err)
+	}
+	pc.Close()
+}
+
+func TestListenAndServeShutdown(t *testing.T) {
+	s := NewServer("127.0.0.1:0", setupStore(t))
+	started := make(chan struct{}, 2)
+	s.udp.NotifyStartedFunc = func() { started <- struct{}{} }
+	s.tcp.NotifyStartedFunc = func() { started <- struct{}{} }
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.ListenAndServe()
+	}()
+	// Wait for both servers to start before shutting down
+	<-started
+	<-started
+	err := s.Shutdown(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error shutting down: %v", err)
+	}
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatalf("Unexpected error after shutting down: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Unexpected hang after shutting down")
+	}
+}
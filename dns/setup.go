@@ -1,13 +1,24 @@
 package dns
 
 import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/fall"
 	"github.com/gophish/healthcheck/config"
 	"github.com/gophish/healthcheck/db"
+	"github.com/gophish/healthcheck/webhook"
 	"github.com/mholt/caddy"
 )
 
+// ErrBackendMismatch is returned when the server blocks of a Corefile set
+// different configuration files or databases.
+var ErrBackendMismatch = errors.New("the config and db properties must be the same in every healthcheck block")
+
 func init() {
 	caddy.RegisterPlugin("healthcheck", caddy.Plugin{
 		ServerType: "dns",
@@ -15,22 +26,253 @@ func init() {
 	})
 }
 
-func setup(c *caddy.Controller) error {
-	c.Next() // healthcheck
+// options holds the settings of a healthcheck block in the Corefile:
+//
+//	healthcheck [ZONES...] {
+//	    config PATH
+//	    db DRIVER DSN
+//	    zone ZONES...
+//	    ttl SECONDS
+//	    log_queries [on|off]
+//	    fallthrough [ZONES...]
+//	}
+type options struct {
+	configPath string
+	dbName     string
+	dbPath     string
+	zones      []string
+	ttl        uint32
+	ttlSet     bool
+	logQueries bool
+	fall       fall.F
+}
+
+// parse returns the options set in the healthcheck block.
+func parse(c *caddy.Controller) (options, error) {
+	o := options{configPath: config.Path(), logQueries: true}
+	for c.Next() {
+		o.zones = append(o.zones, c.RemainingArgs()...)
+		for c.NextBlock() {
+			switch c.Val() {
+			case "config":
+				if !c.NextArg() {
+					return o, c.ArgErr()
+				}
+				o.configPath = c.Val()
+			case "db":
+				args := c.RemainingArgs()
+				if len(args) != 2 {
+					return o, c.ArgErr()
+				}
+				o.dbName, o.dbPath = args[0], args[1]
+			case "zone":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return o, c.ArgErr()
+				}
+				o.zones = append(o.zones, args...)
+			case "ttl":
+				if !c.NextArg() {
+					return o, c.ArgErr()
+				}
+				ttl, err := strconv.ParseUint(c.Val(), 10, 32)
+				if err != nil {
+					return o, c.Errf("invalid ttl %q", c.Val())
+				}
+				o.ttl, o.ttlSet = uint32(ttl), true
+			case "log_queries":
+				args := c.RemainingArgs()
+				switch {
+				case len(args) == 0 || args[0] == "on":
+					o.logQueries = true
+				case len(args) == 1 && args[0] == "off":
+					o.logQueries = false
+				default:
+					return o, c.ArgErr()
+				}
+			case "fallthrough":
+				o.fall.SetZonesFromArgs(c.RemainingArgs())
+			default:
+				return o, c.Errf("unknown property %q", c.Val())
+			}
+		}
+	}
+	for i := range o.zones {
+		o.zones[i] = plugin.Host(o.zones[i]).Normalize()
+	}
+	return o, nil
+}
+
+// configZones returns the provided zones along with the hostnames used by the
+// messages we send, so that operators don't have to list them.
+func configZones(zones ...string) []string {
+	hostnames := []string{
+		config.Config.EmailHostname,
+		config.Config.EnvelopeHostname,
+		config.Config.LookalikeHostname,
+	}
+	for _, hostname := range hostnames {
+		if hostname == "" {
+			continue
+		}
+		zone := plugin.Host(hostname).Normalize()
+		found := false
+		for _, z := range zones {
+			if z == zone {
+				found = true
+			}
+		}
+		if !found {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// backend holds the configuration, the database and the workers shared by
+// the server blocks of a Corefile. They are started along with the first
+// server block and stopped once the last one shuts down.
+type backend struct {
+	configPath string
+	dbName     string
+	dbPath     string
+	gormStore  *db.GormStore
+	store      *db.CachedStore
+	users      int
+	cancel     context.CancelFunc
+}
+
+// backends holds the backend of every Corefile being loaded, keyed by the
+// context of the load, until its servers are started.
+var backends = struct {
+	sync.Mutex
+	loading map[caddy.Context]*backend
+}{loading: map[caddy.Context]*backend{}}
+
+// loadBackend returns the backend shared by the server blocks of the Corefile
+// loaded with key, loading the configuration and opening the database for
+// the first one. Every block has to use the same configuration and database,
+// since the configuration is global to the process. The global configuration
+// is only replaced once the database is open, so that a failed reload leaves
+// the running servers untouched.
+func loadBackend(key caddy.Context, o options) (*backend, error) {
+	backends.Lock()
+	defer backends.Unlock()
+	if b, ok := backends.loading[key]; ok {
+		if b.configPath != o.configPath || b.dbName != o.dbName || b.dbPath != o.dbPath {
+			return nil, ErrBackendMismatch
+		}
+		return b, nil
+	}
+	overrides := map[string]string{}
+	if o.dbName != "" {
+		overrides["db_name"] = o.dbName
+		overrides["db_path"] = o.dbPath
+	}
+	c, err := config.Load(o.configPath, overrides)
+	if err != nil {
+		return nil, err
+	}
+	gormStore, err := db.Open(c)
+	if err != nil {
+		return nil, err
+	}
+	config.Config = c
+	// Messages are updated by the API in another process, so the cache
+	// has to look for the updates in the database.
+	store := db.NewCachedStore(gormStore)
+	store.PollInterval = db.DefaultCachePollInterval
+	b := &backend{
+		configPath: o.configPath,
+		dbName:     o.dbName,
+		dbPath:     o.dbPath,
+		gormStore:  gormStore,
+		store:      store,
+	}
+	backends.loading[key] = b
+	return b, nil
+}
+
+// discardBackend closes the database of the backend loaded for the Corefile
+// loaded with key, if any, once setting up one of its server blocks failed.
+// The Corefile won't be loaded, so the backend would never be started.
+func discardBackend(key caddy.Context) error {
+	backends.Lock()
+	defer backends.Unlock()
+	b, ok := backends.loading[key]
+	if !ok {
+		return nil
+	}
+	delete(backends.loading, key)
+	return b.gormStore.Close()
+}
+
+// start starts the cache invalidation and the webhook deliveries, if they
+// aren't running yet. DNS lookups are published from this process, so we
+// need to deliver them to the webhooks from here as well.
+func (b *backend) start(key caddy.Context) {
+	backends.Lock()
+	defer backends.Unlock()
+	delete(backends.loading, key)
+	if b.users == 0 {
+		var ctx context.Context
+		ctx, b.cancel = context.WithCancel(context.Background())
+		dispatcher := webhook.NewWorker()
+		dispatcher.Store = b.store
+		go dispatcher.Start(ctx)
+		go b.store.Start(ctx)
+	}
+	b.users++
+}
+
+// stop stops the workers and closes the database once the last server block
+// using the backend shuts down.
+func (b *backend) stop() error {
+	backends.Lock()
+	defer backends.Unlock()
+	b.users--
+	if b.users > 0 {
+		return nil
+	}
+	b.cancel()
+	return b.gormStore.Close()
+}
 
-	err := config.LoadConfig("./config.json")
+func setup(c *caddy.Controller) error {
+	key := c.Context()
+	o, err := parse(c)
 	if err != nil {
-		return err
+		discardBackend(key)
+		return plugin.Error(HealthCheckPluginName, err)
 	}
 
-	err = db.Setup()
+	b, err := loadBackend(key, o)
 	if err != nil {
-		return err
+		discardBackend(key)
+		return plugin.Error(HealthCheckPluginName, err)
 	}
 
+	ttl := config.Config.DNSTTL
+	if o.ttlSet {
+		ttl = o.ttl
+	}
+
+	zones := configZones(o.zones...)
+
+	c.OnStartup(func() error {
+		b.start(key)
+		return nil
+	})
+	c.OnShutdown(b.stop)
+
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		return HealthCheckPlugin{
-			Next: next,
+			Next:       next,
+			Store:      b.store,
+			Zones:      zones,
+			TTL:        ttl,
+			LogQueries: o.logQueries,
+			Fall:       o.fall,
 		}
 	})
 
@@ -0,0 +1,226 @@
+package dns
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gophish/healthcheck/config"
+	"github.com/mholt/caddy"
+)
+
+func TestParse(t *testing.T) {
+	c := caddy.NewTestController("dns", `healthcheck example.com {
+		config /etc/healthcheck/config.json
+		db postgres postgres://healthcheck@localhost/healthcheck
+		zone example.org
+		ttl 300
+		log_queries off
+		fallthrough
+	}`)
+	o, err := parse(c)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing the Corefile: %v", err)
+	}
+	if o.configPath != "/etc/healthcheck/config.json" {
+		t.Fatalf("Unexpected config path. Expected /etc/healthcheck/config.json Got %s", o.configPath)
+	}
+	if o.dbName != "postgres" || o.dbPath != "postgres://healthcheck@localhost/healthcheck" {
+		t.Fatalf("Unexpected database. Got %s %s", o.dbName, o.dbPath)
+	}
+	expected := []string{"example.com.", "example.org."}
+	if !reflect.DeepEqual(o.zones, expected) {
+		t.Fatalf("Unexpected zones. Expected %v Got %v", expected, o.zones)
+	}
+	if !o.ttlSet || o.ttl != 300 {
+		t.Fatalf("Unexpected TTL. Expected 300 Got %d", o.ttl)
+	}
+	if o.logQueries {
+		t.Fatal("Expected the query log to be disabled")
+	}
+	if !o.fall.Through("anything.example.com.") {
+		t.Fatal("Expected queries to fall through")
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	c := caddy.NewTestController("dns", `healthcheck`)
+	o, err := parse(c)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing the Corefile: %v", err)
+	}
+	if !o.logQueries {
+		t.Fatal("Expected the query log to be enabled by default")
+	}
+	if o.ttlSet {
+		t.Fatal("Expected the TTL to default to the configuration")
+	}
+	if o.fall.Through("example.com.") {
+		t.Fatal("Expected queries not to fall through by default")
+	}
+	if len(o.zones) != 0 {
+		t.Fatalf("Unexpected zones. Expected none Got %v", o.zones)
+	}
+}
+
+func TestConfigZones(t *testing.T) {
+	config.Config.EmailHostname = "mail.example.com"
+	config.Config.EnvelopeHostname = "Bounce.example.net"
+	config.Config.LookalikeHostname = ""
+	got := configZones("example.org.", "mail.example.com.")
+	expected := []string{"example.org.", "mail.example.com.", "bounce.example.net."}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Unexpected zones. Expected %v Got %v", expected, got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		`healthcheck {
+			ttl forever
+		}`,
+		`healthcheck {
+			db postgres
+		}`,
+		`healthcheck {
+			config
+		}`,
+		`healthcheck {
+			log_queries sometimes
+		}`,
+		`healthcheck {
+			unknown
+		}`,
+	}
+	for _, input := range tests {
+		c := caddy.NewTestController("dns", input)
+		_, err := parse(c)
+		if err == nil {
+			t.Fatalf("Expected an error parsing %s", input)
+		}
+	}
+}
+
+func TestLoadBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "healthcheck-dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(path, []byte(`{
+		"db_name": "sqlite3",
+		"db_path": ":memory:",
+		"migrations_path": "../db/",
+		"email_hostname": "mail.example.com",
+		"secret_key": "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := caddy.NewTestController("dns", "healthcheck").Context()
+	o := options{configPath: path}
+	b, err := loadBackend(key, o)
+	if err != nil {
+		t.Fatalf("Unexpected error loading the backend: %v", err)
+	}
+	got, err := loadBackend(key, o)
+	if err != nil || got != b {
+		t.Fatalf("Unexpected backend for the same settings: %v", err)
+	}
+	o.dbName, o.dbPath = "sqlite3", filepath.Join(dir, "other.db")
+	_, err = loadBackend(key, o)
+	if err != ErrBackendMismatch {
+		t.Fatalf("Unexpected error for another database. Expected %v Got %v", ErrBackendMismatch, err)
+	}
+
+	b.start(key)
+	b.start(key)
+	if _, ok := backends.loading[key]; ok {
+		t.Fatal("Backend still loading after being started")
+	}
+	b.stop()
+	_, err = b.gormStore.GetOrganizations()
+	if err != nil {
+		t.Fatalf("Database closed while still used: %v", err)
+	}
+	b.stop()
+	_, err = b.gormStore.GetOrganizations()
+	if err == nil {
+		t.Fatal("Database not closed after the last server block stopped")
+	}
+}
+
+func TestLoadBackendFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "healthcheck-dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(path, []byte(`{
+		"db_name": "sqlite3",
+		"db_path": "`+filepath.Join(dir, "missing", "healthcheck.db")+`",
+		"migrations_path": "../db/",
+		"email_hostname": "mail.example.com",
+		"secret_key": "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The running configuration is kept when the database can't be opened
+	config.Config.EmailHostname = "running.example.com"
+	key := caddy.NewTestController("dns", "healthcheck").Context()
+	_, err = loadBackend(key, options{configPath: path})
+	if err == nil {
+		t.Fatal("Expected an error opening the database")
+	}
+	if config.Config.EmailHostname != "running.example.com" {
+		t.Fatalf("Unexpected configuration after a failed load. Expected running.example.com Got %s", config.Config.EmailHostname)
+	}
+	if _, ok := backends.loading[key]; ok {
+		t.Fatal("Unexpected backend loading after a failed load")
+	}
+}
+
+func TestDiscardBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "healthcheck-dns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(path, []byte(`{
+		"db_name": "sqlite3",
+		"db_path": ":memory:",
+		"migrations_path": "../db/",
+		"email_hostname": "mail.example.com",
+		"secret_key": "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A server block failing after the first one was set up discards the
+	// backend loaded for the Corefile
+	key := caddy.NewTestController("dns", "healthcheck").Context()
+	b, err := loadBackend(key, options{configPath: path})
+	if err != nil {
+		t.Fatalf("Unexpected error loading the backend: %v", err)
+	}
+	err = discardBackend(key)
+	if err != nil {
+		t.Fatalf("Unexpected error discarding the backend: %v", err)
+	}
+	if _, ok := backends.loading[key]; ok {
+		t.Fatal("Backend still loading after being discarded")
+	}
+	_, err = b.gormStore.GetOrganizations()
+	if err == nil {
+		t.Fatal("Database not closed after the backend was discarded")
+	}
+}
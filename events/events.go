@@ -0,0 +1,134 @@
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+)
+
+const (
+	// MessageQueued is published when a message is created
+	MessageQueued = "message.queued"
+	// MessageSent is published when the mail server accepts a message
+	MessageSent = "message.sent"
+	// MessageRejected is published when the mail server permanently rejects
+	// a message
+	MessageRejected = "message.rejected"
+	// MessageDeferred is published when the mail server temporarily rejects
+	// a message
+	MessageDeferred = "message.deferred"
+	// MessageFailed is published when a message couldn't be delivered for a
+	// reason other than an SMTP rejection
+	MessageFailed = "message.failed"
+	// MessageReported is published when the recipient reports a message
+	// reached their inbox
+	MessageReported = "message.reported"
+	// DNSLookup is published when a DNS query for a message reaches the DNS
+	// plugin
+	DNSLookup = "dns.lookup"
+)
+
+// DefaultBufferSize is the number of events buffered for each subscription
+// before new events are dropped.
+const DefaultBufferSize = 100
+
+// Query describes the DNS query which caused a DNSLookup event.
+type Query struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	RemoteIP string `json:"remote_ip"`
+	TTL      uint32 `json:"ttl"`
+}
+
+// Event is a single step in the lifecycle of a message.
+type Event struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	MessageID  string    `json:"message_id"`
+	OrgID      uint      `json:"org_id"`
+	DomainHash string    `json:"domain_hash"`
+	RunID      uint      `json:"run_id,omitempty"`
+	Scenario   string    `json:"scenario,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Query      *Query    `json:"query,omitempty"`
+}
+
+// Subscription receives the events published after it was created, or only
+// the ones matching its filter if it has one.
+type Subscription struct {
+	C chan Event
+
+	match func(Event) bool
+	// dropped counts the events dropped because the buffer was full, and
+	// dropping is set while events are being dropped, so that we only warn
+	// once until the subscriber catches up.
+	dropped  uint64
+	dropping int32
+}
+
+var (
+	mu            sync.RWMutex
+	subscriptions = map[*Subscription]bool{}
+)
+
+// Subscribe returns a new subscription which buffers up to size events.
+func Subscribe(size int) *Subscription {
+	return SubscribeFunc(size, nil)
+}
+
+// SubscribeFunc returns a new subscription which only receives the events
+// matching the filter, buffering up to size events. A nil filter matches
+// every event.
+func SubscribeFunc(size int, match func(Event) bool) *Subscription {
+	s := &Subscription{C: make(chan Event, size), match: match}
+	mu.Lock()
+	subscriptions[s] = true
+	mu.Unlock()
+	return s
+}
+
+// Unsubscribe stops delivering events to the subscription and closes its
+// channel.
+func Unsubscribe(s *Subscription) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := subscriptions[s]; !ok {
+		return
+	}
+	delete(subscriptions, s)
+	close(s.C)
+}
+
+// Dropped returns the number of events dropped for the subscription because
+// its buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Publish sends the event to every matching subscription. Publishing never
+// blocks: if a subscription's buffer is full, the event is dropped for that
+// subscription.
+func Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	for s := range subscriptions {
+		if s.match != nil && !s.match(e) {
+			continue
+		}
+		select {
+		case s.C <- e:
+			atomic.StoreInt32(&s.dropping, 0)
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+			if atomic.CompareAndSwapInt32(&s.dropping, 0, 1) {
+				log.Warnf("subscriber is full, dropping events starting with %s for message %s", e.Type, e.MessageID)
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestPublish(t *testing.T) {
+	s := Subscribe(1)
+	defer Unsubscribe(s)
+	Publish(Event{Type: MessageQueued, MessageID: "abc"})
+	e := <-s.C
+	if e.Type != MessageQueued || e.MessageID != "abc" {
+		t.Fatalf("Unexpected event received: %#v", e)
+	}
+	if e.Time.IsZero() {
+		t.Fatalf("Event time wasn't set")
+	}
+}
+
+func TestPublishFullSubscription(t *testing.T) {
+	s := Subscribe(1)
+	defer Unsubscribe(s)
+	Publish(Event{Type: MessageQueued})
+	// This shouldn't block, even though the buffer is full
+	Publish(Event{Type: MessageSent})
+	Publish(Event{Type: MessageReported})
+	e := <-s.C
+	if e.Type != MessageQueued {
+		t.Fatalf("Unexpected event received. Got %s Expected %s", e.Type, MessageQueued)
+	}
+	if s.Dropped() != 2 {
+		t.Fatalf("Unexpected number of dropped events. Expected 2 Got %d", s.Dropped())
+	}
+}
+
+func TestSubscribeFunc(t *testing.T) {
+	s := SubscribeFunc(1, func(e Event) bool {
+		return e.MessageID == "abc"
+	})
+	defer Unsubscribe(s)
+	// Events which don't match aren't buffered, so they can't fill the
+	// subscription
+	Publish(Event{Type: MessageQueued, MessageID: "def"})
+	Publish(Event{Type: MessageSent, MessageID: "abc"})
+	e := <-s.C
+	if e.Type != MessageSent || e.MessageID != "abc" {
+		t.Fatalf("Unexpected event received: %#v", e)
+	}
+	if s.Dropped() != 0 {
+		t.Fatalf("Unexpected number of dropped events. Expected 0 Got %d", s.Dropped())
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	s := Subscribe(1)
+	Unsubscribe(s)
+	Publish(Event{Type: MessageQueued})
+	if _, ok := <-s.C; ok {
+		t.Fatalf("Received event after unsubscribing")
+	}
+	// Unsubscribing twice is a no-op
+	Unsubscribe(s)
+}
@@ -0,0 +1,283 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gophish/healthcheck/db"
+)
+
+const (
+	// FormatCSV exports one row per message
+	FormatCSV = "csv"
+	// FormatNDJSON exports one JSON object per line for each message
+	FormatNDJSON = "ndjson"
+	// FormatJUnit exports a JUnit XML report with a test suite per run and a
+	// test case per scenario
+	FormatJUnit = "junit"
+)
+
+const (
+	// ResultPass indicates the scenario had the expected outcome
+	ResultPass = db.GradePass
+	// ResultWarn indicates we can't tell whether the scenario had the
+	// expected outcome
+	ResultWarn = db.GradeWarn
+	// ResultFail indicates the scenario didn't have the expected outcome
+	ResultFail = db.GradeFail
+)
+
+// AdHocSuite is the name of the JUnit test suite holding the messages which
+// weren't sent as part of a run.
+const AdHocSuite = "ad-hoc"
+
+// ErrInvalidFormat occurs when an unknown export format is requested.
+var ErrInvalidFormat = errors.New("invalid export format")
+
+// Result is a single exported message.
+type Result struct {
+	MessageID  string    `json:"message_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	DomainHash string    `json:"domain_hash"`
+	RunID      uint      `json:"run_id,omitempty"`
+	Scenario   string    `json:"scenario,omitempty"`
+	MailServer string    `json:"mail_server"`
+	Status     string    `json:"status"`
+	Outcome    string    `json:"outcome"`
+	// Expected is the outcome expected for the scenario. It's empty for
+	// messages which weren't sent as part of a scenario.
+	Expected string `json:"expected,omitempty"`
+	// Result is empty if the expected or actual outcome isn't known.
+	Result     string `json:"result,omitempty"`
+	Error      string `json:"error,omitempty"`
+	TLSVersion string `json:"tls_version,omitempty"`
+	DANE       string `json:"dane,omitempty"`
+	MTASTS     string `json:"mta_sts,omitempty"`
+}
+
+// NewResult converts a message into an exported result.
+func NewResult(m db.Message) Result {
+	return Result{
+		MessageID:  m.MessageID,
+		CreatedAt:  m.CreatedAt,
+		DomainHash: m.DomainHash,
+		RunID:      m.RunID,
+		Scenario:   m.Scenario,
+		MailServer: m.MailServer,
+		Status:     m.Status,
+		Outcome:    m.Outcome(),
+		Error:      m.ErrorMessage,
+		TLSVersion: m.TLS.Version,
+		DANE:       m.TLS.DANE,
+		MTASTS:     m.TLS.MTASTS,
+		Expected:   m.Expected(),
+		Result:     m.Result(),
+	}
+}
+
+// ParseFilter returns a filter on the scenario and the time range of the
+// messages, where from and to are optional RFC 3339 times.
+func ParseFilter(scenario, from, to string) (db.MessageFilter, error) {
+	f := db.MessageFilter{Scenario: scenario}
+	var err error
+	if from != "" {
+		f.From, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			return f, err
+		}
+	}
+	if to != "" {
+		f.To, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// ContentType returns the MIME type of the export format.
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv"
+	case FormatNDJSON:
+		return "application/x-ndjson"
+	case FormatJUnit:
+		return "application/xml"
+	}
+	return "application/octet-stream"
+}
+
+// Write exports the messages in the requested format.
+func Write(w io.Writer, format string, messages []db.Message) error {
+	switch format {
+	case FormatCSV:
+		return WriteCSV(w, messages)
+	case FormatNDJSON:
+		return WriteNDJSON(w, messages)
+	case FormatJUnit:
+		return WriteJUnit(w, messages)
+	}
+	return ErrInvalidFormat
+}
+
+var csvHeader = []string{
+	"message_id", "created_at", "domain_hash", "run_id", "scenario",
+	"mail_server", "status", "outcome", "expected", "result", "error",
+	"tls_version", "dane", "mta_sts",
+}
+
+// WriteCSV exports the messages as CSV, including a header row.
+func WriteCSV(w io.Writer, messages []db.Message) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write(csvHeader)
+	if err != nil {
+		return err
+	}
+	for _, m := range messages {
+		r := NewResult(m)
+		err = cw.Write([]string{
+			r.MessageID, r.CreatedAt.UTC().Format(time.RFC3339), r.DomainHash,
+			strconv.FormatUint(uint64(r.RunID), 10), r.Scenario, r.MailServer,
+			r.Status, r.Outcome, r.Expected, r.Result, r.Error,
+			r.TLSVersion, r.DANE, r.MTASTS,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteNDJSON exports the messages as JSON Lines, one result per line.
+func WriteNDJSON(w io.Writer, messages []db.Message) error {
+	enc := json.NewEncoder(w)
+	for _, m := range messages {
+		err := enc.Encode(NewResult(m))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JUnitTestSuites is the root element of a JUnit XML report.
+type JUnitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Suites   []JUnitTestSuite `xml:"testsuite"`
+}
+
+// JUnitTestSuite contains the scenarios sent in a single run.
+type JUnitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	TestCases []JUnitTestCase `xml:"testcase"`
+}
+
+// JUnitTestCase is a single scenario, or a single message which wasn't sent
+// using a scenario. Scenarios pass if the message had the expected outcome,
+// meaning undesired messages were blocked, while other messages pass once
+// they're delivered or rejected.
+type JUnitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *JUnitFailure `xml:"failure,omitempty"`
+	Skipped   *JUnitSkipped `xml:"skipped,omitempty"`
+}
+
+// JUnitFailure describes why a scenario failed.
+type JUnitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// JUnitSkipped describes why a scenario doesn't have a result yet.
+type JUnitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit exports the messages as a JUnit XML report. Messages sent as
+// part of a run are grouped by run, while the others are grouped in an
+// ad-hoc suite for each domain.
+func WriteJUnit(w io.Writer, messages []db.Message) error {
+	report := JUnitTestSuites{}
+	suites := map[string]int{}
+	for _, m := range messages {
+		r := NewResult(m)
+		name := fmt.Sprintf("%s/run-%d", r.DomainHash, r.RunID)
+		if r.RunID == 0 {
+			name = fmt.Sprintf("%s/%s", r.DomainHash, AdHocSuite)
+		}
+		i, ok := suites[name]
+		if !ok {
+			i = len(report.Suites)
+			suites[name] = i
+			report.Suites = append(report.Suites, JUnitTestSuite{
+				Name:      name,
+				Timestamp: r.CreatedAt.UTC().Format(time.RFC3339),
+			})
+		}
+		suite := &report.Suites[i]
+		tc := JUnitTestCase{
+			Name:      r.Scenario,
+			ClassName: r.DomainHash,
+		}
+		if tc.Name == "" {
+			tc.Name = fmt.Sprintf("message-%s", r.MessageID)
+		}
+		switch {
+		case r.Result == ResultFail:
+			tc.Failure = &JUnitFailure{
+				Message: fmt.Sprintf("expected message to be %s, but it was %s", r.Expected, r.Outcome),
+				Type:    r.Outcome,
+				Text:    fmt.Sprintf("message %s, status %s", r.MessageID, r.Status),
+			}
+			suite.Failures++
+		case r.Expected == "" && r.Status == db.StatusFailed:
+			tc.Failure = &JUnitFailure{
+				Message: "message couldn't be delivered",
+				Type:    r.Status,
+				Text:    fmt.Sprintf("message %s: %s", r.MessageID, r.Error),
+			}
+			suite.Failures++
+		case r.Result == ResultWarn:
+			tc.Skipped = &JUnitSkipped{
+				Message: "message was accepted, but we can't tell whether it reached the inbox",
+			}
+			suite.Skipped++
+		case r.Outcome == "":
+			tc.Skipped = &JUnitSkipped{
+				Message: fmt.Sprintf("message is %s", r.Status),
+			}
+			suite.Skipped++
+		}
+		suite.Tests++
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	for _, suite := range report.Suites {
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Skipped += suite.Skipped
+	}
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(report)
+}
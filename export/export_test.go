@@ -0,0 +1,124 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/gophish/healthcheck/db"
+)
+
+var testMessages = []db.Message{
+	{MessageID: "1", DomainHash: "hash", RunID: 1, Scenario: "baseline", Status: db.StatusReceived},
+	{MessageID: "2", DomainHash: "hash", RunID: 1, Scenario: "spf_hardfail", Status: db.StatusRejected},
+	{MessageID: "3", DomainHash: "hash", RunID: 1, Scenario: "spf_softfail", Status: db.StatusSent},
+	{MessageID: "4", DomainHash: "hash", RunID: 1, Scenario: "no_mx", Status: db.StatusQueued},
+	{MessageID: "5", DomainHash: "hash", Status: db.StatusSent},
+	{MessageID: "6", DomainHash: "hash", Status: db.StatusFailed, ErrorMessage: "connection refused"},
+}
+
+func TestNewResult(t *testing.T) {
+	expected := map[string]string{
+		"1": ResultPass,
+		"2": ResultPass,
+		"3": ResultWarn,
+		"4": "",
+		"5": "",
+		"6": "",
+	}
+	for _, m := range testMessages {
+		r := NewResult(m)
+		if r.Result != expected[m.MessageID] {
+			t.Fatalf("Unexpected result for message %s. Got %q Expected %q", m.MessageID, r.Result, expected[m.MessageID])
+		}
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	buff := &bytes.Buffer{}
+	err := WriteCSV(buff, testMessages)
+	if err != nil {
+		t.Fatalf("Unexpected error writing CSV: %v", err)
+	}
+	rows, err := csv.NewReader(buff).ReadAll()
+	if err != nil {
+		t.Fatalf("Unexpected error reading CSV: %v", err)
+	}
+	if len(rows) != len(testMessages)+1 {
+		t.Fatalf("Unexpected number of rows. Got %d Expected %d", len(rows), len(testMessages)+1)
+	}
+}
+
+func TestWriteNDJSON(t *testing.T) {
+	buff := &bytes.Buffer{}
+	err := WriteNDJSON(buff, testMessages)
+	if err != nil {
+		t.Fatalf("Unexpected error writing NDJSON: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buff.String()), "\n")
+	if len(lines) != len(testMessages) {
+		t.Fatalf("Unexpected number of lines. Got %d Expected %d", len(lines), len(testMessages))
+	}
+	r := Result{}
+	err = json.Unmarshal([]byte(lines[2]), &r)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing line: %v", err)
+	}
+	if r.MessageID != "3" || r.Result != ResultWarn {
+		t.Fatalf("Unexpected result: %#v", r)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	buff := &bytes.Buffer{}
+	err := WriteJUnit(buff, testMessages)
+	if err != nil {
+		t.Fatalf("Unexpected error writing JUnit: %v", err)
+	}
+	report := JUnitTestSuites{}
+	err = xml.Unmarshal(buff.Bytes(), &report)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing JUnit: %v", err)
+	}
+	// Accepted messages which should have been blocked are skipped, since
+	// they may have been quarantined
+	if report.Tests != 6 || report.Failures != 1 || report.Skipped != 2 {
+		t.Fatalf("Unexpected totals. Got %d tests, %d failures, %d skipped", report.Tests, report.Failures, report.Skipped)
+	}
+	if len(report.Suites) != 2 || report.Suites[0].TestCases[2].Skipped == nil {
+		t.Fatalf("Unexpected suites: %#v", report.Suites)
+	}
+	// Messages sent without a scenario are in the ad-hoc suite, and fail if
+	// they couldn't be delivered
+	adHoc := report.Suites[1]
+	if adHoc.Name != "hash/"+AdHocSuite || adHoc.Tests != 2 || adHoc.Failures != 1 {
+		t.Fatalf("Unexpected ad-hoc suite: %#v", adHoc)
+	}
+	if adHoc.TestCases[0].Name != "message-5" || adHoc.TestCases[0].Failure != nil {
+		t.Fatalf("Unexpected ad-hoc test case: %#v", adHoc.TestCases[0])
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	f, err := ParseFilter("baseline", "2026-10-01T00:00:00Z", "")
+	if err != nil {
+		t.Fatalf("Unexpected error parsing filter: %v", err)
+	}
+	if f.Scenario != "baseline" || f.From.IsZero() || !f.To.IsZero() {
+		t.Fatalf("Unexpected filter: %#v", f)
+	}
+	_, err = ParseFilter("", "", "yesterday")
+	if err == nil {
+		t.Fatal("Expected an error parsing an invalid time")
+	}
+}
+
+func TestWriteInvalidFormat(t *testing.T) {
+	err := Write(&bytes.Buffer{}, "pdf", testMessages)
+	if err != ErrInvalidFormat {
+		t.Fatalf("Unexpected error. Got %v Expected %v", err, ErrInvalidFormat)
+	}
+}
@@ -0,0 +1,57 @@
+package mail
+
+import (
+	log "github.com/gophish/gophish/logger"
+	"github.com/gophish/healthcheck/db"
+)
+
+// SendRun saves the run in the store and creates a message for each of the
+// provided scenarios. The messages are then sent one after the other in the
+// background.
+func SendRun(store db.Store, r *db.Run, recipient, mailServer string, scenarios []string) error {
+	messages := []*db.Message{}
+	for _, name := range scenarios {
+		scenario, err := db.GetScenario(name)
+		if err != nil {
+			return err
+		}
+		m := &db.Message{
+			Recipient:            recipient,
+			MailServer:           mailServer,
+			OrgID:                r.OrgID,
+			DomainHash:           r.DomainHash,
+			Scenario:             scenario.Name,
+			MessageConfiguration: scenario.Configuration,
+		}
+		err = m.Validate()
+		if err != nil {
+			return err
+		}
+		err = m.ResolveMailServer()
+		if err != nil {
+			return err
+		}
+		messages = append(messages, m)
+	}
+	err := store.PostRun(r)
+	if err != nil {
+		return err
+	}
+	for _, m := range messages {
+		m.RunID = r.ID
+		m.ErrorChan = make(chan error)
+		err = store.PostMessage(m)
+		if err != nil {
+			return err
+		}
+	}
+	go func() {
+		for _, m := range messages {
+			err := SendEmail(m)
+			if err != nil {
+				log.Errorf("error sending %s message for run %d: %v", m.Scenario, r.ID, err)
+			}
+		}
+	}()
+	return nil
+}
@@ -2,30 +2,217 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	log "github.com/gophish/gophish/logger"
 	"github.com/gophish/gophish/mailer"
 	"github.com/gophish/healthcheck/api"
 	"github.com/gophish/healthcheck/config"
 	"github.com/gophish/healthcheck/db"
+	"github.com/gophish/healthcheck/dns"
+	"github.com/gophish/healthcheck/export"
+	"github.com/gophish/healthcheck/retention"
+	"github.com/gophish/healthcheck/scheduler"
+	"github.com/gophish/healthcheck/smtp"
+	"github.com/gophish/healthcheck/webhook"
 )
 
 func main() {
-	err := config.LoadConfig("./config.json")
+	configPath := config.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	err := config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	smtp.DialTimeout = config.Config.SMTPDialTimeout()
+	smtp.Timeout = config.Config.SMTPTimeout()
+	store, err := db.Setup()
 	if err != nil {
 		panic(err)
 	}
-	err = db.Setup()
+
+	if flag.Arg(0) == "export" {
+		err = runExport(store, flag.Args()[1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == "rehash" {
+		err = runRehash(store, flag.Args()[1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	key, err := store.BootstrapAPIKey()
 	if err != nil {
 		panic(err)
 	}
+	if key != "" {
+		log.Infof("Created superadmin API key %s. It won't be shown again.", key)
+	}
 
+	serve := flag.Arg(0) == "serve"
+	err = run(store, serve)
+	if err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+}
+
+// ShutdownTimeout is how long we wait for in-flight requests and queries to
+// complete when shutting down.
+const ShutdownTimeout = 30 * time.Second
+
+// run starts the API server and the background workers, blocking until the
+// process receives SIGINT or SIGTERM or one of the servers fails. When serve
+// is set, the DNS server is embedded in the same process instead of running
+// as a CoreDNS plugin.
+func run(gormStore *db.GormStore, serve bool) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	go mailer.Mailer.Start(ctx)
 
-	router := api.NewAPIRouter()
-	log.Info("API Server started on :3000")
-	http.ListenAndServe(":3000", router)
+	// The API, the DNS server and the workers share the same store, so that
+	// messages created by one are visible to the others.
+	store := db.NewCachedStore(gormStore)
+	scheduler.Scheduler.Store = store
+	retention.Purger.Store = store
+	webhook.Dispatcher.Store = store
+
+	var wg sync.WaitGroup
+	workers := []func(context.Context){
+		mailer.Mailer.Start,
+		scheduler.Scheduler.Start,
+		retention.Purger.Start,
+		webhook.Dispatcher.Start,
+		store.Start,
+	}
+	for _, start := range workers {
+		wg.Add(1)
+		go func(start func(context.Context)) {
+			defer wg.Done()
+			start(ctx)
+		}(start)
+	}
+
+	errs := make(chan error, 2)
+	server := &http.Server{
+		Addr:    config.Config.ListenAddr,
+		Handler: api.NewAPIRouter(store),
+	}
+	go func() {
+		log.Infof("API Server started on %s", server.Addr)
+		err := server.ListenAndServe()
+		if err != http.ErrServerClosed {
+			errs <- err
+		}
+	}()
+
+	var dnsServer *dns.Server
+	if serve {
+		dnsServer = dns.NewServer(config.Config.DNSListenAddr, store)
+		go func() {
+			log.Infof("DNS Server started on %s", config.Config.DNSListenAddr)
+			err := dnsServer.ListenAndServe()
+			if err != nil {
+				errs <- err
+			}
+		}()
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	var err error
+	select {
+	case sig := <-sigs:
+		log.Infof("Received %s, shutting down", sig)
+	case err = <-errs:
+	}
+
+	// Stop accepting new work first, then wait for the workers so that
+	// messages being sent are either delivered or cancelled before we exit.
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer shutdownCancel()
+	if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+		log.Errorf("error shutting down the API server: %v", shutdownErr)
+	}
+	if dnsServer != nil {
+		if shutdownErr := dnsServer.Shutdown(shutdownCtx); shutdownErr != nil {
+			log.Errorf("error shutting down the DNS server: %v", shutdownErr)
+		}
+	}
+	cancel()
+	wg.Wait()
+	return err
+}
+
+// runRehash replaces the legacy SHA-1 identifiers of the provided domains
+// with their keyed identifiers.
+func runRehash(store *db.GormStore, domains []string) error {
+	if len(domains) == 0 {
+		return fmt.Errorf("usage: healthcheck rehash <domain>...")
+	}
+	for _, domain := range domains {
+		updated, err := store.RehashDomain(domain)
+		if err != nil {
+			return fmt.Errorf("error rehashing %s: %v", domain, err)
+		}
+		fmt.Printf("%s: updated %d rows\n", domain, updated)
+	}
+	return nil
+}
+
+// runExport writes the messages matching the provided flags to stdout, or to
+// the file set with -o.
+func runExport(store db.Store, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", export.FormatCSV, "export format (csv, ndjson or junit)")
+	org := fs.Uint("org", 0, "only export messages sent by this organization ID")
+	domain := fs.String("domain", "", "only export messages sent to this domain")
+	scenario := fs.String("scenario", "", "only export messages sent using this scenario")
+	from := fs.String("from", "", "only export messages created at or after this time (RFC3339)")
+	to := fs.String("to", "", "only export messages created before this time (RFC3339)")
+	output := fs.String("o", "", "file to write the export to (defaults to stdout)")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	f, err := export.ParseFilter(*scenario, *from, *to)
+	if err != nil {
+		return err
+	}
+	f.OrgID = *org
+	if *domain != "" {
+		f.DomainHash, err = db.DomainID(*domain)
+		if err != nil {
+			return err
+		}
+	}
+	messages, err := store.GetMessages(f)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	return export.Write(w, *format, messages)
 }
@@ -0,0 +1,263 @@
+package report
+
+import (
+	"time"
+
+	"github.com/gophish/healthcheck/db"
+	"github.com/gophish/healthcheck/smtp"
+)
+
+const (
+	// OutcomeRejected indicates the mail server rejected the message
+	OutcomeRejected = "rejected"
+	// OutcomeAccepted indicates the mail server accepted the message, but the
+	// recipient hasn't reported it reaching their inbox. It may have been
+	// quarantined or sent to the junk folder.
+	OutcomeAccepted = "accepted"
+	// OutcomeInbox indicates the recipient reported the message reaching
+	// their inbox
+	OutcomeInbox = "inbox"
+	// OutcomeQuarantined is expected of messages which should be rejected,
+	// or accepted and quarantined.
+	OutcomeQuarantined = "quarantined"
+)
+
+const (
+	// ResultPass indicates the mail server behaves as expected
+	ResultPass = db.GradePass
+	// ResultWarn indicates the mail server partially behaves as expected, or
+	// that we can't tell for sure
+	ResultWarn = db.GradeWarn
+	// ResultFail indicates the mail server doesn't behave as expected
+	ResultFail = db.GradeFail
+)
+
+// Item is a single scored check in a report.
+type Item struct {
+	Name        string `json:"name"`
+	Category    string `json:"category"`
+	Expected    string `json:"expected"`
+	Outcome     string `json:"outcome"`
+	Result      string `json:"result"`
+	Remediation string `json:"remediation,omitempty"`
+	MessageID   string `json:"message_id,omitempty"`
+}
+
+// TrendPoint is the score of a single run.
+type TrendPoint struct {
+	RunID     uint      `json:"run_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Score     float64   `json:"score"`
+}
+
+// Report is a scorecard summarizing every message sent to a domain.
+type Report struct {
+	DomainHash  string    `json:"domain_hash"`
+	Domain      string    `json:"domain,omitempty"`
+	GeneratedAt time.Time `json:"generated_at"`
+	// Score is the percentage of checks passed, with warnings counting as
+	// half a pass.
+	Score     float64      `json:"score"`
+	Items     []Item       `json:"items"`
+	Transport []Item       `json:"transport"`
+	Trend     []TrendPoint `json:"trend"`
+}
+
+// Generate builds the report for the organization's domain using the
+// messages and runs in the store.
+func Generate(store db.Store, orgID uint, hash string) (*Report, error) {
+	messages, err := store.GetMessages(db.MessageFilter{OrgID: orgID, DomainHash: hash})
+	if err != nil {
+		return nil, err
+	}
+	runs, err := store.GetRuns(orgID, hash)
+	if err != nil {
+		return nil, err
+	}
+	r := build(hash, messages, runs)
+	// Messages can be sent to domains which aren't registered, in which case
+	// we don't know the domain name.
+	domain, err := store.GetDomain(orgID, hash)
+	if err == nil {
+		r.Domain = domain.Name
+	}
+	return r, nil
+}
+
+// build scores the messages, which must be ordered oldest first.
+func build(hash string, messages []db.Message, runs []db.Run) *Report {
+	r := &Report{
+		DomainHash:  hash,
+		GeneratedAt: time.Now().UTC(),
+		Items:       scenarioItems(messages),
+		Transport:   transportItems(messages),
+		Trend:       []TrendPoint{},
+	}
+	r.Score = score(append(r.Items, r.Transport...))
+
+	byRun := map[uint][]db.Message{}
+	for _, m := range messages {
+		if m.RunID != 0 {
+			byRun[m.RunID] = append(byRun[m.RunID], m)
+		}
+	}
+	// Runs are returned most recent first, but trends read oldest first.
+	for i := len(runs) - 1; i >= 0; i-- {
+		items := scenarioItems(byRun[runs[i].ID])
+		if len(items) == 0 {
+			continue
+		}
+		r.Trend = append(r.Trend, TrendPoint{
+			RunID:     runs[i].ID,
+			CreatedAt: runs[i].CreatedAt,
+			Score:     score(items),
+		})
+	}
+	return r
+}
+
+// outcome returns what happened to the message, or an empty string if the
+// message hasn't been delivered or rejected (yet).
+func outcome(m db.Message) string {
+	switch m.Status {
+	case db.StatusRejected:
+		return OutcomeRejected
+	case db.StatusSent:
+		return OutcomeAccepted
+	case db.StatusReceived:
+		return OutcomeInbox
+	}
+	return ""
+}
+
+// scenarioItems scores the latest conclusive message sent for each scenario,
+// in the order the scenarios are defined. Messages are graded the same way
+// as in exports.
+func scenarioItems(messages []db.Message) []Item {
+	latest := map[string]db.Message{}
+	for _, m := range messages {
+		if m.Scenario != "" && outcome(m) != "" {
+			latest[m.Scenario] = m
+		}
+	}
+	items := []Item{}
+	for _, s := range db.Scenarios {
+		m, ok := latest[s.Name]
+		if !ok {
+			continue
+		}
+		item := Item{
+			Name:      s.Name,
+			Category:  s.Category,
+			Expected:  OutcomeInbox,
+			Outcome:   outcome(m),
+			MessageID: m.MessageID,
+		}
+		switch {
+		case s.Quarantine:
+			item.Expected = OutcomeQuarantined
+		case s.Blocked:
+			item.Expected = OutcomeRejected
+		}
+		item.Result = s.Grade(&m)
+		if item.Result != ResultPass {
+			item.Remediation = s.Remediation
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// transportItems scores the TLS posture recorded by the latest message sent
+// over STARTTLS.
+func transportItems(messages []db.Message) []Item {
+	var m *db.Message
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].TLSPolicy != smtp.TLSNone && outcome(messages[i]) != "" {
+			m = &messages[i]
+			break
+		}
+	}
+	if m == nil {
+		return []Item{}
+	}
+	tls := m.TLS
+	items := []Item{}
+	add := func(name, expected, got, result, remediation string) {
+		item := Item{
+			Name:      name,
+			Category:  db.CategoryTransport,
+			Expected:  expected,
+			Outcome:   got,
+			Result:    result,
+			MessageID: m.MessageID,
+		}
+		if result != ResultPass {
+			item.Remediation = remediation
+		}
+		items = append(items, item)
+	}
+
+	if !tls.Offered {
+		add("starttls", "offered", "not offered", ResultFail,
+			"Enable STARTTLS on your mail server so that mail can be delivered encrypted.")
+		return items
+	}
+	add("starttls", "offered", "offered", ResultPass, "")
+
+	certificate, result := "valid", ResultPass
+	if !tls.CertificateValid || !tls.NameMatch {
+		certificate, result = "invalid", ResultFail
+	}
+	add("certificate", "valid", certificate, result,
+		"Install a certificate issued by a trusted certificate authority which matches your MX hostname.")
+
+	legacy, result := "disabled", ResultPass
+	if tls.LegacyProtocols != "" {
+		legacy, result = tls.LegacyProtocols, ResultWarn
+	}
+	add("legacy_protocols", "disabled", legacy, result,
+		"Disable TLS 1.0 and TLS 1.1 on your mail server.")
+
+	// DANE isn't checked unless a DNSSEC-validating resolver is configured
+	if tls.DANE != "" {
+		switch tls.DANE {
+		case smtp.DANEUsable:
+			result = ResultPass
+		case smtp.DANEMismatch:
+			result = ResultFail
+		default:
+			result = ResultWarn
+		}
+		add("dane", smtp.DANEUsable, tls.DANE, result,
+			"Sign your zone with DNSSEC and publish a TLSA record for your MX hosts matching their certificate.")
+	}
+
+	switch tls.MTASTS {
+	case smtp.MTASTSEnforce:
+		result = ResultPass
+	default:
+		result = ResultWarn
+	}
+	add("mta_sts", smtp.MTASTSEnforce, tls.MTASTS, result,
+		"Publish an MTA-STS policy in enforce mode, along with the _mta-sts TXT record.")
+	return items
+}
+
+// score returns the percentage of items passed, counting warnings as half a
+// pass. A report without any items scores zero.
+func score(items []Item) float64 {
+	if len(items) == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, item := range items {
+		switch item.Result {
+		case ResultPass:
+			total++
+		case ResultWarn:
+			total += 0.5
+		}
+	}
+	return float64(int(total/float64(len(items))*1000)) / 10
+}
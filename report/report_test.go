@@ -0,0 +1,93 @@
+package report
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gophish/healthcheck/db"
+	"github.com/gophish/healthcheck/smtp"
+)
+
+func TestScenarioItems(t *testing.T) {
+	messages := []db.Message{
+		{MessageID: "1", Scenario: "baseline", Status: db.StatusReceived},
+		{MessageID: "2", Scenario: "spf_hardfail", Status: db.StatusSent},
+		{MessageID: "3", Scenario: "spf_hardfail", Status: db.StatusRejected},
+		{MessageID: "4", Scenario: "spf_softfail", Status: db.StatusReceived},
+		// Quarantined messages are accepted, but never reach the inbox
+		{MessageID: "5", Scenario: "dmarc_quarantine", Status: db.StatusSent},
+		// Messages without a conclusive outcome are ignored
+		{MessageID: "6", Scenario: "dmarc_quarantine", Status: db.StatusDeferred},
+		{MessageID: "7", Scenario: "no_mx", Status: db.StatusSent},
+	}
+	expected := map[string]string{
+		"baseline":         ResultPass,
+		"spf_hardfail":     ResultPass,
+		"spf_softfail":     ResultFail,
+		"dmarc_quarantine": ResultPass,
+		"no_mx":            ResultWarn,
+	}
+	items := scenarioItems(messages)
+	if len(items) != len(expected) {
+		t.Fatalf("Unexpected number of items. Got %d Expected %d", len(items), len(expected))
+	}
+	for _, item := range items {
+		if item.Result != expected[item.Name] {
+			t.Fatalf("Unexpected result for %s. Got %s Expected %s", item.Name, item.Result, expected[item.Name])
+		}
+		if item.Result == ResultPass && item.Remediation != "" {
+			t.Fatalf("Unexpected remediation for passing item %s", item.Name)
+		}
+		if item.Result != ResultPass && item.Remediation == "" {
+			t.Fatalf("Missing remediation for %s item %s", item.Result, item.Name)
+		}
+	}
+}
+
+func TestTransportItems(t *testing.T) {
+	m := db.Message{MessageID: "1", Status: db.StatusSent}
+	m.TLS = smtp.TLSReport{Offered: true}
+	items := transportItems([]db.Message{m})
+	results := map[string]string{}
+	for _, item := range items {
+		results[item.Name] = item.Result
+	}
+	if results["starttls"] != ResultPass || results["certificate"] != ResultFail {
+		t.Fatalf("Unexpected transport results: %v", results)
+	}
+
+	m.TLS = smtp.TLSReport{}
+	items = transportItems([]db.Message{m})
+	if len(items) != 1 || items[0].Result != ResultFail {
+		t.Fatalf("Unexpected transport items without STARTTLS: %#v", items)
+	}
+
+	// Messages deliberately sent in cleartext don't count
+	m.TLSPolicy = smtp.TLSNone
+	items = transportItems([]db.Message{m})
+	if len(items) != 0 {
+		t.Fatalf("Unexpected transport items for cleartext message: %#v", items)
+	}
+}
+
+func TestBuildTrend(t *testing.T) {
+	messages := []db.Message{
+		{MessageID: "1", RunID: 1, Scenario: "spf_hardfail", Status: db.StatusSent, MessageConfiguration: db.MessageConfiguration{TLSPolicy: smtp.TLSNone}},
+		{MessageID: "2", RunID: 2, Scenario: "spf_hardfail", Status: db.StatusRejected, MessageConfiguration: db.MessageConfiguration{TLSPolicy: smtp.TLSNone}},
+	}
+	now := time.Now()
+	runs := []db.Run{
+		{ID: 2, CreatedAt: now},
+		{ID: 1, CreatedAt: now.Add(-time.Hour)},
+	}
+	r := build("hash", messages, runs)
+	if r.Score != 100 {
+		t.Fatalf("Unexpected score. Got %v Expected 100", r.Score)
+	}
+	if len(r.Trend) != 2 {
+		t.Fatalf("Unexpected number of trend points. Got %d Expected 2", len(r.Trend))
+	}
+	if r.Trend[0].RunID != 1 || r.Trend[0].Score != 50 || r.Trend[1].Score != 100 {
+		t.Fatalf("Unexpected trend: %#v", r.Trend)
+	}
+}
@@ -0,0 +1,70 @@
+package retention
+
+import (
+	"context"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/gophish/healthcheck/db"
+)
+
+// DefaultPollInterval is how often the retention policy is applied.
+const DefaultPollInterval = time.Hour
+
+// Worker periodically deletes the messages and personal data which are
+// older than the configured retention periods.
+type Worker struct {
+	PollInterval time.Duration
+	// Store is where the expired data is deleted from. It must be set
+	// before the worker is started.
+	Store db.Store
+}
+
+// Purger is the global worker used to apply the retention policy
+var Purger = NewWorker()
+
+// NewWorker returns a new retention worker with the default poll interval
+func NewWorker() *Worker {
+	return &Worker{
+		PollInterval: DefaultPollInterval,
+	}
+}
+
+// Start applies the retention policy when started and then every poll
+// interval until the context is cancelled.
+func (w *Worker) Start(ctx context.Context) {
+	w.Purge(time.Now().UTC())
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-ticker.C:
+			w.Purge(t.UTC())
+		}
+	}
+}
+
+// Purge deletes the messages, personal data and dashboard sessions which
+// expired at t.
+func (w *Worker) Purge(t time.Time) {
+	if cutoff := db.MessageCutoff(t); !cutoff.IsZero() {
+		deleted, err := w.Store.DeleteMessagesBefore(cutoff)
+		if err != nil {
+			log.Errorf("error deleting expired messages: %v", err)
+		} else if deleted > 0 {
+			log.Infof("Deleted %d messages created before %s", deleted, cutoff.Format(time.RFC3339))
+		}
+	}
+	if cutoff := db.PersonalDataCutoff(t); !cutoff.IsZero() {
+		err := w.Store.PurgePersonalDataBefore(cutoff)
+		if err != nil {
+			log.Errorf("error purging expired personal data: %v", err)
+		}
+	}
+	err := w.Store.DeleteSessionsBefore(t)
+	if err != nil {
+		log.Errorf("error deleting expired sessions: %v", err)
+	}
+}
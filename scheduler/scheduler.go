@@ -0,0 +1,90 @@
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/gophish/healthcheck/db"
+	"github.com/gophish/healthcheck/mail"
+)
+
+// DefaultPollInterval is how often the scheduler checks for schedules which
+// are due.
+const DefaultPollInterval = time.Minute
+
+// Worker periodically launches a run for every schedule which is due.
+type Worker struct {
+	PollInterval time.Duration
+	// Store is where the schedules are read from and the runs are saved.
+	// It must be set before the worker is started.
+	Store db.Store
+}
+
+// Scheduler is the global worker used to launch scheduled runs
+var Scheduler = NewWorker()
+
+// NewWorker returns a new scheduler worker with the default poll interval
+func NewWorker() *Worker {
+	return &Worker{
+		PollInterval: DefaultPollInterval,
+	}
+}
+
+// Start launches the due schedules every poll interval until the context is
+// cancelled.
+func (w *Worker) Start(ctx context.Context) {
+	ticker := time.NewTicker(w.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case t := <-ticker.C:
+			w.launchDue(t.UTC())
+		}
+	}
+}
+
+func (w *Worker) launchDue(t time.Time) {
+	schedules, err := w.Store.GetDueSchedules(t)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	for i := range schedules {
+		s := &schedules[i]
+		err = w.launch(s)
+		if err != nil {
+			log.Errorf("error launching schedule %d: %v", s.ID, err)
+		}
+		// We always move the schedule forward, even on error, so that a
+		// broken schedule doesn't run on every poll.
+		s.LastRunAt = &t
+		err = s.ScheduleNext(t)
+		if err != nil {
+			log.Errorf("error scheduling next run for schedule %d: %v", s.ID, err)
+			s.Paused = true
+		}
+		err = w.Store.PutSchedule(s)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+func (w *Worker) launch(s *db.Schedule) error {
+	domain, err := w.Store.GetDomain(s.OrgID, s.DomainHash)
+	if err != nil {
+		return err
+	}
+	if !domain.Verified {
+		return db.ErrDomainNotVerified
+	}
+	r := &db.Run{
+		OrgID:      s.OrgID,
+		DomainHash: s.DomainHash,
+		ScheduleID: s.ID,
+	}
+	return mail.SendRun(w.Store, r, s.Recipient, s.MailServer, s.ScenarioNames)
+}
@@ -0,0 +1,202 @@
+package smtp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// TLSOpportunistic uses STARTTLS if the server offers it, falling back to
+	// cleartext otherwise. This is the default policy.
+	TLSOpportunistic = "opportunistic"
+	// TLSRequire aborts delivery if the server doesn't offer STARTTLS or if
+	// the TLS handshake fails.
+	TLSRequire = "require"
+	// TLSNone deliberately sends the message in cleartext, even if the server
+	// offers STARTTLS. This tests whether the server demands TLS.
+	TLSNone = "none"
+)
+
+// ErrTLSNotOffered occurs when TLS is required but the server doesn't offer
+// STARTTLS.
+var ErrTLSNotOffered = errors.New("server does not offer STARTTLS")
+
+// legacyProtocols are the protocol versions we check the server still
+// supports. These are considered insecure.
+var legacyProtocols = []uint16{tls.VersionTLS10, tls.VersionTLS11}
+
+// TLSReport describes the transport security of a delivery attempt.
+type TLSReport struct {
+	Offered          bool   `json:"offered"`
+	Used             bool   `json:"used"`
+	Version          string `json:"version"`
+	CipherSuite      string `json:"cipher_suite"`
+	CertificateValid bool   `json:"certificate_valid"`
+	NameMatch        bool   `json:"name_match"`
+	CertificateError string `json:"certificate_error,omitempty"`
+	LegacyProtocols  string `json:"legacy_protocols"`
+	DANE             string `json:"dane"`
+	MTASTS           string `gorm:"column:mta_sts" json:"mta_sts"`
+
+	// Certificates is the chain presented by the server during STARTTLS
+	Certificates []*x509.Certificate `gorm:"-" json:"-"`
+}
+
+// record fills in the report from the negotiated connection state. We verify
+// the certificate ourselves so that an invalid certificate is reported
+// instead of aborting the delivery.
+func (r *TLSReport) record(state tls.ConnectionState, host string) {
+	r.Used = true
+	r.Version = tls.VersionName(state.Version)
+	r.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	r.Certificates = state.PeerCertificates
+	if len(state.PeerCertificates) == 0 {
+		r.CertificateError = "no certificate presented"
+		return
+	}
+	leaf := state.PeerCertificates[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{Intermediates: intermediates})
+	r.CertificateValid = err == nil
+	if err != nil {
+		r.CertificateError = err.Error()
+	}
+	err = leaf.VerifyHostname(host)
+	r.NameMatch = err == nil
+	if err != nil && r.CertificateError == "" {
+		r.CertificateError = err.Error()
+	}
+}
+
+// Client is an SMTP client which records the TLS properties of the
+// connection. It implements the mailer.Sender interface.
+type Client struct {
+	client *smtp.Client
+}
+
+// Dial connects to the mail server, negotiating STARTTLS according to the
+// provided policy and recording the outcome in the report.
+func Dial(host string, port int, localName, policy string, report *TLSReport) (*Client, error) {
+	c, err := hello(host, port, localName)
+	if err != nil {
+		return nil, err
+	}
+	report.Offered, _ = c.Extension("STARTTLS")
+	switch {
+	case policy == TLSNone:
+	case report.Offered:
+		err = c.StartTLS(&tls.Config{ServerName: host, InsecureSkipVerify: true})
+		if err != nil {
+			if policy == TLSRequire {
+				c.Close()
+				return nil, err
+			}
+			// The failed handshake leaves the connection unusable, so we
+			// reconnect and fall back to cleartext.
+			report.CertificateError = err.Error()
+			c.Close()
+			c, err = hello(host, port, localName)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		state, _ := c.TLSConnectionState()
+		report.record(state, host)
+		report.LegacyProtocols = strings.Join(probeLegacyProtocols(host, port, localName), ",")
+	case policy == TLSRequire:
+		c.Close()
+		return nil, ErrTLSNotOffered
+	}
+	return &Client{client: c}, nil
+}
+
+// hello connects to the mail server and sends the EHLO command.
+func hello(host string, port int, localName string) (*smtp.Client, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(Timeout))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if localName != "" {
+		err = c.Hello(localName)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// probeLegacyProtocols returns the names of the legacy protocol versions the
+// server accepts during STARTTLS.
+func probeLegacyProtocols(host string, port int, localName string) []string {
+	supported := []string{}
+	for _, version := range legacyProtocols {
+		c, err := hello(host, port, localName)
+		if err != nil {
+			continue
+		}
+		err = c.StartTLS(&tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: true,
+			MinVersion:         version,
+			MaxVersion:         version,
+		})
+		if err == nil {
+			supported = append(supported, tls.VersionName(version))
+			c.Quit()
+		}
+		c.Close()
+	}
+	return supported
+}
+
+// Send sends the message to the provided recipients.
+func (c *Client) Send(from string, to []string, msg io.WriterTo) error {
+	err := c.client.Mail(from)
+	if err != nil {
+		return err
+	}
+	for _, addr := range to {
+		err = c.client.Rcpt(addr)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := c.client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = msg.WriteTo(w)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// Reset aborts the current mail transaction.
+func (c *Client) Reset() error {
+	return c.client.Reset()
+}
+
+// Close ends the SMTP session.
+func (c *Client) Close() error {
+	return c.client.Quit()
+}
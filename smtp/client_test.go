@@ -0,0 +1,190 @@
+package smtp
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTLSServer is a local SMTP stand-in which offers STARTTLS using a self
+// signed certificate for 127.0.0.1.
+type startTLSServer struct {
+	listener net.Listener
+	config   *tls.Config
+}
+
+func newStartTLSServer(t *testing.T, minVersion uint16) *startTLSServer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unexpected error creating certificate: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error starting SMTP server: %v", err)
+	}
+	s := &startTLSServer{
+		listener: l,
+		config: &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+			MinVersion:   minVersion,
+		},
+	}
+	go s.serve()
+	return s
+}
+
+func (s *startTLSServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *startTLSServer) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	r := bufio.NewReader(conn)
+	conn.Write([]byte("220 localhost ESMTP\r\n"))
+	secure := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(strings.TrimSpace(line)) {
+		case "STARTTLS":
+			conn.Write([]byte("220 Ready to start TLS\r\n"))
+			tlsConn := tls.Server(conn, s.config)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, r, secure = tlsConn, bufio.NewReader(tlsConn), true
+		case "QUIT":
+			conn.Write([]byte("221 Bye\r\n"))
+			return
+		default:
+			if strings.HasPrefix(strings.ToUpper(line), "EHLO") && !secure {
+				conn.Write([]byte("250-localhost\r\n250 STARTTLS\r\n"))
+				continue
+			}
+			conn.Write([]byte("250 OK\r\n"))
+		}
+	}
+}
+
+func (s *startTLSServer) Close() {
+	s.listener.Close()
+}
+
+func (s *startTLSServer) port() int {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+func dialLenientServer(t *testing.T, s *lenientServer, policy string, report *TLSReport) (*Client, error) {
+	host, portStr, _ := net.SplitHostPort(s.listener.Addr().String())
+	port, _ := strconv.Atoi(portStr)
+	return Dial(host, port, "example.com", policy, report)
+}
+
+func TestDialRequireTLS(t *testing.T) {
+	s := newLenientServer(t)
+	defer s.Close()
+	report := &TLSReport{}
+	_, err := dialLenientServer(t, s, TLSRequire, report)
+	if err != ErrTLSNotOffered {
+		t.Fatalf("Didn't receive expected error when requiring TLS. Got: %v", err)
+	}
+	if report.Offered {
+		t.Fatalf("Unexpected STARTTLS offered in report")
+	}
+}
+
+func TestDialOpportunisticTLS(t *testing.T) {
+	s := newLenientServer(t)
+	defer s.Close()
+	report := &TLSReport{}
+	c, err := dialLenientServer(t, s, TLSOpportunistic, report)
+	if err != nil {
+		t.Fatalf("Unexpected error when dialing: %v", err)
+	}
+	defer c.Close()
+	if report.Used {
+		t.Fatalf("Unexpected TLS used in report")
+	}
+	msg := strings.NewReader("Subject: Test\r\n\r\nTest")
+	err = c.Send("no-reply@example.com", []string{"test@example.org"}, msg)
+	if err != nil {
+		t.Fatalf("Unexpected error when sending message: %v", err)
+	}
+}
+
+func TestDialStartTLS(t *testing.T) {
+	s := newStartTLSServer(t, tls.VersionTLS12)
+	defer s.Close()
+	report := &TLSReport{}
+	c, err := Dial("127.0.0.1", s.port(), "example.com", TLSRequire, report)
+	if err != nil {
+		t.Fatalf("Unexpected error when dialing: %v", err)
+	}
+	defer c.Close()
+	if !report.Offered || !report.Used {
+		t.Fatalf("Expected STARTTLS to be offered and used. Got %+v", report)
+	}
+	if !strings.HasPrefix(report.Version, "TLS 1.") {
+		t.Fatalf("Unexpected TLS version: %s", report.Version)
+	}
+	if !strings.HasPrefix(report.CipherSuite, "TLS_") {
+		t.Fatalf("Unexpected cipher suite: %s", report.CipherSuite)
+	}
+	// The certificate is self signed, but matches the host we connected to
+	if report.CertificateValid || !report.NameMatch || report.CertificateError == "" {
+		t.Fatalf("Unexpected certificate report: %+v", report)
+	}
+	if len(report.Certificates) != 1 {
+		t.Fatalf("Unexpected number of certificates. Expected 1 Got %d", len(report.Certificates))
+	}
+	if report.LegacyProtocols != "" {
+		t.Fatalf("Unexpected legacy protocols: %s", report.LegacyProtocols)
+	}
+}
+
+func TestDialLegacyProtocols(t *testing.T) {
+	s := newStartTLSServer(t, tls.VersionTLS10)
+	defer s.Close()
+	report := &TLSReport{}
+	c, err := Dial("127.0.0.1", s.port(), "example.com", TLSOpportunistic, report)
+	if err != nil {
+		t.Fatalf("Unexpected error when dialing: %v", err)
+	}
+	defer c.Close()
+	if report.LegacyProtocols != "TLS 1.0,TLS 1.1" {
+		t.Fatalf("Unexpected legacy protocols. Expected TLS 1.0,TLS 1.1 Got %s", report.LegacyProtocols)
+	}
+}
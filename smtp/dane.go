@@ -0,0 +1,151 @@
+package smtp
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ErrMissingResolver occurs when DANE is checked without a DNSSEC-validating
+// resolver. We don't fall back to a local resolver, since it may well be our
+// own DNS server, which doesn't validate anything.
+var ErrMissingResolver = errors.New("no DNSSEC-validating resolver configured")
+
+const (
+	// DANEUsable indicates the server has DNSSEC-signed TLSA records which
+	// match the presented certificate.
+	DANEUsable = "usable"
+	// DANEMismatch indicates the server has DNSSEC-signed TLSA records, but
+	// none match the presented certificate (or no TLS was negotiated).
+	DANEMismatch = "mismatch"
+	// DANENoTLSA indicates the server has no usable TLSA records.
+	DANENoTLSA = "no_tlsa"
+	// DANEInsecure indicates TLSA records exist but weren't validated with
+	// DNSSEC, so they can't be used.
+	DANEInsecure = "insecure"
+)
+
+// DNSTimeout is how long we wait for an answer from the DNSSEC-validating
+// resolver.
+const DNSTimeout = 5 * time.Second
+
+// TLSA certificate usages defined in RFC 6698. Per RFC 7672, only DANE-TA and
+// DANE-EE are usable for SMTP.
+const (
+	tlsaUsageDANETA = 2
+	tlsaUsageDANEEE = 3
+)
+
+// exchange sends a DNSSEC-enabled query to the resolver, returning the
+// answer if the name exists.
+func exchange(resolver, name string, qtype uint16) (*dns.Msg, error) {
+	if resolver == "" {
+		return nil, ErrMissingResolver
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.SetEdns0(4096, true)
+	m.RecursionDesired = true
+	c := &dns.Client{Net: "tcp", Timeout: DNSTimeout}
+	r, _, err := c.Exchange(m, resolver)
+	if err != nil {
+		return nil, err
+	}
+	switch r.Rcode {
+	case dns.RcodeSuccess, dns.RcodeNameError:
+	default:
+		return nil, fmt.Errorf("%s lookup for %s failed: %s", dns.TypeToString[qtype], name, dns.RcodeToString[r.Rcode])
+	}
+	return r, nil
+}
+
+// LookupMX looks up the MX hosts of the domain using the provided resolver,
+// most preferred first. It also returns whether the answer was
+// DNSSEC-validated, since DANE only applies to mail servers found through a
+// validated MX lookup.
+func LookupMX(resolver, domain string) ([]string, bool, error) {
+	r, err := exchange(resolver, dns.Fqdn(domain), dns.TypeMX)
+	if err != nil {
+		return nil, false, err
+	}
+	mxs := []*dns.MX{}
+	for _, rr := range r.Answer {
+		if mx, ok := rr.(*dns.MX); ok {
+			mxs = append(mxs, mx)
+		}
+	}
+	sort.SliceStable(mxs, func(i, j int) bool {
+		return mxs[i].Preference < mxs[j].Preference
+	})
+	hosts := []string{}
+	for _, mx := range mxs {
+		hosts = append(hosts, strings.TrimSuffix(mx.Mx, "."))
+	}
+	return hosts, r.AuthenticatedData, nil
+}
+
+// LookupTLSA looks up the TLSA records for the mail server using the
+// provided resolver, returning whether the answer was DNSSEC-validated.
+func LookupTLSA(resolver, host string, port int) ([]*dns.TLSA, bool, error) {
+	records := []*dns.TLSA{}
+	name := dns.Fqdn(fmt.Sprintf("_%s._tcp.%s", strconv.Itoa(port), host))
+	r, err := exchange(resolver, name, dns.TypeTLSA)
+	if err != nil {
+		return records, false, err
+	}
+	for _, rr := range r.Answer {
+		if tlsa, ok := rr.(*dns.TLSA); ok {
+			records = append(records, tlsa)
+		}
+	}
+	return records, r.AuthenticatedData, nil
+}
+
+// VerifyDANE looks up the TLSA records for the mail server and checks them
+// against the certificate chain presented during STARTTLS.
+func VerifyDANE(resolver, host string, port int, certs []*x509.Certificate) (string, error) {
+	records, secure, err := LookupTLSA(resolver, host, port)
+	if err != nil {
+		return "", err
+	}
+	if len(records) == 0 {
+		return DANENoTLSA, nil
+	}
+	if !secure {
+		return DANEInsecure, nil
+	}
+	return matchTLSA(records, certs), nil
+}
+
+// matchTLSA checks the DNSSEC-validated TLSA records against the certificate
+// chain. DANE-EE records must match the leaf certificate, while DANE-TA
+// records may match any certificate in the chain.
+func matchTLSA(records []*dns.TLSA, certs []*x509.Certificate) string {
+	usable := false
+	for _, record := range records {
+		switch record.Usage {
+		case tlsaUsageDANEEE:
+			usable = true
+			if len(certs) > 0 && record.Verify(certs[0]) == nil {
+				return DANEUsable
+			}
+		case tlsaUsageDANETA:
+			usable = true
+			for _, cert := range certs {
+				if record.Verify(cert) == nil {
+					return DANEUsable
+				}
+			}
+		}
+	}
+	if !usable {
+		return DANENoTLSA
+	}
+	return DANEMismatch
+}
@@ -0,0 +1,117 @@
+package smtp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func createCertificate(t *testing.T) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error generating key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mx.example.com"},
+		DNSNames:     []string{"mx.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unexpected error creating certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Unexpected error parsing certificate: %v", err)
+	}
+	return cert
+}
+
+func createTLSA(usage uint8, cert *x509.Certificate) *dns.TLSA {
+	// SPKI selector with a SHA-256 matching type
+	digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return &dns.TLSA{
+		Usage:        usage,
+		Selector:     1,
+		MatchingType: 1,
+		Certificate:  hex.EncodeToString(digest[:]),
+	}
+}
+
+func TestMatchTLSA(t *testing.T) {
+	cert := createCertificate(t)
+	other := createCertificate(t)
+	testSuite := []struct {
+		name     string
+		records  []*dns.TLSA
+		certs    []*x509.Certificate
+		expected string
+	}{
+		{"DANE-EE match", []*dns.TLSA{createTLSA(tlsaUsageDANEEE, cert)}, []*x509.Certificate{cert}, DANEUsable},
+		{"DANE-EE mismatch", []*dns.TLSA{createTLSA(tlsaUsageDANEEE, other)}, []*x509.Certificate{cert}, DANEMismatch},
+		{"DANE-TA match", []*dns.TLSA{createTLSA(tlsaUsageDANETA, other)}, []*x509.Certificate{cert, other}, DANEUsable},
+		{"No TLS", []*dns.TLSA{createTLSA(tlsaUsageDANEEE, cert)}, []*x509.Certificate{}, DANEMismatch},
+		{"PKIX only", []*dns.TLSA{createTLSA(1, cert)}, []*x509.Certificate{cert}, DANENoTLSA},
+	}
+	for _, test := range testSuite {
+		got := matchTLSA(test.records, test.certs)
+		if got != test.expected {
+			t.Fatalf("Unexpected DANE result for %s. Got %s Expected %s", test.name, got, test.expected)
+		}
+	}
+}
+
+// startResolver runs a local DNS server answering MX queries, setting the AD
+// bit if secure is true.
+func startResolver(t *testing.T, secure bool) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error starting DNS server: %v", err)
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.AuthenticatedData = secure
+		for _, mx := range []string{"20 backup.example.com.", "10 mx.example.com."} {
+			rr, _ := dns.NewRR(r.Question[0].Name + " 300 IN MX " + mx)
+			m.Answer = append(m.Answer, rr)
+		}
+		w.WriteMsg(m)
+	})
+	s := &dns.Server{Listener: l, Handler: handler}
+	go s.ActivateAndServe()
+	return l.Addr().String(), func() { s.Shutdown() }
+}
+
+func TestLookupMX(t *testing.T) {
+	for _, secure := range []bool{true, false} {
+		resolver, stop := startResolver(t, secure)
+		hosts, got, err := LookupMX(resolver, "example.com")
+		stop()
+		if err != nil {
+			t.Fatalf("Unexpected error looking up MX: %v", err)
+		}
+		if len(hosts) != 2 || hosts[0] != "mx.example.com" {
+			t.Fatalf("Unexpected MX hosts: %v", hosts)
+		}
+		if got != secure {
+			t.Fatalf("Unexpected DNSSEC validation. Expected %v Got %v", secure, got)
+		}
+	}
+	_, _, err := LookupMX("", "example.com")
+	if err != ErrMissingResolver {
+		t.Fatalf("Didn't receive expected error without a resolver. Got: %v", err)
+	}
+}
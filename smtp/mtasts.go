@@ -0,0 +1,147 @@
+package smtp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MTASTSEnforce indicates the domain publishes an MTA-STS policy in
+	// enforce mode
+	MTASTSEnforce = "enforce"
+	// MTASTSTesting indicates the domain publishes an MTA-STS policy in
+	// testing mode
+	MTASTSTesting = "testing"
+	// MTASTSNone indicates the domain doesn't publish an MTA-STS policy, or
+	// publishes one in none mode
+	MTASTSNone = "none"
+)
+
+// MaxMTASTSPolicySize is the maximum size of an MTA-STS policy we accept, per
+// RFC 8461.
+const MaxMTASTSPolicySize = 64 * 1024
+
+// MaxMTASTSMaxAge is the maximum lifetime of an MTA-STS policy, per RFC 8461.
+const MaxMTASTSMaxAge = 31557600 * time.Second
+
+// MTASTSTimeout is how long we wait when fetching an MTA-STS policy.
+const MTASTSTimeout = 10 * time.Second
+
+// MTASTSNoPolicyTTL is how long we remember that a domain doesn't publish an
+// MTA-STS policy.
+const MTASTSNoPolicyTTL = time.Hour
+
+var mtastsClient = &http.Client{Timeout: MTASTSTimeout}
+
+// mtastsPolicy is a cached MTA-STS mode
+type mtastsPolicy struct {
+	mode    string
+	expires time.Time
+}
+
+// mtastsCache holds the MTA-STS mode of the domains we deliver to, so that we
+// only fetch a domain's policy again once its max_age has passed.
+var mtastsCache = struct {
+	sync.Mutex
+	policies map[string]mtastsPolicy
+}{policies: map[string]mtastsPolicy{}}
+
+// fetchMTASTS looks up the MTA-STS policy of a domain, returning its mode and
+// how long it may be cached. It's a variable so that tests can replace it.
+var fetchMTASTS = fetchMTASTSPolicy
+
+var now = time.Now
+
+// LookupMTASTS returns the MTA-STS mode published by the recipient domain.
+// Policies are cached for the max_age they advertise.
+func LookupMTASTS(domain string) (string, error) {
+	domain = strings.ToLower(domain)
+	mtastsCache.Lock()
+	policy, ok := mtastsCache.policies[domain]
+	mtastsCache.Unlock()
+	if ok && now().Before(policy.expires) {
+		return policy.mode, nil
+	}
+	mode, maxAge, err := fetchMTASTS(domain)
+	if err != nil {
+		return "", err
+	}
+	mtastsCache.Lock()
+	defer mtastsCache.Unlock()
+	for d, p := range mtastsCache.policies {
+		if !now().Before(p.expires) {
+			delete(mtastsCache.policies, d)
+		}
+	}
+	mtastsCache.policies[domain] = mtastsPolicy{mode: mode, expires: now().Add(maxAge)}
+	return mode, nil
+}
+
+// fetchMTASTSPolicy looks up the _mta-sts TXT record of the domain and, if
+// found, fetches its policy.
+func fetchMTASTSPolicy(domain string) (string, time.Duration, error) {
+	records, err := net.LookupTXT(fmt.Sprintf("_mta-sts.%s", domain))
+	if err != nil {
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			return MTASTSNone, MTASTSNoPolicyTTL, nil
+		}
+		return "", 0, err
+	}
+	found := false
+	for _, record := range records {
+		if strings.HasPrefix(record, "v=STSv1") {
+			found = true
+		}
+	}
+	if !found {
+		return MTASTSNone, MTASTSNoPolicyTTL, nil
+	}
+	resp, err := mtastsClient.Get(fmt.Sprintf("https://mta-sts.%s/.well-known/mta-sts.txt", domain))
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("unexpected status code fetching MTA-STS policy: %d", resp.StatusCode)
+	}
+	mode, maxAge := parseMTASTSPolicy(io.LimitReader(resp.Body, MaxMTASTSPolicySize))
+	return mode, maxAge, nil
+}
+
+// parseMTASTSPolicy returns the mode set in the policy, defaulting to none if
+// the policy doesn't set a valid mode, along with its max_age. Policies
+// without a valid max_age are cached as if the domain had no policy.
+func parseMTASTSPolicy(r io.Reader) (string, time.Duration) {
+	mode, maxAge := MTASTSNone, MTASTSNoPolicyTTL
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(parts[1])
+		switch strings.TrimSpace(parts[0]) {
+		case "mode":
+			switch value {
+			case MTASTSEnforce, MTASTSTesting:
+				mode = value
+			}
+		case "max_age":
+			seconds, err := strconv.ParseUint(value, 10, 32)
+			if err == nil {
+				maxAge = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	if maxAge > MaxMTASTSMaxAge {
+		maxAge = MaxMTASTSMaxAge
+	}
+	return mode, maxAge
+}
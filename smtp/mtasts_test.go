@@ -0,0 +1,72 @@
+package smtp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMTASTSPolicy(t *testing.T) {
+	testSuite := map[string]string{
+		"version: STSv1\r\nmode: enforce\r\nmx: mail.example.com\r\nmax_age: 86400\r\n": MTASTSEnforce,
+		"version: STSv1\nmode: testing\nmx: mail.example.com\n":                         MTASTSTesting,
+		"version: STSv1\nmode: none\n":                                                  MTASTSNone,
+		"version: STSv1\nmode: invalid\n":                                               MTASTSNone,
+		"":                                                                              MTASTSNone,
+	}
+	for policy, expected := range testSuite {
+		got, _ := parseMTASTSPolicy(strings.NewReader(policy))
+		if got != expected {
+			t.Fatalf("Unexpected MTA-STS mode for policy %q. Got %s Expected %s", policy, got, expected)
+		}
+	}
+}
+
+func TestParseMTASTSMaxAge(t *testing.T) {
+	testSuite := map[string]time.Duration{
+		"version: STSv1\nmode: enforce\nmax_age: 86400\n":     24 * time.Hour,
+		"version: STSv1\nmode: enforce\nmax_age: 999999999\n": MaxMTASTSMaxAge,
+		"version: STSv1\nmode: enforce\nmax_age: soon\n":      MTASTSNoPolicyTTL,
+		"version: STSv1\nmode: enforce\n":                     MTASTSNoPolicyTTL,
+	}
+	for policy, expected := range testSuite {
+		_, got := parseMTASTSPolicy(strings.NewReader(policy))
+		if got != expected {
+			t.Fatalf("Unexpected max_age for policy %q. Got %s Expected %s", policy, got, expected)
+		}
+	}
+}
+
+func TestLookupMTASTSCache(t *testing.T) {
+	current := time.Now()
+	fetches := 0
+	defer func(fetch func(string) (string, time.Duration, error), clock func() time.Time) {
+		fetchMTASTS, now = fetch, clock
+	}(fetchMTASTS, now)
+	fetchMTASTS = func(domain string) (string, time.Duration, error) {
+		fetches++
+		return MTASTSEnforce, time.Hour, nil
+	}
+	now = func() time.Time { return current }
+
+	for i := 0; i < 2; i++ {
+		mode, err := LookupMTASTS("Cache.Example.com")
+		if err != nil {
+			t.Fatalf("Unexpected error looking up MTA-STS: %v", err)
+		}
+		if mode != MTASTSEnforce {
+			t.Fatalf("Unexpected MTA-STS mode. Expected %s Got %s", MTASTSEnforce, mode)
+		}
+	}
+	if fetches != 1 {
+		t.Fatalf("Unexpected number of policy fetches. Expected 1 Got %d", fetches)
+	}
+	current = current.Add(time.Hour)
+	_, err := LookupMTASTS("cache.example.com")
+	if err != nil {
+		t.Fatalf("Unexpected error looking up MTA-STS: %v", err)
+	}
+	if fetches != 2 {
+		t.Fatalf("Expected the policy to be fetched again after max_age. Got %d fetches", fetches)
+	}
+}
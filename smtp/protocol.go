@@ -0,0 +1,344 @@
+package smtp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPort is the SMTP port used when the mail server doesn't specify one
+const DefaultPort = 25
+
+// DefaultTimeout is the maximum amount of time spent in a single SMTP session
+const DefaultTimeout = 30 * time.Second
+
+// DialTimeout is how long we wait to connect to a mail server.
+var DialTimeout = DefaultTimeout
+
+// Timeout is the maximum amount of time spent in a single SMTP session.
+var Timeout = DefaultTimeout
+
+// MaxLineLength is the maximum length of a line (excluding the CRLF) allowed
+// by RFC 5322.
+const MaxLineLength = 998
+
+// extraReplyWait is how long we wait for unsolicited replies after sending the
+// end of data. Receiving any means the server parsed our smuggled commands.
+var extraReplyWait = 2 * time.Second
+
+// ErrUnknownProtocolTest occurs when an unknown protocol test is requested.
+var ErrUnknownProtocolTest = errors.New("unknown protocol test")
+
+// Envelope holds the identities used during an SMTP session.
+type Envelope struct {
+	Hostname string
+	From     string
+	To       string
+}
+
+// ProtocolResult is the outcome of sending a single malformed sequence to the
+// target mail server.
+type ProtocolResult struct {
+	Name     string   `json:"name"`
+	Sequence string   `json:"sequence"`
+	Accepted bool     `json:"accepted"`
+	Smuggled bool     `json:"smuggled"`
+	Replies  []string `json:"replies"`
+	Error    string   `json:"error,omitempty"`
+}
+
+type protocolTest struct {
+	name     string
+	sequence string
+	run      func(s *session, e Envelope, r *ProtocolResult) error
+}
+
+var protocolTests = []protocolTest{
+	smugglingTest("lf_dot_lf", "\n.\n"),
+	smugglingTest("lf_dot_crlf", "\n.\r\n"),
+	smugglingTest("crlf_dot_lf", "\r\n.\n"),
+	smugglingTest("cr_dot_lf", "\r.\n"),
+	smugglingTest("cr_dot_cr", "\r.\r"),
+	{
+		name:     "pipelining",
+		sequence: "MAIL, RCPT, DATA and the message in a single write",
+		run:      runPipelining,
+	},
+	{
+		name:     "long_line",
+		sequence: fmt.Sprintf("header line of %d octets", MaxLineLength*10),
+		run: func(s *session, e Envelope, r *ProtocolResult) error {
+			header := fmt.Sprintf("X-Healthcheck-Long: %s\r\n", strings.Repeat("a", MaxLineLength*10))
+			return s.sendData(e, r, header+message(e, "Long line test"))
+		},
+	},
+	{
+		name:     "long_command",
+		sequence: "MAIL FROM command longer than 512 octets",
+		run:      runLongCommand,
+	},
+	{
+		name:     "nul_header",
+		sequence: strconv.Quote("X-Healthcheck-Null: a\x00b"),
+		run: func(s *session, e Envelope, r *ProtocolResult) error {
+			return s.sendData(e, r, "X-Healthcheck-Null: a\x00b\r\n"+message(e, "NUL header test"))
+		},
+	},
+}
+
+// ProtocolTests returns the names of every available protocol test.
+func ProtocolTests() []string {
+	names := []string{}
+	for _, t := range protocolTests {
+		names = append(names, t.name)
+	}
+	return names
+}
+
+// Address returns the host:port address for the provided mail server,
+// adding the default SMTP port if none is set.
+func Address(server string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(server, strconv.Itoa(DefaultPort))
+}
+
+// RunProtocolTests opens a new SMTP session to the server for each of the
+// named protocol tests and reports which sequences were accepted. If no names
+// are provided, every test is run.
+func RunProtocolTests(server string, e Envelope, names []string) ([]ProtocolResult, error) {
+	tests := protocolTests
+	if len(names) > 0 {
+		tests = []protocolTest{}
+		for _, name := range names {
+			found := false
+			for _, t := range protocolTests {
+				if t.name == name {
+					tests = append(tests, t)
+					found = true
+				}
+			}
+			if !found {
+				return nil, ErrUnknownProtocolTest
+			}
+		}
+	}
+	results := []ProtocolResult{}
+	for _, t := range tests {
+		r := ProtocolResult{
+			Name:     t.name,
+			Sequence: t.sequence,
+			Replies:  []string{},
+		}
+		err := runProtocolTest(server, e, t, &r)
+		if err != nil {
+			r.Error = err.Error()
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func runProtocolTest(server string, e Envelope, t protocolTest, r *ProtocolResult) error {
+	s, err := dial(server, e.Hostname, r)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	return t.run(s, e, r)
+}
+
+// smugglingTest builds a test which ends the message data with the provided
+// terminator, followed by a second, smuggled message. Servers which treat
+// the terminator as the end of data reply to the smuggled commands as well.
+func smugglingTest(name, terminator string) protocolTest {
+	return protocolTest{
+		name:     name,
+		sequence: strconv.Quote(terminator),
+		run: func(s *session, e Envelope, r *ProtocolResult) error {
+			if err := s.startData(e, r); err != nil {
+				return err
+			}
+			data := message(e, "Smuggling test: "+name) + terminator +
+				fmt.Sprintf("MAIL FROM:<%s>\r\nRCPT TO:<%s>\r\nDATA\r\n", e.From, e.To) +
+				message(e, "Smuggled message: "+name) + "\r\n.\r\n"
+			if err := s.write(data); err != nil {
+				return err
+			}
+			code, err := s.reply(r)
+			if err != nil {
+				return err
+			}
+			r.Accepted = code >= 200 && code < 300
+			r.Smuggled = s.extraReplies(r) > 0
+			return nil
+		},
+	}
+}
+
+// runPipelining sends the whole transaction without waiting for any replies,
+// including the message data before the server replied to DATA.
+func runPipelining(s *session, e Envelope, r *ProtocolResult) error {
+	data := fmt.Sprintf("MAIL FROM:<%s>\r\nRCPT TO:<%s>\r\nDATA\r\n", e.From, e.To) +
+		message(e, "Pipelining test") + "\r\n.\r\n"
+	if err := s.write(data); err != nil {
+		return err
+	}
+	code := 0
+	for i := 0; i < 4; i++ {
+		var err error
+		code, err = s.reply(r)
+		if err != nil {
+			return err
+		}
+		if code >= 400 {
+			return nil
+		}
+	}
+	r.Accepted = code >= 200 && code < 300
+	return nil
+}
+
+func runLongCommand(s *session, e Envelope, r *ProtocolResult) error {
+	parts := strings.SplitN(e.From, "@", 2)
+	from := fmt.Sprintf("%s@%s", strings.Repeat("a", 600), parts[len(parts)-1])
+	code, err := s.command(r, "MAIL FROM:<%s>", from)
+	if err != nil {
+		return err
+	}
+	r.Accepted = code >= 200 && code < 300
+	return nil
+}
+
+// message returns a minimal message with the provided subject. The message
+// doesn't include the end of data sequence.
+func message(e Envelope, subject string) string {
+	return fmt.Sprintf("From: <%s>\r\nTo: <%s>\r\nSubject: %s\r\n\r\nGophish Healthcheck protocol test", e.From, e.To, subject)
+}
+
+// session is a raw SMTP session that lets us send arbitrary bytes to the
+// server, as opposed to net/smtp which sanitizes everything.
+type session struct {
+	conn net.Conn
+	text *textproto.Reader
+}
+
+func dial(server, hostname string, r *ProtocolResult) (*session, error) {
+	conn, err := net.DialTimeout("tcp", Address(server), DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(Timeout))
+	s := &session{
+		conn: conn,
+		text: textproto.NewReader(bufio.NewReader(conn)),
+	}
+	code, err := s.reply(r)
+	if err == nil && code != 220 {
+		err = fmt.Errorf("unexpected greeting: %d", code)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := s.write(fmt.Sprintf("EHLO %s\r\n", hostname)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	code, msg, err := s.text.ReadResponse(250)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	r.Replies = append(r.Replies, fmt.Sprintf("%d %s", code, msg))
+	return s, nil
+}
+
+func (s *session) write(data string) error {
+	_, err := s.conn.Write([]byte(data))
+	return err
+}
+
+// reply reads a single (possibly multiline) reply, recording it in the result.
+func (s *session) reply(r *ProtocolResult) (int, error) {
+	code, msg, err := s.text.ReadResponse(0)
+	if err != nil {
+		return code, err
+	}
+	r.Replies = append(r.Replies, fmt.Sprintf("%d %s", code, msg))
+	return code, nil
+}
+
+func (s *session) command(r *ProtocolResult, format string, args ...interface{}) (int, error) {
+	if err := s.write(fmt.Sprintf(format, args...) + "\r\n"); err != nil {
+		return 0, err
+	}
+	return s.reply(r)
+}
+
+// startData sends the envelope and the DATA command, returning an error if
+// the server doesn't let us send the message data.
+func (s *session) startData(e Envelope, r *ProtocolResult) error {
+	steps := []struct {
+		command string
+		code    int
+	}{
+		{fmt.Sprintf("MAIL FROM:<%s>", e.From), 250},
+		{fmt.Sprintf("RCPT TO:<%s>", e.To), 250},
+		{"DATA", 354},
+	}
+	for _, step := range steps {
+		code, err := s.command(r, "%s", step.command)
+		if err != nil {
+			return err
+		}
+		// RCPT TO can also be accepted with a 251 reply
+		if code/100 != step.code/100 {
+			return &textproto.Error{Code: code, Msg: r.Replies[len(r.Replies)-1]}
+		}
+	}
+	return nil
+}
+
+// sendData sends a full, correctly terminated message and records whether the
+// server accepted it.
+func (s *session) sendData(e Envelope, r *ProtocolResult, data string) error {
+	if err := s.startData(e, r); err != nil {
+		return err
+	}
+	if err := s.write(data + "\r\n.\r\n"); err != nil {
+		return err
+	}
+	code, err := s.reply(r)
+	if err != nil {
+		return err
+	}
+	r.Accepted = code >= 200 && code < 300
+	return nil
+}
+
+// extraReplies reads any unsolicited replies sent by the server, returning
+// how many were received.
+func (s *session) extraReplies(r *ProtocolResult) int {
+	count := 0
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(extraReplyWait))
+		if _, err := s.reply(r); err != nil {
+			break
+		}
+		count++
+	}
+	s.conn.SetDeadline(time.Now().Add(Timeout))
+	return count
+}
+
+func (s *session) close() error {
+	s.write("QUIT\r\n")
+	return s.conn.Close()
+}
@@ -0,0 +1,141 @@
+package smtp
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lenientServer is a local SMTP stand-in which, like many vulnerable servers,
+// accepts bare LFs as line endings.
+type lenientServer struct {
+	listener net.Listener
+}
+
+func newLenientServer(t *testing.T) *lenientServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error starting SMTP server: %v", err)
+	}
+	s := &lenientServer{listener: l}
+	go s.serve()
+	return s
+}
+
+func (s *lenientServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *lenientServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	conn.Write([]byte("220 localhost ESMTP\r\n"))
+	data := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if data {
+			if line == "." {
+				data = false
+				conn.Write([]byte("250 Queued\r\n"))
+			}
+			continue
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch {
+		case command == "EHLO":
+			conn.Write([]byte("250-localhost\r\n250 PIPELINING\r\n"))
+		case command == "DATA":
+			data = true
+			conn.Write([]byte("354 Go ahead\r\n"))
+		case command == "QUIT":
+			conn.Write([]byte("221 Bye\r\n"))
+			return
+		case len(line) > 512:
+			conn.Write([]byte("500 Line too long\r\n"))
+		default:
+			conn.Write([]byte("250 OK\r\n"))
+		}
+	}
+}
+
+func (s *lenientServer) Close() {
+	s.listener.Close()
+}
+
+func TestRunProtocolTests(t *testing.T) {
+	defer func(wait time.Duration) {
+		extraReplyWait = wait
+	}(extraReplyWait)
+	extraReplyWait = 100 * time.Millisecond
+	s := newLenientServer(t)
+	defer s.Close()
+	e := Envelope{
+		Hostname: "example.com",
+		From:     "no-reply@example.com",
+		To:       "test@example.org",
+	}
+	results, err := RunProtocolTests(s.listener.Addr().String(), e, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error running protocol tests: %v", err)
+	}
+	if len(results) != len(protocolTests) {
+		t.Fatalf("Unexpected number of results. Got %d Expected %d", len(results), len(protocolTests))
+	}
+	expected := map[string]struct {
+		accepted bool
+		smuggled bool
+	}{
+		"lf_dot_lf":    {true, true},
+		"crlf_dot_lf":  {true, true},
+		"cr_dot_cr":    {true, false},
+		"pipelining":   {true, false},
+		"long_line":    {true, false},
+		"long_command": {false, false},
+		"nul_header":   {true, false},
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			t.Fatalf("Unexpected error in %s test: %s", r.Name, r.Error)
+		}
+		want, ok := expected[r.Name]
+		if !ok {
+			continue
+		}
+		if r.Accepted != want.accepted || r.Smuggled != want.smuggled {
+			t.Fatalf("Unexpected %s result. Got accepted=%v smuggled=%v Expected accepted=%v smuggled=%v",
+				r.Name, r.Accepted, r.Smuggled, want.accepted, want.smuggled)
+		}
+	}
+}
+
+func TestUnknownProtocolTest(t *testing.T) {
+	_, err := RunProtocolTests("localhost", Envelope{}, []string{"invalid"})
+	if err != ErrUnknownProtocolTest {
+		t.Fatalf("Didn't receive expected error. Got: %v", err)
+	}
+}
+
+func TestAddress(t *testing.T) {
+	testSuite := map[string]string{
+		"localhost":      "localhost:25",
+		"localhost:1025": "localhost:1025",
+	}
+	for server, expected := range testSuite {
+		got := Address(server)
+		if got != expected {
+			t.Fatalf("Unexpected address for %s. Got %s Expected %s", server, got, expected)
+		}
+	}
+}
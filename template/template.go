@@ -2,6 +2,8 @@ package template
 
 import (
 	"bytes"
+	htmltemplate "html/template"
+	"path/filepath"
 	"text/template"
 )
 
@@ -13,6 +15,17 @@ const TextTemplate = "./template/templates/email_template.txt"
 // text/html MIME part of the generated email.
 const HTMLTemplate = "./template/templates/email_template.html"
 
+// ReportTemplate is the filepath to the template used when rendering a
+// domain's security report as HTML.
+const ReportTemplate = "./template/templates/report.html"
+
+// DashboardLayout is the filepath to the layout shared by every dashboard
+// page.
+const DashboardLayout = "./template/templates/dashboard/layout.html"
+
+// DashboardPath is the directory containing the dashboard page templates.
+const DashboardPath = "./template/templates/dashboard/"
+
 // ExecuteTemplate creates a templated string based on the provided
 // template filename and data.
 func ExecuteTemplate(filename string, data interface{}) (string, error) {
@@ -24,3 +37,28 @@ func ExecuteTemplate(filename string, data interface{}) (string, error) {
 	err = tmpl.Execute(&buff, data)
 	return buff.String(), err
 }
+
+// ExecuteHTMLTemplate is like ExecuteTemplate, but escapes the data for
+// safe inclusion in an HTML page.
+func ExecuteHTMLTemplate(filename string, data interface{}) (string, error) {
+	buff := bytes.Buffer{}
+	tmpl, err := htmltemplate.ParseFiles(filename)
+	if err != nil {
+		return buff.String(), err
+	}
+	err = tmpl.Execute(&buff, data)
+	return buff.String(), err
+}
+
+// ExecuteDashboardTemplate renders the named dashboard page inside the
+// dashboard layout. Pages define a "content" template, which the layout
+// includes.
+func ExecuteDashboardTemplate(name string, data interface{}) (string, error) {
+	buff := bytes.Buffer{}
+	tmpl, err := htmltemplate.ParseFiles(DashboardLayout, filepath.Join(DashboardPath, name))
+	if err != nil {
+		return buff.String(), err
+	}
+	err = tmpl.ExecuteTemplate(&buff, "layout", data)
+	return buff.String(), err
+}
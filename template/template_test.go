@@ -0,0 +1,89 @@
+package template
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Template paths are relative to the repository root
+	err := os.Chdir("..")
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+type testPage struct {
+	Title     string
+	CSRFField string
+	Key       interface{}
+	Error     string
+	Refresh   bool
+	Events    string
+	Data      interface{}
+}
+
+func TestExecuteDashboardTemplate(t *testing.T) {
+	now := time.Now()
+	page := testPage{
+		Title:   "<Test>",
+		Refresh: true,
+		Data: map[string]interface{}{
+			"Domains": []map[string]interface{}{
+				{"DomainHash": "hash", "DisplayName": "example.com", "Verified": true, "CreatedAt": now},
+			},
+			"Scenarios": []map[string]string{{"Name": "baseline", "Description": "A baseline"}},
+		},
+	}
+	html, err := ExecuteDashboardTemplate("index.html", page)
+	if err != nil {
+		t.Fatalf("Unexpected error executing template: %v", err)
+	}
+	if !strings.Contains(html, "&lt;Test&gt;") {
+		t.Fatalf("Title wasn't escaped: %s", html)
+	}
+	if !strings.Contains(html, `http-equiv="refresh"`) {
+		t.Fatalf("Refreshing page doesn't include the refresh header")
+	}
+	if !strings.Contains(html, "/dashboard/domains/hash/") || !strings.Contains(html, "example.com") {
+		t.Fatalf("Domain wasn't rendered: %s", html)
+	}
+}
+
+func TestExecuteDashboardTemplateMissing(t *testing.T) {
+	_, err := ExecuteDashboardTemplate("missing.html", testPage{})
+	if err == nil {
+		t.Fatalf("Didn't receive expected error with a missing template")
+	}
+}
+
+type testMessage struct {
+	link string
+}
+
+func (m testMessage) LinkURL() string {
+	return m.link
+}
+
+func TestExecuteEmailTemplateLink(t *testing.T) {
+	link := "http://malware.testing.google.test/testing/malware/"
+	for _, filename := range []string{TextTemplate, HTMLTemplate} {
+		body, err := ExecuteTemplate(filename, testMessage{})
+		if err != nil {
+			t.Fatalf("Unexpected error executing %s: %v", filename, err)
+		}
+		if strings.Contains(body, "http") {
+			t.Fatalf("Unexpected link in %s: %s", filename, body)
+		}
+		body, err = ExecuteTemplate(filename, testMessage{link: link})
+		if err != nil {
+			t.Fatalf("Unexpected error executing %s: %v", filename, err)
+		}
+		if !strings.Contains(body, link) {
+			t.Fatalf("Link missing from %s: %s", filename, body)
+		}
+	}
+}
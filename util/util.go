@@ -1,15 +1,25 @@
 package util
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
-	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/mail"
 	"strings"
 )
 
+// ErrInvalidCiphertext occurs when a ciphertext can't be decrypted, because
+// it's malformed or it was encrypted using a different key.
+var ErrInvalidCiphertext = errors.New("invalid ciphertext")
+
 // GenerateSecureID creates a secure ID to use
 // as a CSRF key or a Message ID
 func GenerateSecureID(length int) string {
@@ -19,20 +29,76 @@ func GenerateSecureID(length int) string {
 	return fmt.Sprintf("%x", k)
 }
 
-// DomainHashFromAddress returns a byte slice containing the SHA1 hash
-// of the domain in an email address.
-func DomainHashFromAddress(addr string) (string, error) {
-	// Parse out the email domain
+// DomainFromAddress returns the domain part of an email address.
+func DomainFromAddress(addr string) (string, error) {
 	parsed, err := mail.ParseAddress(addr)
 	if err != nil {
 		return "", err
 	}
 	parts := strings.Split(parsed.Address, "@")
 	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid email address: %s", addr)
+	}
+	return parts[1], nil
+}
+
+// IsPublicIP returns whether the address is routable on the internet, as
+// opposed to loopback, link-local, private or unspecified addresses.
+func IsPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsPrivate() || ip.IsUnspecified())
+}
+
+// HMAC returns the hex-encoded HMAC-SHA256 of the message using the
+// provided key.
+func HMAC(key []byte, message string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Encrypt encrypts the plaintext using AES-GCM with the provided key, which
+// must be 16, 24 or 32 bytes long. It returns the base64-encoded nonce
+// followed by the ciphertext.
+func Encrypt(key []byte, plaintext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
 		return "", err
 	}
-	domain := parts[1]
-	// Generate the hash of the domain provided
-	domainHash := sha1.Sum([]byte(domain))
-	return hex.EncodeToString(domainHash[:]), nil
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a ciphertext returned by Encrypt using the same key.
+func Decrypt(key []byte, ciphertext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", ErrInvalidCiphertext
+	}
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+	return string(plaintext), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
 }
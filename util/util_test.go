@@ -1,25 +1,83 @@
 package util
 
 import (
+	"bytes"
+	"net"
+	"strings"
 	"testing"
 )
 
-func TestDomainHashFromAddress(t *testing.T) {
-	address := "test@example.com"
-	expected := "0caaf24ab1a0c33440c06afe99df986365b0781f"
-	got, err := DomainHashFromAddress(address)
+func TestHMAC(t *testing.T) {
+	expected := "f529c5eab6db1f73dde94efa0e29357f0a77ed9b24e46b9e7758e1d12b05876b"
+	got := HMAC([]byte("key"), "example.com")
+	if expected != got {
+		t.Fatalf("Invalid response. Got: %s Expected %s", got, expected)
+	}
+	if HMAC([]byte("other"), "example.com") == got {
+		t.Fatalf("HMAC didn't depend on the key")
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	ciphertext, err := Encrypt(key, "example.com")
 	if err != nil {
 		t.Fatalf("Unexpected error: %s", err.Error())
 	}
-	if expected != got {
-		t.Fatalf("Invalid response. Got: %s Expected %s", got, expected)
+	if strings.Contains(ciphertext, "example") {
+		t.Fatalf("Plaintext found in ciphertext: %s", ciphertext)
+	}
+	got, err := Decrypt(key, ciphertext)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if got != "example.com" {
+		t.Fatalf("Invalid response. Got: %s Expected example.com", got)
+	}
+	_, err = Decrypt(bytes.Repeat([]byte{2}, 32), ciphertext)
+	if err != ErrInvalidCiphertext {
+		t.Fatalf("Unexpected error decrypting with the wrong key. Got %v Expected %v", err, ErrInvalidCiphertext)
 	}
 }
 
 func TestInvalidEmailAddress(t *testing.T) {
 	address := "test"
-	_, err := DomainHashFromAddress(address)
+	_, err := DomainFromAddress(address)
 	if err == nil {
 		t.Fatalf("Didn't receive expected error")
 	}
 }
+
+func TestDomainFromAddress(t *testing.T) {
+	address := "\"Test User\" <test@example.com>"
+	expected := "example.com"
+	got, err := DomainFromAddress(address)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if expected != got {
+		t.Fatalf("Invalid response. Got: %s Expected %s", got, expected)
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	testSuite := map[string]bool{
+		"93.184.216.34": true,
+		"2606:4700::1":  true,
+		"127.0.0.1":     false,
+		"::1":           false,
+		"10.1.2.3":      false,
+		"172.16.0.1":    false,
+		"192.168.0.1":   false,
+		"169.254.1.1":   false,
+		"fe80::1":       false,
+		"fd00::1":       false,
+		"0.0.0.0":       false,
+	}
+	for addr, expected := range testSuite {
+		got := IsPublicIP(net.ParseIP(addr))
+		if got != expected {
+			t.Fatalf("Unexpected result for %s. Expected %v Got %v", addr, expected, got)
+		}
+	}
+}
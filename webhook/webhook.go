@@ -0,0 +1,170 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	log "github.com/gophish/gophish/logger"
+	"github.com/gophish/healthcheck/db"
+	"github.com/gophish/healthcheck/events"
+	"github.com/gophish/healthcheck/util"
+)
+
+// SignatureHeader is the header containing the hex-encoded HMAC-SHA256 of the
+// request body, keyed with the webhook secret.
+const SignatureHeader = "X-Healthcheck-Signature"
+
+// EventHeader is the header containing the type of the delivered event.
+const EventHeader = "X-Healthcheck-Event"
+
+// MaxAttempts is the number of times we try to deliver an event to a webhook
+// before giving up.
+const MaxAttempts = 5
+
+// DefaultTimeout is the timeout for a single delivery attempt.
+const DefaultTimeout = 10 * time.Second
+
+// Worker delivers the published events to the registered webhooks.
+type Worker struct {
+	// Store is where the webhooks are read from and the deliveries are
+	// recorded. It must be set before the worker is started.
+	Store  db.Store
+	Client *http.Client
+	// Backoff is the delay before the first retry. It doubles after every
+	// failed attempt.
+	Backoff time.Duration
+}
+
+// Dispatcher is the global worker used to deliver webhooks
+var Dispatcher = NewWorker()
+
+// NewWorker returns a new webhook worker. Its client refuses to connect to
+// internal addresses, including after redirects or when a webhook's host name
+// resolves to one.
+func NewWorker() *Worker {
+	dialer := &net.Dialer{
+		Timeout: DefaultTimeout,
+		Control: checkAddress,
+	}
+	return &Worker{
+		Client: &http.Client{
+			Timeout:   DefaultTimeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+		},
+		Backoff: 5 * time.Second,
+	}
+}
+
+// checkAddress is called with the resolved address before every connection,
+// failing if it isn't a public address.
+func checkAddress(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !util.IsPublicIP(ip) {
+		return db.ErrForbiddenWebhookAddress
+	}
+	return nil
+}
+
+// Sign returns the signature for the body using the provided secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return fmt.Sprintf("sha256=%s", hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Start delivers events to the matching webhooks until the context is
+// cancelled.
+func (w *Worker) Start(ctx context.Context) {
+	sub := events.Subscribe(events.DefaultBufferSize)
+	defer events.Unsubscribe(sub)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-sub.C:
+			webhooks, err := w.Store.GetEventWebhooks(e.OrgID, e.DomainHash)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			if len(webhooks) == 0 {
+				continue
+			}
+			body, err := json.Marshal(e)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			for _, hook := range webhooks {
+				go w.deliver(ctx, hook, e, body)
+			}
+		}
+	}
+}
+
+// deliver posts the event to the webhook, retrying with an exponential
+// backoff. Every attempt is recorded in the delivery log.
+func (w *Worker) deliver(ctx context.Context, hook db.Webhook, e events.Event, body []byte) {
+	backoff := w.Backoff
+	for attempt := 1; attempt <= MaxAttempts; attempt++ {
+		code, err := w.Post(ctx, hook, e.Type, body)
+		d := &db.WebhookDelivery{
+			WebhookID:  hook.ID,
+			Event:      e.Type,
+			MessageID:  e.MessageID,
+			Attempt:    attempt,
+			StatusCode: code,
+		}
+		if err != nil {
+			d.Error = err.Error()
+		}
+		if dbErr := w.Store.PostWebhookDelivery(d); dbErr != nil {
+			log.Error(dbErr)
+		}
+		if err == nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	log.Errorf("giving up delivering %s event to webhook %d", e.Type, hook.ID)
+}
+
+// Post sends a single signed request to the webhook. Any response other than
+// a 2xx is considered an error.
+func (w *Worker) Post(ctx context.Context, hook db.Webhook, eventType string, body []byte) (int, error) {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, eventType)
+	req.Header.Set(SignatureHeader, Sign(hook.Secret, body))
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
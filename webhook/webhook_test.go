@@ -0,0 +1,149 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gophish/healthcheck/config"
+	"github.com/gophish/healthcheck/db"
+	"github.com/gophish/healthcheck/events"
+)
+
+func setupDB(t *testing.T) *db.GormStore {
+	config.Config.DBName = "sqlite3"
+	config.Config.DBPath = ":memory:"
+	config.Config.MigrationsPath = "../db/sqlite3/migrations/"
+	store, err := db.Setup()
+	if err != nil {
+		t.Fatalf("Failed setting up the database: %s", err.Error())
+	}
+	return store
+}
+
+// testWorker returns a worker which is allowed to reach the test server,
+// since it listens on a loopback address.
+func testWorker(ts *httptest.Server) *Worker {
+	return &Worker{Client: ts.Client(), Backoff: time.Millisecond}
+}
+
+func TestSign(t *testing.T) {
+	expected := "sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	got := Sign("key", []byte("The quick brown fox jumps over the lazy dog"))
+	if got != expected {
+		t.Fatalf("Unexpected signature. Got %s Expected %s", got, expected)
+	}
+}
+
+func TestPost(t *testing.T) {
+	body := []byte(`{"type":"message.sent"}`)
+	secret := "secret"
+	hook := db.Webhook{Secret: secret}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign(secret, got) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get(EventHeader) != events.MessageSent {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	hook.URL = ts.URL
+
+	code, err := testWorker(ts).Post(context.Background(), hook, events.MessageSent, body)
+	if err != nil {
+		t.Fatalf("Unexpected error posting webhook: %v", err)
+	}
+	if code != http.StatusNoContent {
+		t.Fatalf("Unexpected status code. Got %d Expected %d", code, http.StatusNoContent)
+	}
+
+	hook.Secret = "invalid"
+	_, err = testWorker(ts).Post(context.Background(), hook, events.MessageSent, body)
+	if err == nil {
+		t.Fatalf("Didn't receive expected error with an invalid signature")
+	}
+}
+
+func TestPostInternalAddress(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	// The URL is a loopback address, which is refused once it's resolved
+	// even though the webhook wasn't validated
+	hook := db.Webhook{URL: ts.URL, Secret: "secret"}
+	_, err := NewWorker().Post(context.Background(), hook, events.MessageSent, []byte("{}"))
+	if !errors.Is(err, db.ErrForbiddenWebhookAddress) {
+		t.Fatalf("Didn't receive expected error posting to a loopback address. Got: %v", err)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	store := setupDB(t)
+	failures := 2
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	hook := db.Webhook{ID: 1, URL: ts.URL, Secret: "secret"}
+	e := events.Event{Type: events.MessageSent, MessageID: "id"}
+	worker := testWorker(ts)
+	worker.Store = store
+	worker.deliver(context.Background(), hook, e, []byte("{}"))
+
+	deliveries, err := store.GetWebhookDeliveries(hook.ID, MaxAttempts)
+	if err != nil {
+		t.Fatalf("Unexpected error getting deliveries: %v", err)
+	}
+	if len(deliveries) != 3 {
+		t.Fatalf("Unexpected number of deliveries. Expected 3 Got %d", len(deliveries))
+	}
+	// Deliveries are returned most recent first
+	for i, d := range deliveries {
+		attempt := len(deliveries) - i
+		expected := http.StatusServiceUnavailable
+		if attempt == 3 {
+			expected = http.StatusNoContent
+		}
+		if d.Attempt != attempt || d.StatusCode != expected || d.Event != e.Type || d.MessageID != e.MessageID {
+			t.Fatalf("Unexpected delivery for attempt %d: %#v", attempt, d)
+		}
+		if (d.Error == "") != (expected == http.StatusNoContent) {
+			t.Fatalf("Unexpected error for attempt %d: %q", attempt, d.Error)
+		}
+	}
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	store := setupDB(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+	hook := db.Webhook{ID: 2, URL: ts.URL, Secret: "secret"}
+	worker := testWorker(ts)
+	worker.Store = store
+	worker.deliver(context.Background(), hook, events.Event{Type: events.MessageSent}, []byte("{}"))
+
+	deliveries, err := store.GetWebhookDeliveries(hook.ID, 2*MaxAttempts)
+	if err != nil {
+		t.Fatalf("Unexpected error getting deliveries: %v", err)
+	}
+	if len(deliveries) != MaxAttempts {
+		t.Fatalf("Unexpected number of deliveries. Expected %d Got %d", MaxAttempts, len(deliveries))
+	}
+}